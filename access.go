@@ -0,0 +1,193 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released under
+// the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for details.
+// Resist intellectual serfdom - the ownership of ideas is akin to slavery.
+
+package neoism
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// ErrReadOnly is returned, wrapped in a *url.Error, for REST API calls which
+// would write through a read-only Database.  See ReadOnly.
+var ErrReadOnly = errors.New("Database is read-only")
+
+// An AccessMode tells whether a query or transaction writes.
+type AccessMode int
+
+const (
+	// AccessDefault leaves the mode of a query to Classify.
+	AccessDefault AccessMode = iota
+	AccessRead
+	AccessWrite
+)
+
+func (m AccessMode) String() string {
+	switch m {
+	case AccessRead:
+		return "READ"
+	case AccessWrite:
+		return "WRITE"
+	}
+	return "DEFAULT"
+}
+
+// An AccessModeError is returned for a query which writes, or may write,
+// although it was declared read-only, or is executed in a read-only
+// transaction or through a read-only Database.  If Updated is false, the
+// query was refused before it was sent; if true, the server reported that it
+// wrote, and the write has taken effect unless its transaction is rolled
+// back.
+type AccessModeError struct {
+	Statement string
+	Updated   bool
+}
+
+func (e *AccessModeError) Error() string {
+	if e.Updated {
+		return fmt.Sprintf("Read-only query wrote to the database: %s", e.Statement)
+	}
+	return fmt.Sprintf("Query may write to a read-only database: %s", e.Statement)
+}
+
+// Unwrap returns ErrReadOnly, so errors.Is(err, ErrReadOnly) holds for an
+// *AccessModeError.
+func (e *AccessModeError) Unwrap() error {
+	return ErrReadOnly
+}
+
+// AccessMode returns the mode declared in the query's Mode, or if that is
+// AccessDefault, the one Classify returns for its statement.
+func (cq *CypherQuery) AccessMode() AccessMode {
+	if cq.Mode != AccessDefault {
+		return cq.Mode
+	}
+	return Classify(cq.Statement)
+}
+
+var (
+	writeClauseRegex = regexp.MustCompile(`(?i)\b(CREATE|MERGE|SET|DELETE|REMOVE|DROP|FOREACH|LOAD\s+CSV|GRANT|REVOKE|DENY|ALTER|RENAME|(START|STOP)\s+DATABASE|IN\s+TRANSACTIONS)\b`)
+	procedureRegex   = regexp.MustCompile(`(?i)\bCALL\s+([\w.]+)`)
+)
+
+// readProcedures are procedures known not to write.
+var readProcedures = map[string]bool{
+	"db.labels":                    true,
+	"db.relationshiptypes":         true,
+	"db.propertykeys":              true,
+	"db.indexes":                   true,
+	"db.constraints":               true,
+	"db.schema":                    true,
+	"db.schema.visualization":      true,
+	"db.schema.nodetypeproperties": true,
+	"db.schema.reltypeproperties":  true,
+	"dbms.components":              true,
+	"dbms.procedures":              true,
+	"dbms.functions":               true,
+	"dbms.cluster.role":            true,
+	"dbms.cluster.overview":        true,
+}
+
+// Classify guesses the access mode of a statement: AccessWrite if it has a
+// clause which writes, such as CREATE, MERGE, SET, DELETE or REMOVE, an
+// administrative command, such as GRANT, ALTER or STOP DATABASE, a subquery
+// run IN TRANSACTIONS, or calls a procedure other than a few known to only
+// read, such as db.labels(); otherwise AccessRead.  Keywords in string literals and comments are
+// ignored.  When in doubt, Classify returns AccessWrite.
+func Classify(stmt string) AccessMode {
+	fp := Fingerprint(stmt)
+	if writeClauseRegex.MatchString(fp) {
+		return AccessWrite
+	}
+	for _, m := range procedureRegex.FindAllStringSubmatch(fp, -1) {
+		if !readProcedures[strings.ToLower(m[1])] {
+			return AccessWrite
+		}
+	}
+	return AccessRead
+}
+
+// ReadOnly returns a view of db which refuses writes client-side: queries
+// whose AccessMode is AccessWrite fail with an *AccessModeError, REST API
+// calls which would write fail with ErrReadOnly, and transactions begun
+// through it have the mode AccessRead.  The view shares its connection and
+// settings with db.
+func (db *Database) ReadOnly() *Database {
+	d := *db
+	d.readOnly = true
+	s := *db.Session
+	c := *s.Client
+	c.Transport = &readOnlyTransport{next: c.Transport}
+	s.Client = &c
+	d.Session = &s
+	return &d
+}
+
+// checkAccess refuses qs client-side if any may write and mode is
+// AccessRead, calls f to execute them, and then checks the statistics the
+// server reported, if any, against mode and the modes declared by the
+// queries.
+func checkAccess(qs []*CypherQuery, mode AccessMode, f func() error) error {
+	if mode == AccessRead {
+		for _, q := range qs {
+			if q.AccessMode() == AccessWrite {
+				return &AccessModeError{Statement: q.Statement}
+			}
+		}
+	}
+	err := f()
+	if err != nil {
+		return err
+	}
+	for _, q := range qs {
+		if q.stats != nil && q.stats.ContainsUpdates && (mode == AccessRead || q.Mode == AccessRead) {
+			return &AccessModeError{Statement: q.Statement, Updated: true}
+		}
+	}
+	return nil
+}
+
+// accessMode returns the mode of queries executed through db outside a
+// transaction.
+func (db *Database) accessMode() AccessMode {
+	if db.readOnly {
+		return AccessRead
+	}
+	return AccessDefault
+}
+
+// A readOnlyTransport refuses requests which would write through the REST
+// API.  Requests to the Cypher, batch and transactional endpoints pass, as
+// the queries they carry have been checked already.
+type readOnlyTransport struct {
+	next http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *readOnlyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	switch endpoint(req.URL.Path) {
+	case EndpointCypher, EndpointBatch, EndpointTransaction:
+		return t.next.RoundTrip(req)
+	}
+	if req.Method != "GET" && req.Method != "HEAD" {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, ErrReadOnly
+	}
+	return t.next.RoundTrip(req)
+}
+
+// CloseIdleConnections closes idle connections of the underlying transport.
+func (t *readOnlyTransport) CloseIdleConnections() {
+	type closeIdler interface {
+		CloseIdleConnections()
+	}
+	if c, ok := t.next.(closeIdler); ok {
+		c.CloseIdleConnections()
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released under
+// the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for details.
+// Resist intellectual serfdom - the ownership of ideas is akin to slavery.
+
+package neoism
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/jmcvetta/neoism/neoismtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassify(t *testing.T) {
+	cases := map[string]AccessMode{
+		"MATCH (n:Person) RETURN n.name":              AccessRead,
+		"MATCH (n) WHERE n.name = 'CREATE' RETURN n":  AccessRead,
+		"MATCH (n) // DELETE n\nRETURN n":             AccessRead,
+		"CALL db.labels()":                            AccessRead,
+		"CALL db.labels() YIELD label RETURN label":   AccessRead,
+		"CREATE (n:Person)":                           AccessWrite,
+		"MATCH (n) detach delete n":                   AccessWrite,
+		"MATCH (n) SET n.x = 1":                       AccessWrite,
+		"MATCH (n) REMOVE n:Person":                   AccessWrite,
+		"MERGE (n {id: 1})":                           AccessWrite,
+		"CALL apoc.periodic.iterate('a', 'b', {})":    AccessWrite,
+		"CALL apoc.create.node(['Person'], {})":       AccessWrite,
+		"CALL my.unknown.procedure()":                 AccessWrite,
+		"SHOW USERS":                                  AccessRead,
+		"GRANT ROLE reader TO kirk":                   AccessWrite,
+		"REVOKE ROLE reader FROM kirk":                AccessWrite,
+		"DENY WRITE ON GRAPH * TO reader":             AccessWrite,
+		"ALTER USER kirk SET PASSWORD 'x'":            AccessWrite,
+		"RENAME ROLE reader TO viewer":                AccessWrite,
+		"START DATABASE movies":                       AccessWrite,
+		"STOP DATABASE movies":                        AccessWrite,
+		"START n=node(1) RETURN n":                    AccessRead,
+		"CALL { MATCH (n) RETURN n } IN TRANSACTIONS": AccessWrite,
+	}
+	for stmt, exp := range cases {
+		assert.Equal(t, exp, Classify(stmt), stmt)
+	}
+	q := CypherQuery{Statement: "CREATE (n)", Mode: AccessRead}
+	assert.Equal(t, AccessRead, q.AccessMode())
+	q = CypherQuery{Statement: "MATCH (n) RETURN n", Mode: AccessWrite}
+	assert.Equal(t, AccessWrite, q.AccessMode())
+}
+
+func TestReadOnly(t *testing.T) {
+	fake := neoismtest.NewServer()
+	defer fake.Close()
+	fake.HandleCypher("^MATCH", neoismtest.CypherResponse{})
+	fake.HandleCypher("^CALL my.cleanup", neoismtest.CypherResponse{
+		Stats: map[string]interface{}{"contains_updates": true, "nodes_deleted": 1},
+	})
+	db, err := Connect(fake.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := db.CreateNode(Props{"name": "Kirk"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ro := db.ReadOnly()
+	//
+	// Writes are refused before they are sent.
+	//
+	err = ro.Cypher(&CypherQuery{Statement: "CREATE (n)"})
+	if ae, ok := err.(*AccessModeError); assert.True(t, ok, "%v", err) {
+		assert.False(t, ae.Updated)
+	}
+	assert.True(t, errors.Is(err, ErrReadOnly))
+	err = ro.CypherBatch([]*CypherQuery{{Statement: "MATCH (n) RETURN n"}, {Statement: "MERGE (n)"}})
+	assert.IsType(t, &AccessModeError{}, err)
+	_, err = ro.Begin([]*CypherQuery{{Statement: "SET n.x = 1"}})
+	assert.IsType(t, &AccessModeError{}, err)
+	_, err = ro.CreateNode(Props{})
+	assert.True(t, errors.Is(err, ErrReadOnly), "%v", err)
+	assert.Equal(t, []string{}, fake.Statements())
+	//
+	// Reads pass.
+	//
+	assert.Nil(t, ro.Cypher(&CypherQuery{Statement: "MATCH (n) RETURN n"}))
+	m, err := ro.Node(n.Id())
+	assert.Nil(t, err)
+	err = m.SetProperty("name", "Spock")
+	assert.True(t, errors.Is(err, ErrReadOnly), "%v", err)
+	tx, err := ro.Begin([]*CypherQuery{{Statement: "MATCH (n) RETURN n"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, AccessRead, tx.Mode)
+	err = tx.Query([]*CypherQuery{{Statement: "CREATE (n)"}})
+	assert.IsType(t, &AccessModeError{}, err)
+	assert.Nil(t, tx.Rollback())
+	//
+	// A query declared read-only which the server reports to have written
+	// fails.
+	//
+	cq := CypherQuery{Statement: "CALL my.cleanup()", Mode: AccessRead, IncludeStats: true}
+	err = db.Cypher(&cq)
+	if ae, ok := err.(*AccessModeError); assert.True(t, ok, "%v", err) {
+		assert.True(t, ae.Updated)
+	}
+	cq = CypherQuery{Statement: "CALL my.cleanup()", IncludeStats: true}
+	assert.Nil(t, db.Cypher(&cq))
+	_, err = db.CreateNode(Props{})
+	assert.Nil(t, err)
+}
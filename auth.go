@@ -0,0 +1,306 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released under
+// the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for details.
+// Resist intellectual serfdom - the ownership of ideas is akin to slavery.
+
+package neoism
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+// Authentication schemes.
+const (
+	SchemeNone     = "none"
+	SchemeBasic    = "basic"
+	SchemeBearer   = "bearer"
+	SchemeKerberos = "kerberos"
+)
+
+// An AuthToken holds the credentials neoism authenticates with.  Over HTTP,
+// only the basic and bearer schemes are supported; over Bolt, any scheme the
+// server accepts, including custom ones.
+type AuthToken struct {
+	Scheme      string
+	Principal   string
+	Credentials string
+	Realm       string                 // Sent over Bolt only
+	Parameters  map[string]interface{} // For custom schemes; sent over Bolt only
+}
+
+// BasicAuth returns a token for authenticating with a username and password.
+func BasicAuth(username, password string) AuthToken {
+	return AuthToken{Scheme: SchemeBasic, Principal: username, Credentials: password}
+}
+
+// BearerAuth returns a token for authenticating with a bearer token, such as
+// one issued by a single sign-on provider to Neo4j 4.4 and later.
+func BearerAuth(token string) AuthToken {
+	return AuthToken{Scheme: SchemeBearer, Credentials: token}
+}
+
+// KerberosAuth returns a token for authenticating with a base64 encoded
+// Kerberos ticket, over Bolt.
+func KerberosAuth(ticket string) AuthToken {
+	return AuthToken{Scheme: SchemeKerberos, Credentials: ticket}
+}
+
+// header returns the Authorization header for the token.
+func (t AuthToken) header() (string, error) {
+	switch t.Scheme {
+	case SchemeNone, "":
+		return "", nil
+	case SchemeBasic:
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(t.Principal+":"+t.Credentials)), nil
+	case SchemeBearer:
+		return "Bearer " + t.Credentials, nil
+	}
+	return "", fmt.Errorf("Authentication scheme %q is not supported over HTTP", t.Scheme)
+}
+
+// bolt returns the token in the form sent in Bolt's INIT or HELLO message.
+func (t AuthToken) bolt() map[string]interface{} {
+	if t.Scheme == "" || t.Scheme == SchemeNone {
+		return map[string]interface{}{"scheme": SchemeNone}
+	}
+	m := map[string]interface{}{
+		"scheme":      t.Scheme,
+		"credentials": t.Credentials,
+	}
+	if t.Principal != "" || t.Scheme == SchemeBasic {
+		m["principal"] = t.Principal
+	}
+	if t.Realm != "" {
+		m["realm"] = t.Realm
+	}
+	if len(t.Parameters) > 0 {
+		m["parameters"] = t.Parameters
+	}
+	return m
+}
+
+// equal reports whether two tokens carry the same credentials.
+func (t AuthToken) equal(u AuthToken) bool {
+	return t.Scheme == u.Scheme && t.Principal == u.Principal &&
+		t.Credentials == u.Credentials && t.Realm == u.Realm
+}
+
+// authState holds the credentials provider of a database.  It is shared by
+// copies of the Database.
+type authState struct {
+	mu       sync.Mutex
+	provider CredentialsProvider // Nil if no credentials were given
+}
+
+func (a *authState) getProvider() CredentialsProvider {
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.provider
+}
+
+func (a *authState) setProvider(p CredentialsProvider) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.provider = p
+}
+
+func (a *authState) set(t AuthToken) {
+	a.setProvider(StaticCredentials(t))
+}
+
+// get returns the current credentials.
+func (a *authState) get() (AuthToken, error) {
+	p := a.getProvider()
+	if p == nil {
+		return AuthToken{Scheme: SchemeNone}, nil
+	}
+	return p.Credentials(context.Background())
+}
+
+// authorize returns req with its Authorization header set from the current
+// credentials, and the token used.  If no credentials were given, req is
+// returned unchanged, so credentials set on the session or the URL still
+// apply, and the token is nil.
+func (a *authState) authorize(req *http.Request) (*http.Request, *AuthToken, error) {
+	p := a.getProvider()
+	if p == nil {
+		return req, nil, nil
+	}
+	t, err := p.Credentials(req.Context())
+	if err != nil {
+		return nil, nil, err
+	}
+	h, err := t.header()
+	if err != nil {
+		return nil, nil, err
+	}
+	req = req.Clone(req.Context())
+	if h == "" {
+		req.Header.Del("Authorization")
+	} else {
+		req.Header.Set("Authorization", h)
+	}
+	return req, &t, nil
+}
+
+// renew is called when the server refuses the credentials used.  It reports
+// whether the provider now supplies different ones, with which the request
+// is worth retrying.
+func (a *authState) renew(used *AuthToken) bool {
+	p := a.getProvider()
+	if p == nil || used == nil {
+		return false
+	}
+	if inv, ok := p.(invalidator); ok {
+		inv.Invalidate()
+	}
+	t, err := p.Credentials(context.Background())
+	return err == nil && !t.equal(*used)
+}
+
+// SetAuth replaces the credentials db authenticates with, for db and all
+// copies of it, such as those returned by Use and ReadOnly.  Over Bolt,
+// connections already open stay authenticated with the old credentials.
+func (db *Database) SetAuth(t AuthToken) error {
+	if db.bolt == nil {
+		if _, err := t.header(); err != nil {
+			return err
+		}
+	}
+	db.auth.set(t)
+	return nil
+}
+
+// SetCredentialsProvider makes db, and all copies of it, authenticate with
+// the credentials supplied by p.  See CredentialsProvider.
+func (db *Database) SetCredentialsProvider(p CredentialsProvider) {
+	db.auth.setProvider(p)
+}
+
+// username returns the principal db authenticates as, if known.
+func (db *Database) username() string {
+	t, _ := db.auth.get()
+	return t.Principal
+}
+
+// A PasswordChangeRequiredError is returned by Connect when the server
+// requires the user to change their password before doing anything else, as
+// Neo4j 2.2 and later do for the initial password.
+type PasswordChangeRequiredError struct {
+	Username string
+	db       *Database
+	url      url.URL // Connect again to this URL after changing the password
+}
+
+func (e *PasswordChangeRequiredError) Error() string {
+	return fmt.Sprintf("User %s is required to change their password", e.Username)
+}
+
+// ChangePassword changes the password and completes the connection.
+func (e *PasswordChangeRequiredError) ChangePassword(newPassword string) (*Database, error) {
+	err := e.db.ChangePassword(newPassword)
+	if err != nil {
+		return nil, err
+	}
+	if e.db.bolt != nil {
+		return e.db, nil
+	}
+	u := e.url
+	return connectWithRetry(e.db, &u, 0)
+}
+
+// credentialsExpired is the code of errors returned by Neo4j 4.0 and later to
+// users who must change their password.
+const credentialsExpired = "Neo.ClientError.Security.CredentialsExpired"
+
+// ChangePassword changes the password of the user db authenticates as, and
+// authenticates with the new password from then on.  Only users
+// authenticating with the basic scheme have a password to change.  If the
+// credentials come from a CredentialsProvider other than StaticCredentials,
+// the provider must supply the new password from then on.
+func (db *Database) ChangePassword(newPassword string) error {
+	t, err := db.auth.get()
+	if err != nil {
+		return err
+	}
+	if t.Scheme != SchemeBasic {
+		return errors.New("Passwords can only be changed when using basic authentication")
+	}
+	switch {
+	case db.Capabilities().MultiDatabase:
+		system, _ := db.Use("system")
+		cq := CypherQuery{
+			Statement: "ALTER CURRENT USER SET PASSWORD FROM $old TO $new",
+			Parameters: map[string]interface{}{
+				"old": t.Credentials,
+				"new": newPassword,
+			},
+			secret: true,
+		}
+		err = system.Cypher(&cq)
+	case db.bolt != nil:
+		cq := CypherQuery{
+			Statement:  "CALL dbms.security.changePassword($password)",
+			Parameters: map[string]interface{}{"password": newPassword},
+			secret:     true,
+		}
+		err = db.Cypher(&cq)
+	default:
+		err = db.changePasswordREST(t.Principal, newPassword)
+	}
+	if err != nil {
+		return err
+	}
+	if _, ok := db.auth.getProvider().(*staticCredentials); ok {
+		t.Credentials = newPassword
+		db.auth.set(t)
+	}
+	return nil
+}
+
+// authResponse is the body of responses from the user REST API, and of
+// authentication failures.
+type authResponse struct {
+	PasswordChange string    `json:"password_change"`
+	Errors         []TxError `json:"errors"`
+}
+
+func (r *authResponse) err() error {
+	if len(r.Errors) > 0 {
+		return &r.Errors[0]
+	}
+	return errors.New("Unexpected response from the user REST API")
+}
+
+// changePasswordREST changes a password using the user REST API of Neo4j
+// 2.2 to 3.x.
+func (db *Database) changePasswordREST(username, newPassword string) error {
+	payload := map[string]string{"password": newPassword}
+	ar := authResponse{}
+	resp, err := db.Session.Post(db.hrefUser(username, "password"), &payload, nil, &ar)
+	if err != nil {
+		return err
+	}
+	if resp.Status() != 200 {
+		return ar.err()
+	}
+	return nil
+}
+
+// hrefUser returns the URL of a user in the user REST API.
+func (db *Database) hrefUser(username string, parts ...string) string {
+	u, err := url.Parse(db.Url)
+	if err != nil {
+		return ""
+	}
+	root := u.Scheme + "://" + u.Host
+	return join(append([]string{root, "user", url.PathEscape(username)}, parts...)...)
+}
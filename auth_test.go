@@ -0,0 +1,190 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released under
+// the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for details.
+// Resist intellectual serfdom - the ownership of ideas is akin to slavery.
+
+package neoism
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jmcvetta/neoism/neoismtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnectAuth(t *testing.T) {
+	fake := neoismtest.NewServer()
+	defer fake.Close()
+	fake.SetUser("neo4j", "secret", false)
+	fake.SetBearerToken("token")
+	withUser := func(userinfo string) string {
+		return strings.Replace(fake.URL, "://", "://"+userinfo+"@", 1)
+	}
+	_, err := Connect(fake.URL)
+	assert.Equal(t, Unauthorized, err)
+	_, err = Connect(withUser("neo4j:wrong"))
+	assert.Equal(t, Unauthorized, err)
+	db, err := Connect(withUser("neo4j:secret"))
+	if assert.Nil(t, err) {
+		_, err = db.CreateNode(Props{})
+		assert.Nil(t, err)
+	}
+	db, err = ConnectWithAuth(fake.URL, nil, BasicAuth("neo4j", "secret"))
+	assert.Nil(t, err)
+	db, err = ConnectWithAuth(fake.URL, nil, BearerAuth("token"))
+	if assert.Nil(t, err) {
+		_, err = db.CreateNode(Props{})
+		assert.Nil(t, err)
+		assert.NotNil(t, db.SetAuth(KerberosAuth("ticket")))
+		assert.Nil(t, db.SetAuth(BearerAuth("other")))
+		_, err = db.CreateNode(Props{})
+		assert.NotNil(t, err)
+	}
+	_, err = ConnectWithAuth(fake.URL, nil, KerberosAuth("ticket"))
+	assert.NotNil(t, err)
+}
+
+func TestChangePassword(t *testing.T) {
+	fake := neoismtest.NewServer()
+	defer fake.Close()
+	fake.SetUser("neo4j", "neo4j", true)
+	uri := strings.Replace(fake.URL, "://", "://neo4j:neo4j@", 1)
+	_, err := Connect(uri)
+	pe, ok := err.(*PasswordChangeRequiredError)
+	if !assert.True(t, ok, "%v", err) {
+		return
+	}
+	assert.Equal(t, "neo4j", pe.Username)
+	_, err = pe.ChangePassword("neo4j")
+	assert.IsType(t, &TxError{}, err)
+	db, err := pe.ChangePassword("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "secret", fake.Password())
+	u, err := db.User("neo4j")
+	if assert.Nil(t, err) {
+		assert.Equal(t, "neo4j", u.Username)
+		assert.False(t, u.PasswordChangeRequired)
+	}
+	_, err = db.User("nobody")
+	assert.Equal(t, NotFound, err)
+	//
+	// After a change, the new password is used.
+	//
+	assert.Nil(t, db.ChangePassword("newer"))
+	assert.Equal(t, "newer", fake.Password())
+	_, err = db.CreateNode(Props{})
+	assert.Nil(t, err)
+	_, err = Connect(uri)
+	assert.Equal(t, Unauthorized, err)
+}
+
+func TestSecurityProcedures(t *testing.T) {
+	fake := neoismtest.NewServer()
+	defer fake.Close()
+	var params map[string]interface{}
+	fake.HandleCypherFunc(`^CALL dbms\.security\.(createUser|addRoleToUser)`, func(stmt string, p map[string]interface{}) neoismtest.CypherResponse {
+		params = p
+		return neoismtest.CypherResponse{}
+	})
+	fake.HandleCypher(`^CALL dbms\.security\.listUsers`, neoismtest.CypherResponse{
+		Columns: []string{"username", "roles", "flags"},
+		Rows: [][]interface{}{
+			{"neo4j", []string{"admin"}, []string{}},
+			{"kirk", []string{}, []string{"password_change_required"}},
+		},
+	})
+	db, err := Connect(fake.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Hooks never see passwords, even without a Redactor.
+	seen := []string{}
+	db.AddHook(HookFunc(func(e *Event) {
+		if e.Kind == QueryEvent {
+			seen = append(seen, e.Statement)
+		}
+	}))
+	assert.Nil(t, db.CreateUser("kirk", "enterprise", true))
+	assert.Equal(t, map[string]interface{}{
+		"username":              "kirk",
+		"password":              "enterprise",
+		"requirePasswordChange": true,
+	}, params)
+	assert.Nil(t, db.AddUserRole("kirk", "admin"))
+	assert.Equal(t, "admin", params["role"])
+	if assert.Len(t, seen, 1) {
+		assert.True(t, strings.HasPrefix(seen[0], "CALL dbms.security.addRoleToUser"), seen[0])
+	}
+	users, err := db.Users()
+	if assert.Nil(t, err) && assert.Equal(t, 2, len(users)) {
+		assert.Equal(t, []string{"admin"}, users[0].Roles)
+		assert.True(t, users[1].PasswordChangeRequired)
+	}
+}
+
+func TestSecurityCommands(t *testing.T) {
+	fake := neoismtest.NewServerVersion("5.13.0")
+	defer fake.Close()
+	fake.HandleCypher(`^SHOW DEFAULT DATABASE`, neoismtest.CypherResponse{
+		Columns: []string{"name"},
+		Rows:    [][]interface{}{{"neo4j"}},
+	})
+	var params map[string]interface{}
+	fake.HandleCypherFunc(`^(CREATE|DROP) USER|^(GRANT|REVOKE) ROLE`, func(stmt string, p map[string]interface{}) neoismtest.CypherResponse {
+		params = p
+		return neoismtest.CypherResponse{}
+	})
+	fake.HandleCypher(`^SHOW USERS`, neoismtest.CypherResponse{
+		Columns: []string{"user", "roles", "passwordChangeRequired", "suspended", "home"},
+		Rows: [][]interface{}{
+			{"neo4j", []string{"admin", "PUBLIC"}, false, false, nil},
+			{"kirk", []string{"PUBLIC"}, true, true, nil},
+		},
+	})
+	fake.HandleCypher(`^SHOW ROLES`, neoismtest.CypherResponse{
+		Columns: []string{"role"},
+		Rows:    [][]interface{}{{"admin"}, {"PUBLIC"}},
+	})
+	db, err := Connect(fake.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, db.CreateUser("kirk", "enterprise", true))
+	assert.Equal(t, map[string]interface{}{"password": "enterprise"}, params)
+	assert.Nil(t, db.CreateUser("spock", "vulcan", false))
+	assert.Nil(t, db.AddUserRole("kirk", "admin"))
+	assert.Nil(t, db.RemoveUserRole("kirk", "admin"))
+	assert.Nil(t, db.DeleteUser("odd`name"))
+	roles, err := db.Roles()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"admin", "PUBLIC"}, roles)
+	users, err := db.Users()
+	if assert.Nil(t, err) && assert.Equal(t, 2, len(users)) {
+		assert.Equal(t, "kirk", users[1].Username)
+		assert.Equal(t, []string{"PUBLIC"}, users[1].Roles)
+		assert.True(t, users[1].PasswordChangeRequired)
+		assert.Equal(t, []string{"is_suspended"}, users[1].Flags)
+	}
+	u, err := db.User("neo4j")
+	if assert.Nil(t, err) {
+		assert.Equal(t, []string{"admin", "PUBLIC"}, u.Roles)
+	}
+	stmts := []string{}
+	for _, s := range fake.Statements() {
+		if !strings.HasPrefix(s, "SHOW DEFAULT") {
+			stmts = append(stmts, s)
+		}
+	}
+	assert.Equal(t, []string{
+		"CREATE USER `kirk` SET PASSWORD $password CHANGE REQUIRED",
+		"CREATE USER `spock` SET PASSWORD $password CHANGE NOT REQUIRED",
+		"GRANT ROLE `admin` TO `kirk`",
+		"REVOKE ROLE `admin` FROM `kirk`",
+		"DROP USER `odd``name`",
+		"SHOW ROLES",
+		"SHOW USERS",
+		"SHOW USERS",
+	}, stmts)
+}
@@ -0,0 +1,606 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released under
+// the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for details.
+// Resist intellectual serfdom - the ownership of ideas is akin to slavery.
+
+package neoism
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// A boltResponse is the stub server's scripted response to a statement.
+type boltResponse struct {
+	fields  []string
+	records [][]interface{}
+	meta    map[string]interface{} // Sent with the PULL_ALL summary
+	err     *boltError
+	delay   time.Duration // Before answering RUN
+}
+
+// A boltStub is an in-process server speaking the Bolt protocol, answering
+// RUN messages with scripted responses.  Its version is encoded as in the
+// handshake, e.g. 0x0404 for 4.4.
+type boltStub struct {
+	ln        net.Listener
+	version   uint32
+	mu        sync.Mutex
+	responses map[string]boltResponse
+	log       []string
+	meta      []map[string]interface{} // Sent with BEGIN and RUN
+	auth      map[string]interface{}
+}
+
+func newBoltStub(t *testing.T, version uint32) *boltStub {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &boltStub{
+		ln:        ln,
+		version:   version,
+		responses: map[string]boltResponse{},
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *boltStub) url(userinfo string) string {
+	return "bolt://" + userinfo + s.ln.Addr().String()
+}
+
+func (s *boltStub) close() {
+	s.ln.Close()
+}
+
+func (s *boltStub) handle(stmt string, r boltResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.responses[stmt] = r
+}
+
+func (s *boltStub) record(entry string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.log = append(s.log, entry)
+}
+
+func (s *boltStub) messages() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.log...)
+}
+
+func (s *boltStub) recordMeta(meta map[string]interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.meta = append(s.meta, meta)
+}
+
+func (s *boltStub) metadata() []map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]map[string]interface{}{}, s.meta...)
+}
+
+// offers reports whether the handshake entry v, which may be a range of
+// minor versions, includes the stub's version.
+func (s *boltStub) offers(v uint32) bool {
+	major, minor, span := v&0xFF, v>>8&0xFF, v>>16&0xFF
+	return s.version&0xFF == major && s.version>>8 <= minor && s.version>>8+span >= minor
+}
+
+func (s *boltStub) serve(conn net.Conn) {
+	defer conn.Close()
+	hs := make([]uint32, 5)
+	if binary.Read(conn, binary.BigEndian, hs) != nil || hs[0] != boltMagic {
+		return
+	}
+	version := uint32(0)
+	for _, v := range hs[1:] {
+		if version == 0 && s.offers(v) {
+			version = s.version
+		}
+	}
+	binary.Write(conn, binary.BigEndian, version)
+	c := &boltConn{
+		conn:    conn,
+		r:       bufio.NewReader(conn),
+		w:       bufio.NewWriter(conn),
+		version: version & 0xFF,
+	}
+	failed := false
+	var pending *boltResponse
+	for {
+		msg, err := c.recv()
+		if err != nil {
+			return
+		}
+		if failed && msg.Signature != boltReset {
+			c.send(boltIgnored)
+			continue
+		}
+		switch msg.Signature {
+		case boltInit:
+			auth, _ := msg.Fields[len(msg.Fields)-1].(map[string]interface{})
+			s.mu.Lock()
+			s.auth = auth
+			s.mu.Unlock()
+			if auth["credentials"] == "wrong" {
+				c.send(boltFailure, map[string]interface{}{
+					"code":    "Neo.ClientError.Security.Unauthorized",
+					"message": "The client is unauthorized due to authentication failure.",
+				})
+				continue
+			}
+			meta := map[string]interface{}{"server": "Neo4j/3.5.0"}
+			if c.version >= 4 {
+				meta["server"] = "Neo4j/4.4.0"
+			}
+			if auth["credentials"] == "expired" {
+				meta["credentials_expired"] = true
+			}
+			c.send(boltSuccess, meta)
+		case boltRun:
+			stmt := msg.Fields[0].(string)
+			s.record("RUN " + stmt)
+			if len(msg.Fields) > 2 {
+				meta, _ := msg.Fields[2].(map[string]interface{})
+				s.recordMeta(meta)
+			}
+			s.mu.Lock()
+			r, ok := s.responses[stmt]
+			s.mu.Unlock()
+			switch {
+			case stmt == "BEGIN" || stmt == "COMMIT" || stmt == "ROLLBACK":
+				r = boltResponse{}
+			case !ok:
+				r = boltResponse{err: &boltError{
+					Code:    "Neo.ClientError.Statement.SyntaxError",
+					Message: "Invalid input",
+				}}
+			}
+			if r.err != nil {
+				failed = true
+				c.send(boltFailure, map[string]interface{}{"code": r.err.Code, "message": r.err.Message})
+				continue
+			}
+			fields := []interface{}{}
+			for _, f := range r.fields {
+				fields = append(fields, f)
+			}
+			pending = &r
+			time.Sleep(r.delay)
+			c.send(boltSuccess, map[string]interface{}{"fields": fields})
+		case boltPullAll:
+			if c.version >= 4 {
+				if len(msg.Fields) != 1 {
+					return // PULL takes the number of records
+				}
+				meta, _ := msg.Fields[0].(map[string]interface{})
+				if meta["n"] != int64(-1) {
+					return
+				}
+			}
+			for _, rec := range pending.records {
+				c.send(boltRecord, rec)
+			}
+			meta := pending.meta
+			if meta == nil {
+				meta = map[string]interface{}{}
+			}
+			c.send(boltSuccess, meta)
+		case boltBegin:
+			s.record("BEGIN")
+			meta, _ := msg.Fields[0].(map[string]interface{})
+			s.recordMeta(meta)
+			c.send(boltSuccess, map[string]interface{}{})
+		case boltCommit:
+			s.record("COMMIT")
+			c.send(boltSuccess, map[string]interface{}{})
+		case boltRollback:
+			s.record("ROLLBACK")
+			c.send(boltSuccess, map[string]interface{}{})
+		case boltReset:
+			s.record("RESET")
+			failed = false
+			c.send(boltSuccess, map[string]interface{}{})
+		case boltGoodbye:
+			return
+		}
+		c.w.Flush()
+	}
+}
+
+func node(id int64, labels []interface{}, props map[string]interface{}) *psStruct {
+	return &psStruct{Signature: psNode, Fields: []interface{}{id, labels, props}}
+}
+
+func TestBoltCypher(t *testing.T) {
+	for _, version := range []uint32{1, 3} {
+		s := newBoltStub(t, version)
+		db, err := Connect(s.url("neo4j:secret@"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, "3.5.0", db.Version)
+		assert.Equal(t, "secret", s.auth["credentials"])
+		s.handle("MATCH (n:Person) RETURN n, n.age", boltResponse{
+			fields: []string{"n", "n.age"},
+			records: [][]interface{}{
+				{node(1, []interface{}{"Person"}, map[string]interface{}{"name": "Kirk"}), int64(34)},
+				{node(2, []interface{}{"Person"}, map[string]interface{}{"name": "McCoy"}), int64(41)},
+			},
+			meta: map[string]interface{}{"stats": map[string]interface{}{"nodes-created": int64(2)}},
+		})
+		res := []struct {
+			N   Props `json:"n"`
+			Age int   `json:"n.age"`
+		}{}
+		cq := CypherQuery{
+			Statement:    "MATCH (n:Person) RETURN n, n.age",
+			Parameters:   map[string]interface{}{"name": "Kirk"},
+			Result:       &res,
+			IncludeStats: true,
+		}
+		err = db.Cypher(&cq)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"n", "n.age"}, cq.Columns())
+		assert.Equal(t, 2, len(res))
+		assert.Equal(t, "McCoy", res[1].N["name"])
+		assert.Equal(t, 41, res[1].Age)
+		stats, err := cq.Stats()
+		assert.Nil(t, err)
+		assert.Equal(t, 2, stats.NodesCreated)
+		assert.True(t, stats.ContainsUpdates)
+		//
+		// Failures are reported, and the connection remains usable
+		//
+		err = db.Cypher(&CypherQuery{Statement: "bad"})
+		ne, ok := err.(NeoError)
+		assert.True(t, ok)
+		assert.Equal(t, "Neo.ClientError.Statement.SyntaxError", ne.Exception)
+		err = db.Cypher(&cq)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(db.bolt.idle))
+		//
+		// Plans
+		//
+		s.handle("EXPLAIN MATCH (n) RETURN n", boltResponse{
+			fields: []string{"n"},
+			meta: map[string]interface{}{"plan": map[string]interface{}{
+				"operatorType": "ProduceResults",
+				"identifiers":  []interface{}{"n"},
+				"args":         map[string]interface{}{"EstimatedRows": 2.0, "planner": "COST"},
+				"children":     []interface{}{},
+			}},
+		})
+		pq := CypherQuery{Statement: "MATCH (n) RETURN n", Explain: true}
+		err = db.Cypher(&pq)
+		assert.Nil(t, err)
+		plan, err := pq.Plan()
+		assert.Nil(t, err)
+		assert.Equal(t, "ProduceResults", plan.OperatorType)
+		assert.Equal(t, 2.0, plan.EstimatedRows)
+		assert.Equal(t, "COST", plan.Arguments["planner"])
+		//
+		// Batches run in one transaction
+		//
+		err = db.CypherBatch([]*CypherQuery{&cq, &cq})
+		assert.Nil(t, err)
+		s.close()
+	}
+}
+
+func TestBoltAuth(t *testing.T) {
+	s := newBoltStub(t, 3)
+	defer s.close()
+	_, err := Connect(s.url("neo4j:wrong@"))
+	assert.NotNil(t, err)
+	assert.True(t, strings.Contains(err.Error(), "unauthorized"))
+	_, err = Connect(s.url(""))
+	assert.Nil(t, err)
+	assert.Equal(t, "none", s.auth["scheme"])
+	tok := BasicAuth("neo4j", "secret")
+	tok.Realm = "ldap"
+	_, err = ConnectWithAuth(s.url(""), nil, tok)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"scheme":      "basic",
+		"principal":   "neo4j",
+		"credentials": "secret",
+		"realm":       "ldap",
+		"user_agent":  "neoism",
+	}, s.auth)
+	_, err = ConnectWithAuth(s.url(""), nil, BearerAuth("token"))
+	assert.Nil(t, err)
+	assert.Equal(t, "bearer", s.auth["scheme"])
+	assert.Equal(t, "token", s.auth["credentials"])
+	//
+	// Expired credentials must be changed before anything else.
+	//
+	_, err = Connect(s.url("neo4j:expired@"))
+	pe, ok := err.(*PasswordChangeRequiredError)
+	if !assert.True(t, ok, "%v", err) {
+		return
+	}
+	assert.Equal(t, "neo4j", pe.Username)
+	s.handle("CALL dbms.security.changePassword($password)", boltResponse{})
+	db, err := pe.ChangePassword("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tok, _ = db.auth.get()
+	assert.Equal(t, "secret", tok.Credentials)
+}
+
+func TestBoltTx(t *testing.T) {
+	for _, version := range []uint32{1, 3} {
+		s := newBoltStub(t, version)
+		db, err := Connect(s.url(""))
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.handle("CREATE (n) RETURN n", boltResponse{
+			fields:  []string{"n"},
+			records: [][]interface{}{{node(1, []interface{}{}, map[string]interface{}{})}},
+		})
+		q := func(stmt string) []*CypherQuery {
+			return []*CypherQuery{{Statement: stmt}}
+		}
+		tx, err := db.Begin(q("CREATE (n) RETURN n"))
+		assert.Nil(t, err)
+		assert.Equal(t, int64(1), db.Stats().OpenTransactions)
+		assert.Nil(t, tx.Query(q("CREATE (n) RETURN n")))
+		assert.Nil(t, tx.Commit())
+		tx, err = db.Begin(q("CREATE (n) RETURN n"))
+		assert.Nil(t, err)
+		assert.Nil(t, tx.Rollback())
+		//
+		// A failed statement rolls back the transaction
+		//
+		tx, err = db.Begin(q("CREATE (n) RETURN n"))
+		assert.Nil(t, err)
+		assert.Equal(t, TxQueryError, tx.Query(q("bad")))
+		assert.Equal(t, "Neo.ClientError.Statement.SyntaxError", tx.Errors[0].Code)
+		assert.Equal(t, TxQueryError, tx.Commit())
+		assert.Equal(t, NotFound, tx.Rollback())
+		stats := db.Stats()
+		assert.Equal(t, int64(0), stats.OpenTransactions)
+		assert.Equal(t, int64(1), stats.Commits)
+		assert.Equal(t, int64(2), stats.Rollbacks)
+		begin, commit, rollback := "BEGIN", "COMMIT", "ROLLBACK"
+		if version < 3 {
+			begin, commit, rollback = "RUN BEGIN", "RUN COMMIT", "RUN ROLLBACK"
+		}
+		create := "RUN CREATE (n) RETURN n"
+		assert.Equal(t, []string{
+			begin, create, create, commit,
+			begin, create, rollback,
+			begin, create, "RUN bad", "RESET",
+		}, s.messages())
+		s.close()
+	}
+}
+
+func TestBoltV4(t *testing.T) {
+	s := newBoltStub(t, 0x0404)
+	defer s.close()
+	s.handle("RETURN 1", boltResponse{fields: []string{"1"}, records: [][]interface{}{{int64(1)}}})
+	db, err := Connect(s.url("neo4j:secret@"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "4.4.0", db.Version)
+	assert.True(t, db.Capabilities().MultiDatabase)
+	res := []struct {
+		N int `json:"1"`
+	}{}
+	assert.Nil(t, db.Cypher(&CypherQuery{Statement: "RETURN 1", Result: &res}))
+	assert.Equal(t, 1, len(res))
+	//
+	// The database and access mode are sent with BEGIN, or with RUN outside
+	// a transaction.
+	//
+	movies, err := db.Use("movies")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, movies.Cypher(&CypherQuery{Statement: "RETURN 1"}))
+	tx, err := movies.ReadOnly().Begin([]*CypherQuery{{Statement: "RETURN 1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, tx.Commit())
+	assert.Equal(t, []map[string]interface{}{
+		{},
+		{"db": "movies"},
+		{"db": "movies", "mode": "r"},
+		{},
+	}, s.metadata())
+	//
+	// Older protocol versions have no databases to choose from.
+	//
+	s3 := newBoltStub(t, 3)
+	defer s3.close()
+	db, err = Connect(s3.url("neo4j:secret@"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.False(t, db.Capabilities().MultiDatabase)
+	_, err = db.Use("movies")
+	_, ok := err.(*UnsupportedError)
+	assert.True(t, ok, "%v", err)
+}
+
+func TestPackStream(t *testing.T) {
+	long := strings.Repeat("x", 70000)
+	values := []interface{}{
+		nil, true, false,
+		int64(0), int64(-16), int64(127), int64(-17), int64(200), int64(-40000), int64(1 << 40),
+		3.25, "", "hello", long, []byte{1, 2, 3},
+		[]interface{}{int64(1), "two", []interface{}{}},
+		map[string]interface{}{"a": int64(1), "b": map[string]interface{}{"c": nil}},
+	}
+	for _, v := range values {
+		p := packer{}
+		assert.Nil(t, p.pack(v))
+		got, err := (&unpacker{bytes.NewReader(p.Bytes())}).unpack()
+		assert.Nil(t, err)
+		assert.Equal(t, v, got)
+	}
+	//
+	// Other types are packed via JSON
+	//
+	p := packer{}
+	assert.Nil(t, p.pack(struct {
+		Name string `json:"name"`
+		Age  int
+	}{"Kirk", 34}))
+	got, err := (&unpacker{bytes.NewReader(p.Bytes())}).unpack()
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"name": "Kirk", "Age": int64(34)}, got)
+	//
+	// Paths become lists of property maps
+	//
+	path := &psStruct{Signature: psPath, Fields: []interface{}{
+		[]interface{}{node(1, nil, map[string]interface{}{"n": int64(1)}), node(2, nil, map[string]interface{}{"n": int64(2)})},
+		[]interface{}{&psStruct{Signature: psUnboundRelationship, Fields: []interface{}{int64(9), "KNOWS", map[string]interface{}{"r": int64(9)}}}},
+		[]interface{}{int64(-1), int64(1)},
+	}}
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"n": int64(1)},
+		map[string]interface{}{"r": int64(9)},
+		map[string]interface{}{"n": int64(2)},
+	}, jsonValue(path))
+}
+
+func TestBoltStats(t *testing.T) {
+	s, err := boltStats(map[string]interface{}{
+		"constraints-added":     int64(1),
+		"constraints-removed":   int64(2),
+		"indexes-added":         int64(3),
+		"indexes-removed":       int64(4),
+		"labels-added":          int64(5),
+		"labels-removed":        int64(6),
+		"nodes-created":         int64(7),
+		"nodes-deleted":         int64(8),
+		"properties-set":        int64(9),
+		"relationships-created": int64(10),
+		"relationships-deleted": int64(11),
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, &Stats{
+		ConstraintsAdded:     1,
+		ConstraintsRemoved:   2,
+		ContainsUpdates:      true,
+		IndexesAdded:         3,
+		IndexesRemoved:       4,
+		LabelsAdded:          5,
+		LabelsRemoved:        6,
+		NodesCreated:         7,
+		NodesDeleted:         8,
+		PropertiesSet:        9,
+		RelationshipsCreated: 10,
+		RelationshipDeleted:  11,
+	}, s)
+	s, err = boltStats(map[string]interface{}{"system-updates": int64(0)})
+	assert.Nil(t, err)
+	assert.Equal(t, &Stats{}, s)
+}
+
+func TestBoltTimeout(t *testing.T) {
+	s := newBoltStub(t, 3)
+	defer s.close()
+	s.handle("RETURN 1", boltResponse{fields: []string{"1"}, records: [][]interface{}{{int64(1)}}})
+	s.handle("RETURN 2", boltResponse{fields: []string{"2"}, records: [][]interface{}{{int64(2)}}, delay: time.Second})
+	db, err := Connect(s.url("neo4j:foobar@"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetBoltTimeout(50 * time.Millisecond)
+	err = db.Cypher(&CypherQuery{Statement: "RETURN 2"})
+	if ne, ok := err.(net.Error); assert.True(t, ok, "%v", err) {
+		assert.True(t, ne.Timeout())
+	}
+	// The connection which timed out is discarded.
+	assert.Nil(t, db.Cypher(&CypherQuery{Statement: "RETURN 1"}))
+	db.SetBoltTimeout(0)
+	assert.Nil(t, db.Cypher(&CypherQuery{Statement: "RETURN 2"}))
+}
+
+func TestBoltHooks(t *testing.T) {
+	s := newBoltStub(t, 3)
+	defer s.close()
+	s.handle("RETURN 1", boltResponse{fields: []string{"1"}, records: [][]interface{}{{int64(1)}}})
+	db, err := Connect(s.url("neo4j:foobar@"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &recordingHook{}
+	db.AddHook(h)
+	assert.Nil(t, db.Cypher(&CypherQuery{Statement: "RETURN 1"}))
+	tx, err := db.Begin([]*CypherQuery{{Statement: "RETURN 1"}})
+	assert.Nil(t, err)
+	assert.Nil(t, tx.Commit())
+	qe := h.events(QueryEvent)
+	if assert.Equal(t, 2, len(qe)) {
+		assert.Equal(t, "RETURN 1", qe[0].Statement)
+		assert.Nil(t, qe[0].Err)
+	}
+	assert.Equal(t, 0, len(h.events(RequestEvent)))
+}
+
+func TestBoltBeginRejectedRollsBack(t *testing.T) {
+	s := newBoltStub(t, 3)
+	defer s.close()
+	s.handle("MATCH (a), (b) RETURN a, b", boltResponse{
+		fields: []string{"a", "b"},
+		meta: map[string]interface{}{"notifications": []interface{}{map[string]interface{}{
+			"code":     "Neo.ClientNotification.Statement.CartesianProductWarning",
+			"severity": SeverityWarning,
+		}}},
+	})
+	db, err := Connect(s.url("neo4j:foobar@"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.NotificationHandler = RejectNotifications(SeverityWarning)
+	tx, err := db.Begin([]*CypherQuery{{Statement: "MATCH (a), (b) RETURN a, b"}})
+	assert.Nil(t, tx)
+	_, ok := err.(*NotificationError)
+	assert.True(t, ok, "%v", err)
+	assert.Equal(t, []string{"BEGIN", "RUN MATCH (a), (b) RETURN a, b", "ROLLBACK"}, s.messages())
+	assert.Equal(t, int64(0), db.Stats().OpenTransactions)
+	//
+	// So does a query rejected in an open transaction.
+	//
+	db.NotificationHandler = nil
+	tx, err = db.Begin(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.NotificationHandler = RejectNotifications(SeverityWarning)
+	err = tx.Query([]*CypherQuery{{Statement: "MATCH (a), (b) RETURN a, b"}})
+	_, ok = err.(*NotificationError)
+	assert.True(t, ok, "%v", err)
+	assert.Equal(t, NotFound, tx.Commit())
+	assert.Equal(t, "ROLLBACK", s.messages()[len(s.messages())-1])
+	assert.Equal(t, int64(0), db.Stats().OpenTransactions)
+}
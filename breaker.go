@@ -0,0 +1,364 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released under
+// the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for details.
+// Resist intellectual serfdom - the ownership of ideas is akin to slavery.
+
+package neoism
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrCircuitOpen is returned, wrapped in a *url.Error, for requests
+	// rejected because the database's circuit breaker is open.
+	ErrCircuitOpen = errors.New("Circuit breaker is open")
+	// ErrLimiterTimeout is returned, wrapped in a *url.Error, for requests
+	// which waited longer than the Limiter's MaxWait to be sent.
+	ErrLimiterTimeout = errors.New("Timed out waiting for a request slot")
+)
+
+// A CircuitState is the state of a CircuitBreaker.
+type CircuitState int
+
+const (
+	// CircuitClosed lets requests through, counting failures.
+	CircuitClosed CircuitState = iota
+	// CircuitOpen rejects requests with ErrCircuitOpen.
+	CircuitOpen
+	// CircuitHalfOpen lets a limited number of probe requests through.  The
+	// circuit closes if they succeed, and opens again if one fails.
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// A CircuitBreaker stops requests to an unhealthy server, failing them fast
+// instead.  A request fails if no response is received or the response has a
+// 5xx status.  The circuit opens after ConsecutiveFailures consecutive
+// failures, or when at least MinRequests have been made and the ratio of
+// failures reaches FailureRatio.  After OpenTimeout it becomes half-open.
+type CircuitBreaker struct {
+	ConsecutiveFailures int     // Zero disables
+	FailureRatio        float64 // Zero disables
+	MinRequests         int
+	// Interval is how often failure counts are cleared while the circuit
+	// is closed.  If zero, they are cleared only on state changes.
+	Interval         time.Duration
+	OpenTimeout      time.Duration
+	HalfOpenRequests int // Concurrent probes when half-open; 1 if zero
+	// OnStateChange, if set, is called on every state change.
+	OnStateChange func(from, to CircuitState)
+	mu            sync.Mutex
+	state         CircuitState
+	requests      int
+	failures      int
+	consecutive   int
+	countsSince   time.Time
+	openedAt      time.Time
+	probes        int
+	now           func() time.Time
+}
+
+// NewCircuitBreaker returns a CircuitBreaker which opens after failures
+// consecutive failures, and becomes half-open after openTimeout.
+func NewCircuitBreaker(failures int, openTimeout time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		ConsecutiveFailures: failures,
+		OpenTimeout:         openTimeout,
+	}
+}
+
+// State returns the current state of the circuit.
+func (cb *CircuitBreaker) State() CircuitState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.currentState(nil)
+}
+
+func (cb *CircuitBreaker) clock() time.Time {
+	if cb.now != nil {
+		return cb.now()
+	}
+	return time.Now()
+}
+
+// currentState applies time-based transitions, appending them to changes.
+// cb.mu must be held.
+func (cb *CircuitBreaker) currentState(changes *[]CircuitState) CircuitState {
+	now := cb.clock()
+	switch cb.state {
+	case CircuitOpen:
+		if now.Sub(cb.openedAt) >= cb.OpenTimeout {
+			cb.setState(CircuitHalfOpen, changes)
+		}
+	case CircuitClosed:
+		if cb.Interval > 0 && now.Sub(cb.countsSince) >= cb.Interval {
+			cb.resetCounts()
+		}
+	}
+	return cb.state
+}
+
+// setState changes the state, appending the old and new states to changes.
+// cb.mu must be held.
+func (cb *CircuitBreaker) setState(s CircuitState, changes *[]CircuitState) {
+	if changes != nil {
+		*changes = append(*changes, cb.state, s)
+	}
+	cb.state = s
+	cb.resetCounts()
+	cb.probes = 0
+	if s == CircuitOpen {
+		cb.openedAt = cb.clock()
+	}
+}
+
+func (cb *CircuitBreaker) resetCounts() {
+	cb.requests = 0
+	cb.failures = 0
+	cb.consecutive = 0
+	cb.countsSince = cb.clock()
+}
+
+// notify calls OnStateChange for each pair of states in changes.
+func (cb *CircuitBreaker) notify(changes []CircuitState) {
+	if cb.OnStateChange == nil {
+		return
+	}
+	for i := 0; i+1 < len(changes); i += 2 {
+		cb.OnStateChange(changes[i], changes[i+1])
+	}
+}
+
+// allow returns ErrCircuitOpen if a request may not be made now.  Otherwise
+// the caller must report the request's outcome with done.
+func (cb *CircuitBreaker) allow() error {
+	changes := []CircuitState{}
+	defer func() { cb.notify(changes) }()
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	switch cb.currentState(&changes) {
+	case CircuitOpen:
+		return ErrCircuitOpen
+	case CircuitHalfOpen:
+		max := cb.HalfOpenRequests
+		if max < 1 {
+			max = 1
+		}
+		if cb.probes >= max {
+			return ErrCircuitOpen
+		}
+		cb.probes++
+	}
+	return nil
+}
+
+// done records the outcome of a request permitted by allow.
+func (cb *CircuitBreaker) done(failed bool) {
+	changes := []CircuitState{}
+	defer func() { cb.notify(changes) }()
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	switch cb.currentState(&changes) {
+	case CircuitHalfOpen:
+		if failed {
+			cb.setState(CircuitOpen, &changes)
+		} else {
+			cb.setState(CircuitClosed, &changes)
+		}
+	case CircuitClosed:
+		cb.requests++
+		if !failed {
+			cb.consecutive = 0
+			return
+		}
+		cb.failures++
+		cb.consecutive++
+		switch {
+		case cb.ConsecutiveFailures > 0 && cb.consecutive >= cb.ConsecutiveFailures:
+			cb.setState(CircuitOpen, &changes)
+		case cb.FailureRatio > 0 && cb.requests >= cb.MinRequests &&
+			float64(cb.failures)/float64(cb.requests) >= cb.FailureRatio:
+			cb.setState(CircuitOpen, &changes)
+		}
+	}
+}
+
+// cancel records that a request permitted by allow was not made.
+func (cb *CircuitBreaker) cancel() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if cb.state == CircuitHalfOpen && cb.probes > 0 {
+		cb.probes--
+	}
+}
+
+// A Limiter caps the number of requests a Database has in flight at once.
+// Further requests wait for a slot, until their context is done or, if
+// MaxWait is set, for at most MaxWait.  Create Limiters with NewLimiter; the
+// zero Limiter does not limit requests.
+type Limiter struct {
+	MaxWait time.Duration
+	// OnStateChange, if set, is called with true when all slots become
+	// busy, and with false when one becomes free again.
+	OnStateChange func(saturated bool)
+	slots         chan struct{}
+	mu            sync.Mutex
+	saturated     bool
+}
+
+// NewLimiter returns a Limiter allowing maxInFlight concurrent requests.  It
+// panics if maxInFlight is less than one.
+func NewLimiter(maxInFlight int) *Limiter {
+	if maxInFlight < 1 {
+		panic(fmt.Sprintf("neoism: NewLimiter(%d): a Limiter must allow at least one request", maxInFlight))
+	}
+	return &Limiter{
+		slots: make(chan struct{}, maxInFlight),
+	}
+}
+
+// InFlight returns the number of requests currently in flight.
+func (l *Limiter) InFlight() int {
+	return len(l.slots)
+}
+
+// acquire waits for a free slot.
+func (l *Limiter) acquire(ctx context.Context) error {
+	if l.slots == nil {
+		return nil
+	}
+	var timeout <-chan time.Time
+	if l.MaxWait > 0 {
+		t := time.NewTimer(l.MaxWait)
+		defer t.Stop()
+		timeout = t.C
+	}
+	select {
+	case l.slots <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timeout:
+		return ErrLimiterTimeout
+	}
+	l.update()
+	return nil
+}
+
+// release frees a slot.
+func (l *Limiter) release() {
+	if l.slots == nil {
+		return
+	}
+	<-l.slots
+	l.update()
+}
+
+// update notifies OnStateChange if saturation has changed.
+func (l *Limiter) update() {
+	l.mu.Lock()
+	saturated := len(l.slots) == cap(l.slots)
+	changed := saturated != l.saturated
+	l.saturated = saturated
+	l.mu.Unlock()
+	if changed && l.OnStateChange != nil {
+		l.OnStateChange(saturated)
+	}
+}
+
+// guardState holds a database's circuit breaker and limiter.  It is shared
+// by copies of the Database.
+type guardState struct {
+	mu      sync.RWMutex
+	breaker *CircuitBreaker
+	limiter *Limiter
+}
+
+// SetCircuitBreaker installs cb around all HTTP requests made by the
+// database; it does not apply over Bolt.  A nil cb removes the circuit
+// breaker.
+func (db *Database) SetCircuitBreaker(cb *CircuitBreaker) {
+	db.guard.mu.Lock()
+	defer db.guard.mu.Unlock()
+	db.guard.breaker = cb
+}
+
+// SetLimiter installs l to cap the HTTP requests the database has in flight;
+// it does not apply over Bolt.  A nil l removes the limit.
+func (db *Database) SetLimiter(l *Limiter) {
+	db.guard.mu.Lock()
+	defer db.guard.mu.Unlock()
+	db.guard.limiter = l
+}
+
+func (g *guardState) get() (*CircuitBreaker, *Limiter) {
+	if g == nil {
+		return nil, nil
+	}
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.breaker, g.limiter
+}
+
+// roundTrip sends req with send, subject to the circuit breaker and limiter.
+// A limiter slot is held until the response body is closed, as the response
+// is still being received until then.
+func (g *guardState) roundTrip(req *http.Request, send func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+	cb, l := g.get()
+	if cb != nil {
+		err := cb.allow()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if l != nil {
+		err := l.acquire(req.Context())
+		if err != nil {
+			if cb != nil {
+				cb.cancel()
+			}
+			return nil, err
+		}
+	}
+	resp, err := send(req)
+	if cb != nil {
+		cb.done(err != nil || resp.StatusCode >= 500)
+	}
+	if l != nil {
+		if err != nil || resp.Body == nil {
+			l.release()
+		} else {
+			resp.Body = &releasingBody{ReadCloser: resp.Body, l: l}
+		}
+	}
+	return resp, err
+}
+
+// A releasingBody frees its limiter slot when the response body is closed.
+type releasingBody struct {
+	io.ReadCloser
+	l    *Limiter
+	once sync.Once
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.l.release)
+	return err
+}
@@ -0,0 +1,196 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released under
+// the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for details.
+// Resist intellectual serfdom - the ownership of ideas is akin to slavery.
+
+package neoism
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Now()
+	changes := []string{}
+	cb := NewCircuitBreaker(3, time.Minute)
+	cb.now = func() time.Time { return now }
+	cb.OnStateChange = func(from, to CircuitState) {
+		changes = append(changes, from.String()+"->"+to.String())
+	}
+	for i := 0; i < 2; i++ {
+		assert.Nil(t, cb.allow())
+		cb.done(true)
+	}
+	assert.Nil(t, cb.allow())
+	cb.done(false) // Resets consecutive failures
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, cb.allow())
+		cb.done(true)
+	}
+	assert.Equal(t, CircuitOpen, cb.State())
+	assert.Equal(t, ErrCircuitOpen, cb.allow())
+	//
+	// Half-open admits one probe; a failure reopens the circuit
+	//
+	now = now.Add(time.Minute)
+	assert.Nil(t, cb.allow())
+	assert.Equal(t, ErrCircuitOpen, cb.allow())
+	cb.done(true)
+	assert.Equal(t, CircuitOpen, cb.State())
+	//
+	// A successful probe closes it
+	//
+	now = now.Add(time.Minute)
+	assert.Nil(t, cb.allow())
+	cb.done(false)
+	assert.Equal(t, CircuitClosed, cb.State())
+	assert.Equal(t, []string{
+		"closed->open",
+		"open->half-open",
+		"half-open->open",
+		"open->half-open",
+		"half-open->closed",
+	}, changes)
+}
+
+func TestCircuitBreakerRatio(t *testing.T) {
+	cb := &CircuitBreaker{
+		FailureRatio: 0.5,
+		MinRequests:  4,
+		OpenTimeout:  time.Minute,
+	}
+	for _, failed := range []bool{true, true, false} {
+		assert.Nil(t, cb.allow())
+		cb.done(failed)
+	}
+	assert.Equal(t, CircuitClosed, cb.State()) // Too few requests
+	assert.Nil(t, cb.allow())
+	cb.done(true)
+	assert.Equal(t, CircuitOpen, cb.State())
+}
+
+func TestCircuitBreakerDatabase(t *testing.T) {
+	f, db := connectFlaky(t, "/labels", 100)
+	defer f.fake.Close()
+	db.SetRetryPolicy(nil)
+	db.SetCircuitBreaker(NewCircuitBreaker(2, time.Hour))
+	db.Labels()
+	db.Labels()
+	_, err := db.Labels()
+	assert.True(t, errors.Is(err, ErrCircuitOpen))
+	assert.Equal(t, int64(2), db.Stats().Statuses[503])
+	_, err = db.Node(1)
+	assert.True(t, errors.Is(err, ErrCircuitOpen))
+	db.SetCircuitBreaker(nil)
+	_, err = db.Node(999999)
+	assert.Equal(t, NotFound, err)
+}
+
+// blockingTransport blocks requests until release is closed.
+type blockingTransport struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	b.started <- struct{}{}
+	<-b.release
+	return nil, errors.New("released")
+}
+
+func TestLimiter(t *testing.T) {
+	bt := &blockingTransport{
+		started: make(chan struct{}, 10),
+		release: make(chan struct{}),
+	}
+	g := &guardState{}
+	l := NewLimiter(2)
+	l.MaxWait = 10 * time.Millisecond
+	mu := sync.Mutex{}
+	states := []bool{}
+	l.OnStateChange = func(saturated bool) {
+		mu.Lock()
+		states = append(states, saturated)
+		mu.Unlock()
+	}
+	g.limiter = l
+	req, _ := http.NewRequest("GET", "http://localhost/db/data/", nil)
+	wg := sync.WaitGroup{}
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			g.roundTrip(req, bt.RoundTrip)
+		}()
+	}
+	<-bt.started
+	<-bt.started
+	assert.Equal(t, 2, l.InFlight())
+	_, err := g.roundTrip(req, bt.RoundTrip)
+	assert.Equal(t, ErrLimiterTimeout, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	l.MaxWait = 0
+	_, err = g.roundTrip(req.WithContext(ctx), bt.RoundTrip)
+	assert.Equal(t, context.Canceled, err)
+	close(bt.release)
+	wg.Wait()
+	assert.Equal(t, 0, l.InFlight())
+	assert.Equal(t, []bool{true, false}, states)
+}
+
+func TestZeroLimiter(t *testing.T) {
+	func() {
+		defer func() {
+			assert.NotNil(t, recover())
+		}()
+		NewLimiter(0)
+	}()
+	g := &guardState{limiter: &Limiter{}}
+	req, _ := http.NewRequest("GET", "http://localhost/db/data/", nil)
+	ok := func(req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: 200, Body: http.NoBody}, nil
+	}
+	done := make(chan error)
+	go func() {
+		resp, err := g.roundTrip(req, ok)
+		if err == nil {
+			resp.Body.Close()
+		}
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		assert.Nil(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("the zero Limiter blocked a request")
+	}
+	assert.Equal(t, 0, g.limiter.InFlight())
+}
+
+func TestLimiterHoldsSlotUntilBodyClosed(t *testing.T) {
+	g := &guardState{limiter: NewLimiter(1)}
+	send := func(req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader("{}"))}, nil
+	}
+	req, _ := http.NewRequest("GET", "http://localhost/db/data/", nil)
+	resp, err := g.roundTrip(req, send)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, g.limiter.InFlight())
+	io.ReadAll(resp.Body)
+	assert.Equal(t, 1, g.limiter.InFlight())
+	resp.Body.Close()
+	assert.Equal(t, 0, g.limiter.InFlight())
+	resp.Body.Close() // Closing again frees nothing more
+	assert.Equal(t, 0, g.limiter.InFlight())
+}
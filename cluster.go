@@ -0,0 +1,386 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released under
+// the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for details.
+// Resist intellectual serfdom - the ownership of ideas is akin to slavery.
+
+package neoism
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNoLeader is returned by a Cluster when no member accepts writes.
+var ErrNoLeader = errors.New("No cluster member accepts writes")
+
+// A Role is the part a member plays in a cluster.
+type Role string
+
+const (
+	RoleLeader      Role = "LEADER"       // Causal cluster leader, or HA master
+	RoleFollower    Role = "FOLLOWER"     // Causal cluster follower, or HA slave
+	RoleReadReplica Role = "READ_REPLICA" // Causal cluster read replica
+	RoleStandalone  Role = "STANDALONE"   // Not clustered
+	RoleUnavailable Role = "UNAVAILABLE"  // Could not be reached
+)
+
+// A ClusterMember is a server in a Cluster.
+type ClusterMember struct {
+	URL  string
+	Role Role
+	DB   *Database // Nil if the member could not be reached
+}
+
+// ClusterOptions configures a Cluster.
+type ClusterOptions struct {
+	// Client, if not nil, is used to connect to every member.  See
+	// ConnectWithClient.
+	Client *http.Client
+	// RefreshInterval, if not zero, is the age after which the members' roles
+	// are probed again before routing a query.  They are always probed again
+	// when a write fails because its member is no longer the leader.
+	RefreshInterval time.Duration
+}
+
+// A Cluster routes queries to the members of a Neo4j causal or HA cluster:
+// Begin, and Cypher queries whose AccessMode is AccessWrite, go to the leader
+// or master, while read-only queries go to the followers, slaves and read
+// replicas in turn.  Reads from other members than the leader may not yet see
+// the latest writes.
+//
+// A Cluster satisfies Querier.
+type Cluster struct {
+	opts      ClusterOptions
+	mu        sync.Mutex
+	members   []*ClusterMember
+	refreshed time.Time
+	next      int        // Index of the next reader
+	refreshMu sync.Mutex // Serializes refreshes
+}
+
+// ConnectCluster connects to the members of a cluster, given the URL of
+// each as for Connect, and probes their roles.  Members which cannot be
+// reached are retried whenever the roles are probed again; an error is
+// returned only if none can be reached.
+func ConnectCluster(urls []string, opts *ClusterOptions) (*Cluster, error) {
+	c := &Cluster{}
+	if opts != nil {
+		c.opts = *opts
+	}
+	if len(urls) == 0 {
+		return nil, errors.New("No cluster members given")
+	}
+	var firstErr error
+	reachable := false
+	for _, u := range urls {
+		m := &ClusterMember{URL: u, Role: RoleUnavailable}
+		db, err := ConnectWithClient(u, c.opts.Client)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if err == nil {
+			m.DB = db
+			reachable = true
+		}
+		c.members = append(c.members, m)
+	}
+	if !reachable {
+		return nil, firstErr
+	}
+	c.refresh(time.Time{})
+	return c, nil
+}
+
+// Members returns the members of the cluster, with their roles when last
+// probed.
+func (c *Cluster) Members() []ClusterMember {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ms := make([]ClusterMember, len(c.members))
+	for i, m := range c.members {
+		ms[i] = *m
+	}
+	return ms
+}
+
+// Refresh probes the roles of the members again.
+func (c *Cluster) Refresh() {
+	c.refresh(time.Now())
+}
+
+// refresh probes the roles of the members, unless that was done after since.
+func (c *Cluster) refresh(since time.Time) {
+	c.refreshMu.Lock()
+	defer c.refreshMu.Unlock()
+	c.mu.Lock()
+	if c.refreshed.After(since) {
+		c.mu.Unlock()
+		return // Another goroutine refreshed meanwhile
+	}
+	members := c.snapshot()
+	c.mu.Unlock()
+	for _, m := range members {
+		db := m.DB
+		if db == nil {
+			var err error
+			db, err = ConnectWithClient(m.URL, c.opts.Client)
+			if err != nil {
+				m.Role = RoleUnavailable
+				continue
+			}
+		}
+		role, err := db.clusterRole()
+		if err != nil {
+			role = RoleUnavailable
+		}
+		m.DB = db
+		m.Role = role
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, m := range members {
+		c.members[i] = m
+	}
+	c.refreshed = time.Now()
+}
+
+// snapshot copies the members.  The caller must hold c.mu.
+func (c *Cluster) snapshot() []*ClusterMember {
+	ms := make([]*ClusterMember, len(c.members))
+	for i, m := range c.members {
+		cp := *m
+		ms[i] = &cp
+	}
+	return ms
+}
+
+// maybeRefresh probes the roles again if they are older than the
+// RefreshInterval.
+func (c *Cluster) maybeRefresh() {
+	if c.opts.RefreshInterval == 0 {
+		return
+	}
+	c.mu.Lock()
+	stale := time.Since(c.refreshed) > c.opts.RefreshInterval
+	c.mu.Unlock()
+	if stale {
+		c.refresh(time.Now().Add(-c.opts.RefreshInterval))
+	}
+}
+
+// Leader returns the member which accepts writes: the leader of a causal
+// cluster, the master of an HA cluster, or a server which is not clustered.
+func (c *Cluster) Leader() (*Database, error) {
+	c.maybeRefresh()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.leader()
+}
+
+// leader finds the member which accepts writes.  The caller must hold c.mu.
+func (c *Cluster) leader() (*Database, error) {
+	for _, m := range c.members {
+		if m.Role == RoleLeader {
+			return m.DB, nil
+		}
+	}
+	for _, m := range c.members {
+		if m.Role == RoleStandalone {
+			return m.DB, nil
+		}
+	}
+	return nil, ErrNoLeader
+}
+
+// Reader returns a member for read-only queries, taking the followers and
+// read replicas in turn, or the leader if there are none.
+func (c *Cluster) Reader() (*Database, error) {
+	c.maybeRefresh()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for range c.members {
+		m := c.members[c.next%len(c.members)]
+		c.next++
+		if m.Role == RoleFollower || m.Role == RoleReadReplica {
+			return m.DB, nil
+		}
+	}
+	return c.leader()
+}
+
+// Cypher executes a query on a reader if its AccessMode is AccessRead, and
+// on the leader otherwise.
+func (c *Cluster) Cypher(q *CypherQuery) error {
+	if q.AccessMode() == AccessRead {
+		db, err := c.Reader()
+		if err != nil {
+			return err
+		}
+		return db.Cypher(q)
+	}
+	return c.write(func(db *Database) error {
+		return db.Cypher(q)
+	})
+}
+
+// CypherBatch executes a batch of queries on a reader if all are read-only,
+// and on the leader otherwise.
+func (c *Cluster) CypherBatch(qs []*CypherQuery) error {
+	for _, q := range qs {
+		if q.AccessMode() != AccessRead {
+			return c.write(func(db *Database) error {
+				return db.CypherBatch(qs)
+			})
+		}
+	}
+	db, err := c.Reader()
+	if err != nil {
+		return err
+	}
+	return db.CypherBatch(qs)
+}
+
+// Begin opens a transaction on the leader.
+func (c *Cluster) Begin(qs []*CypherQuery) (*Tx, error) {
+	var tx *Tx
+	err := c.write(func(db *Database) error {
+		var err error
+		tx, err = db.Begin(qs)
+		if err == TxQueryError && len(tx.Errors) > 0 {
+			// The refusal is only reported in the transaction's errors.
+			return &tx.Errors[0]
+		}
+		return err
+	})
+	if tx != nil && len(tx.Errors) > 0 {
+		return tx, TxQueryError
+	}
+	return tx, err
+}
+
+// BeginTransaction is like Begin, but returns the transaction as a
+// Transaction, so *Cluster satisfies Querier.
+func (c *Cluster) BeginTransaction(qs []*CypherQuery) (Transaction, error) {
+	tx, err := c.Begin(qs)
+	if tx == nil {
+		return nil, err
+	}
+	return tx, err
+}
+
+// Close closes the connections to all members.  See Database.Close.
+func (c *Cluster) Close(ctx context.Context) error {
+	var firstErr error
+	for _, m := range c.Members() {
+		if m.DB == nil {
+			continue
+		}
+		err := m.DB.Close(ctx)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// write calls f with the leader.  If the member is no longer the leader, the
+// roles are probed again and f is retried once with the new leader; the
+// write was refused, so retrying it is safe.
+func (c *Cluster) write(f func(db *Database) error) error {
+	db, err := c.Leader()
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	err = f(db)
+	if !isNotLeader(err) {
+		return err
+	}
+	c.refresh(start)
+	db, err = c.Leader()
+	if err != nil {
+		return err
+	}
+	return f(db)
+}
+
+const notALeader = "Neo.ClientError.Cluster.NotALeader"
+
+// isNotLeader reports whether err was returned because a write was sent to
+// a member which is not the leader.
+func isNotLeader(err error) bool {
+	var te *TxError
+	if errors.As(err, &te) {
+		return te.Code == notALeader
+	}
+	var be *boltError
+	if errors.As(err, &be) {
+		return be.Code == notALeader
+	}
+	var ne NeoError
+	if errors.As(err, &ne) {
+		return strings.Contains(ne.Exception, "NotALeader")
+	}
+	return false
+}
+
+// clusterRole probes the role of the server in its cluster, using the
+// causal clustering status endpoints, falling back to the HA ones.  Over
+// Bolt, the dbms.cluster.role() procedure is used instead.
+func (db *Database) clusterRole() (Role, error) {
+	if db.bolt != nil {
+		res := []struct {
+			Role Role `json:"role"`
+		}{}
+		cq := CypherQuery{Statement: "CALL dbms.cluster.role()", Result: &res}
+		if err := db.Cypher(&cq); err != nil || len(res) != 1 {
+			return RoleStandalone, nil // Not clustered
+		}
+		return res[0].Role, nil
+	}
+	u, err := url.Parse(db.Url)
+	if err != nil {
+		return "", err
+	}
+	probe := func(path string) (bool, error) {
+		u.Path = path
+		resp, err := db.Session.Get(u.String(), nil, nil, nil)
+		if err != nil {
+			return false, err
+		}
+		return resp.Status() == 200, nil
+	}
+	type check struct {
+		path string
+		role Role
+	}
+	checks := []check{
+		{"/db/manage/server/core/writable", RoleLeader},
+		{"/db/manage/server/core/read-only", RoleFollower},
+		{"/db/manage/server/read-replica/available", RoleReadReplica},
+		{"/db/manage/server/ha/master", RoleLeader},
+		{"/db/manage/server/ha/slave", RoleFollower},
+	}
+	if db.Capabilities().MultiDatabase {
+		// Neo4j 4.0 and later cannot tell followers and read replicas apart.
+		base := "/db/" + db.DatabaseName + "/cluster/"
+		checks = []check{
+			{base + "writable", RoleLeader},
+			{base + "read-only", RoleFollower},
+		}
+	}
+	for _, c := range checks {
+		ok, err := probe(c.path)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			return c.role, nil
+		}
+	}
+	return RoleStandalone, nil
+}
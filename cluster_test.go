@@ -0,0 +1,127 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released under
+// the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for details.
+// Resist intellectual serfdom - the ownership of ideas is akin to slavery.
+
+package neoism
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jmcvetta/neoism/neoismtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCluster(t *testing.T) {
+	fakes := make([]*neoismtest.Server, 3)
+	urls := make([]string, 3)
+	for i := range fakes {
+		fakes[i] = neoismtest.NewServer()
+		defer fakes[i].Close()
+		urls[i] = fakes[i].URL
+		fakes[i].HandleCypher(`^(MATCH|MERGE|CREATE \(n\))`, neoismtest.CypherResponse{})
+	}
+	fakes[0].SetClusterRole(neoismtest.Follower)
+	fakes[1].SetClusterRole(neoismtest.Leader)
+	fakes[2].SetClusterRole(neoismtest.ReadReplica)
+	c, err := ConnectCluster(urls, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roles := []Role{}
+	for _, m := range c.Members() {
+		roles = append(roles, m.Role)
+	}
+	assert.Equal(t, []Role{RoleFollower, RoleLeader, RoleReadReplica}, roles)
+	//
+	// Reads are spread over the follower and the read replica, writes go to
+	// the leader.
+	//
+	for i := 0; i < 2; i++ {
+		assert.Nil(t, c.Cypher(&CypherQuery{Statement: "MATCH (n) RETURN n"}))
+	}
+	assert.Nil(t, c.Cypher(&CypherQuery{Statement: "CREATE (n)"}))
+	tx, err := c.Begin([]*CypherQuery{{Statement: "MERGE (n)"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, tx.Commit())
+	assert.Equal(t, []string{"MATCH (n) RETURN n"}, fakes[0].Statements())
+	assert.Equal(t, []string{"CREATE (n)", "MERGE (n)"}, fakes[1].Statements())
+	assert.Equal(t, []string{"MATCH (n) RETURN n"}, fakes[2].Statements())
+	//
+	// After a leader switch, the first write is refused, the roles are probed
+	// again and the write is retried on the new leader.
+	//
+	fakes[0].SetClusterRole(neoismtest.Leader)
+	fakes[1].SetClusterRole(neoismtest.Follower)
+	fakes[0].HandleCypher(`^CREATE \(m\)`, neoismtest.CypherResponse{})
+	fakes[1].HandleCypher(`^CREATE \(m\)`, neoismtest.CypherResponse{
+		Error: &neoismtest.CypherError{
+			Code:    "Neo.ClientError.Cluster.NotALeader",
+			Message: "No write operations are allowed on this database.",
+		},
+	})
+	assert.Nil(t, c.Cypher(&CypherQuery{Statement: "CREATE (m)"}))
+	assert.Equal(t, []string{"MATCH (n) RETURN n", "CREATE (m)"}, fakes[0].Statements())
+	leader, err := c.Leader()
+	assert.Nil(t, err)
+	assert.Equal(t, fakes[0].URL+"/db/data/", leader.Url)
+	//
+	// A transaction begun on a stale leader is re-routed as well.
+	//
+	fakes[0].SetClusterRole(neoismtest.Follower)
+	fakes[1].SetClusterRole(neoismtest.Leader)
+	fakes[1].HandleCypher(`^CREATE \(o\)`, neoismtest.CypherResponse{})
+	fakes[0].HandleCypher(`^CREATE \(o\)`, neoismtest.CypherResponse{
+		Error: &neoismtest.CypherError{
+			Code:    "Neo.ClientError.Cluster.NotALeader",
+			Message: "No write operations are allowed on this database.",
+		},
+	})
+	tx, err = c.Begin([]*CypherQuery{{Statement: "CREATE (o)"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, tx.Commit())
+	assert.Equal(t, []string{"CREATE (n)", "MERGE (n)", "CREATE (m)", "CREATE (o)"}, fakes[1].Statements())
+	leader, err = c.Leader()
+	assert.Nil(t, err)
+	assert.Equal(t, fakes[1].URL+"/db/data/", leader.Url)
+	assert.Nil(t, c.Close(context.Background()))
+}
+
+func TestClusterStandalone(t *testing.T) {
+	fake := neoismtest.NewServer()
+	defer fake.Close()
+	c, err := ConnectCluster([]string{fake.URL, "http://127.0.0.1:1/"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ms := c.Members()
+	assert.Equal(t, RoleStandalone, ms[0].Role)
+	assert.Equal(t, RoleUnavailable, ms[1].Role)
+	reader, err := c.Reader()
+	assert.Nil(t, err)
+	leader, err := c.Leader()
+	assert.Nil(t, err)
+	assert.Equal(t, leader, reader)
+	_, err = ConnectCluster([]string{"http://127.0.0.1:1/"}, nil)
+	assert.NotNil(t, err)
+}
+
+func TestClusterNeo4j4(t *testing.T) {
+	leader := neoismtest.NewServerVersion("4.4.0")
+	defer leader.Close()
+	replica := neoismtest.NewServerVersion("4.4.0")
+	defer replica.Close()
+	leader.SetClusterRole(neoismtest.Leader)
+	replica.SetClusterRole(neoismtest.ReadReplica)
+	c, err := ConnectCluster([]string{leader.URL, replica.URL}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ms := c.Members()
+	assert.Equal(t, RoleLeader, ms[0].Role)
+	assert.Equal(t, RoleFollower, ms[1].Role)
+}
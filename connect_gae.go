@@ -7,33 +7,24 @@
 package neoism
 
 import (
-	"net/http"
 	"net/url"
 
 	"appengine"
 	"appengine/urlfetch"
-
-	"gopkg.in/jmcvetta/napping.v3"
 )
 
 // Modified version of Connect that support Google App Engine.
 // Connect setups parameters for the Neo4j server
 // and calls ConnectWithRetry()
 func Connect(uri string, gaeContext appengine.Context) (*Database, error) {
-	h := http.Header{}
-	h.Add("User-Agent", "neoism")
-	db := &Database{
-		Session: &napping.Session{
-			Header: &h,
-			Client: urlfetch.Client(gaeContext),
-		},
-	}
+	db := newDatabase(urlfetch.Client(gaeContext))
 	parsedUrl, err := url.Parse(uri)
 	if err != nil {
 		return nil, err
 	}
 	if parsedUrl.User != nil {
-		db.Session.Userinfo = parsedUrl.User
+		password, _ := parsedUrl.User.Password()
+		db.auth.set(BasicAuth(parsedUrl.User.Username(), password))
 	}
 	return connectWithRetry(db, parsedUrl, 0)
 }
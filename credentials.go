@@ -0,0 +1,149 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released under
+// the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for details.
+// Resist intellectual serfdom - the ownership of ideas is akin to slavery.
+
+package neoism
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A CredentialsProvider supplies the credentials a Database authenticates
+// with.  Over HTTP it is consulted for every request; over Bolt, whenever a
+// connection is opened.  If the server refuses the credentials and the
+// provider then supplies different ones, the request is sent again with
+// those, so a long-lived Database survives password rotation without
+// reconnecting.
+//
+// Credentials may be called by several goroutines at once.
+type CredentialsProvider interface {
+	Credentials(ctx context.Context) (AuthToken, error)
+}
+
+// An invalidator is a CredentialsProvider which caches credentials, and can
+// be told to look them up again when the server refuses them.
+type invalidator interface {
+	Invalidate()
+}
+
+type staticCredentials struct {
+	token AuthToken
+}
+
+// StaticCredentials returns a provider which always supplies t.
+func StaticCredentials(t AuthToken) CredentialsProvider {
+	return &staticCredentials{token: t}
+}
+
+func (p *staticCredentials) Credentials(ctx context.Context) (AuthToken, error) {
+	return p.token, nil
+}
+
+type envCredentials struct {
+	usernameVar string
+	passwordVar string
+}
+
+// EnvCredentials returns a provider which supplies a username and password
+// for basic authentication from the environment variables usernameVar and
+// passwordVar, read anew on every call.
+func EnvCredentials(usernameVar, passwordVar string) CredentialsProvider {
+	return &envCredentials{usernameVar: usernameVar, passwordVar: passwordVar}
+}
+
+func (p *envCredentials) Credentials(ctx context.Context) (AuthToken, error) {
+	username, ok := os.LookupEnv(p.usernameVar)
+	if !ok {
+		return AuthToken{}, fmt.Errorf("Environment variable %s is not set", p.usernameVar)
+	}
+	password, ok := os.LookupEnv(p.passwordVar)
+	if !ok {
+		return AuthToken{}, fmt.Errorf("Environment variable %s is not set", p.passwordVar)
+	}
+	return BasicAuth(username, password), nil
+}
+
+// FileCredentialsProvider supplies credentials read from a file.  See
+// FileCredentials.
+type FileCredentialsProvider struct {
+	path    string
+	mu      sync.Mutex
+	token   *AuthToken // Nil until the file has been read
+	modTime time.Time
+	size    int64
+}
+
+// FileCredentials returns a provider which supplies a username and password
+// for basic authentication from the first line of the file at path, in the
+// form "username:password".  The file is read again whenever its
+// modification time or size changes, or the server refuses the credentials.
+func FileCredentials(path string) *FileCredentialsProvider {
+	return &FileCredentialsProvider{path: path}
+}
+
+// Credentials implements CredentialsProvider.  If the file cannot be read
+// after it was read once, the credentials last read are supplied.
+func (p *FileCredentialsProvider) Credentials(ctx context.Context) (AuthToken, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	fi, err := os.Stat(p.path)
+	if err != nil {
+		return p.cached(err)
+	}
+	if p.token != nil && fi.ModTime().Equal(p.modTime) && fi.Size() == p.size {
+		return *p.token, nil
+	}
+	t, err := readCredentialsFile(p.path)
+	if err != nil {
+		return p.cached(err)
+	}
+	p.token = &t
+	p.modTime = fi.ModTime()
+	p.size = fi.Size()
+	return t, nil
+}
+
+// cached returns the credentials last read, or err if there are none.  The
+// caller must hold p.mu.
+func (p *FileCredentialsProvider) cached(err error) (AuthToken, error) {
+	if p.token == nil {
+		return AuthToken{}, err
+	}
+	return *p.token, nil
+}
+
+// Invalidate makes the next call to Credentials read the file again, even if
+// it seems unchanged.
+func (p *FileCredentialsProvider) Invalidate() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.modTime = time.Time{}
+	p.size = -1
+}
+
+// readCredentialsFile reads "username:password" from the first line of the
+// file at path.
+func readCredentialsFile(path string) (AuthToken, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return AuthToken{}, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Scan()
+	if err := sc.Err(); err != nil {
+		return AuthToken{}, err
+	}
+	line := strings.TrimRight(sc.Text(), "\r")
+	i := strings.Index(line, ":")
+	if i < 0 {
+		return AuthToken{}, fmt.Errorf("Credentials file %s is not of the form username:password", path)
+	}
+	return BasicAuth(line[:i], line[i+1:]), nil
+}
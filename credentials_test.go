@@ -0,0 +1,108 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released under
+// the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for details.
+// Resist intellectual serfdom - the ownership of ideas is akin to slavery.
+
+package neoism
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jmcvetta/neoism/neoismtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileCredentials(t *testing.T) {
+	fake := neoismtest.NewServer()
+	defer fake.Close()
+	fake.SetUser("neo4j", "first", false)
+	dir, err := ioutil.TempDir("", "neoism")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "credentials")
+	write := func(s string) {
+		if err := ioutil.WriteFile(path, []byte(s), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("neo4j:first\n")
+	db, err := ConnectWithCredentials(fake.URL, nil, FileCredentials(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.CreateNode(Props{})
+	assert.Nil(t, err)
+	//
+	// The file is read again when it changes.
+	//
+	fake.SetUser("neo4j", "second-password", false)
+	write("neo4j:second-password\n")
+	_, err = db.CreateNode(Props{})
+	assert.Nil(t, err)
+	//
+	// A change the file's size and modification time do not reveal is picked
+	// up when the server refuses the old password.
+	//
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake.SetUser("neo4j", "third-password!", false)
+	write("neo4j:third-password!\n")
+	os.Chtimes(path, time.Now(), fi.ModTime())
+	_, err = db.CreateNode(Props{})
+	assert.Nil(t, err)
+	//
+	// Credentials the server refuses are still refused.
+	//
+	fake.SetUser("neo4j", "unknown", false)
+	_, err = db.CreateNode(Props{})
+	assert.NotNil(t, err)
+	//
+	// A missing file is an error only before it was first read.
+	//
+	os.Remove(path)
+	fake.SetUser("neo4j", "third-password!", false)
+	_, err = db.CreateNode(Props{})
+	assert.Nil(t, err)
+	_, err = ConnectWithCredentials(fake.URL, nil, FileCredentials(path))
+	assert.NotNil(t, err)
+}
+
+func TestEnvCredentials(t *testing.T) {
+	fake := neoismtest.NewServer()
+	defer fake.Close()
+	fake.SetUser("neo4j", "first", false)
+	os.Unsetenv("NEOISM_TEST_USER")
+	os.Unsetenv("NEOISM_TEST_PASSWORD")
+	p := EnvCredentials("NEOISM_TEST_USER", "NEOISM_TEST_PASSWORD")
+	_, err := ConnectWithCredentials(fake.URL, nil, p)
+	assert.NotNil(t, err)
+	os.Setenv("NEOISM_TEST_USER", "neo4j")
+	os.Setenv("NEOISM_TEST_PASSWORD", "first")
+	defer os.Unsetenv("NEOISM_TEST_USER")
+	defer os.Unsetenv("NEOISM_TEST_PASSWORD")
+	db, err := ConnectWithCredentials(fake.URL, nil, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake.SetUser("neo4j", "second", false)
+	os.Setenv("NEOISM_TEST_PASSWORD", "second")
+	_, err = db.CreateNode(Props{})
+	assert.Nil(t, err)
+	//
+	// ChangePassword leaves the password to the provider.
+	//
+	assert.Nil(t, db.ChangePassword("third"))
+	assert.Equal(t, "third", fake.Password())
+	_, err = db.CreateNode(Props{})
+	assert.NotNil(t, err)
+	os.Setenv("NEOISM_TEST_PASSWORD", "third")
+	_, err = db.CreateNode(Props{})
+	assert.Nil(t, err)
+}
@@ -20,6 +20,24 @@ type CypherQuery struct {
 	cr           cypherResult
 	IncludeStats bool `json:"includeStats"`
 	stats        *Stats
+	// If Explain is true, the query is not executed; instead the server
+	// returns the execution plan it would use.  If Profile is true, the
+	// query is executed and the plan is returned along with the number of
+	// rows and db hits for each operator.  Plans are only returned by the
+	// transactional endpoint, so a query requesting one cannot be used in
+	// CypherBatch.
+	Explain bool `json:"-"`
+	Profile bool `json:"-"`
+	// Mode declares whether the query writes.  If it is AccessDefault, the
+	// mode is guessed from the statement; see Classify.  A query declared
+	// AccessRead fails with an *AccessModeError if the server reports, in
+	// its statistics, that it wrote.
+	Mode          AccessMode `json:"-"`
+	plan          *Plan
+	notifications []Notification
+	// secret is set on queries whose parameters hold credentials, such as
+	// passwords, which must not reach hooks.
+	secret bool
 }
 
 // Columns returns the names, in order, of the columns returned for this query.
@@ -51,6 +69,62 @@ func (cq *CypherQuery) Unmarshal(v interface{}) error {
 	return json.Unmarshal(b, v)
 }
 
+// SetResult populates the query's result as if it had been returned by the
+// server: columns names the columns, and each row holds one value per column.
+// If Result is set, the rows are unmarshalled into it.  SetResult is intended
+// for mock implementations of Querier.
+func (cq *CypherQuery) SetResult(columns []string, rows [][]interface{}) error {
+	data := make([][]*json.RawMessage, len(rows))
+	for i, row := range rows {
+		if len(row) != len(columns) {
+			return errors.New("Row length does not match column count")
+		}
+		data[i] = make([]*json.RawMessage, len(row))
+		for j, v := range row {
+			b, err := json.Marshal(v)
+			if err != nil {
+				return err
+			}
+			raw := json.RawMessage(b)
+			data[i][j] = &raw
+		}
+	}
+	cq.cr = cypherResult{
+		Columns: columns,
+		Data:    data,
+	}
+	if cq.Result != nil {
+		return cq.Unmarshal(cq.Result)
+	}
+	return nil
+}
+
+// statement returns the statement text, prefixed with EXPLAIN or PROFILE if
+// an execution plan was requested.
+func (cq *CypherQuery) statement() string {
+	switch {
+	case cq.Profile:
+		return "PROFILE " + cq.Statement
+	case cq.Explain:
+		return "EXPLAIN " + cq.Statement
+	}
+	return cq.Statement
+}
+
+// wantsPlan reports whether an execution plan was requested for the query.
+func (cq *CypherQuery) wantsPlan() bool {
+	return cq.Explain || cq.Profile
+}
+
+// Plan returns the execution plan for the query.  Explain or Profile must be
+// set before the query is executed.
+func (cq *CypherQuery) Plan() (*Plan, error) {
+	if cq.plan == nil {
+		return nil, errors.New("plan was not requested at query time")
+	}
+	return cq.plan, nil
+}
+
 func (cq *CypherQuery) Stats() (*Stats, error) {
 	if cq.stats == nil {
 		return nil, errors.New("stats were not requested at query time")
@@ -88,6 +162,21 @@ type cypherResult struct {
 // from the db is used to populate `result`, which should be a pointer to a
 // slice of structs.  TODO:  Or a pointer to a two-dimensional array of structs?
 func (db *Database) Cypher(q *CypherQuery) error {
+	qs := []*CypherQuery{q}
+	return db.observeQueries(qs, func() error {
+		return checkAccess(qs, db.accessMode(), func() error {
+			return db.cypher(q)
+		})
+	})
+}
+
+func (db *Database) cypher(q *CypherQuery) error {
+	if db.bolt != nil {
+		return db.boltCypher(q)
+	}
+	if q.wantsPlan() || db.HrefCypher == "" {
+		return db.cypherTx(q)
+	}
 	result := cypherResult{}
 	payload := cypherRequest{
 		Query:      q.Statement,
@@ -136,8 +225,25 @@ type batchCypherResponse struct {
 // strings in subsequent job descriptions, CypherQuery's batch id will be its
 // index in the slice.
 func (db *Database) CypherBatch(qs []*CypherQuery) error {
+	return db.observeQueries(qs, func() error {
+		return checkAccess(qs, db.accessMode(), func() error {
+			return db.cypherBatch(qs)
+		})
+	})
+}
+
+func (db *Database) cypherBatch(qs []*CypherQuery) error {
+	if db.bolt != nil {
+		return db.boltCypherBatch(qs)
+	}
+	if db.HrefBatch == "" {
+		return db.cypherTx(qs...)
+	}
 	payload := make([]batchCypherQuery, len(qs))
 	for i, q := range qs {
+		if q.wantsPlan() {
+			return errors.New("Execution plans cannot be requested for batched queries")
+		}
 		payload[i] = batchCypherQuery{
 			Method: "POST",
 			To:     "/cypher",
@@ -193,6 +193,9 @@ func TestCypher(t *testing.T) {
 func TestCypherComment(t *testing.T) {
 	db := connectTest(t)
 	defer cleanup(t, db)
+	if !db.Capabilities().REST {
+		t.Skip("Test fixtures are created with the REST API")
+	}
 	// Create
 	idx0, _ := db.CreateLegacyNodeIndex("name_index", "", "")
 	defer idx0.Delete()
@@ -390,3 +393,19 @@ func TestCypherBatchStats(t *testing.T) {
 	}
 	assert.Equal(t, Stats{ContainsUpdates: true, LabelsAdded: 1, NodesCreated: 1}, *stats)
 }
+
+func TestCypherQuerySetResult(t *testing.T) {
+	res := []struct {
+		Name string `json:"name"`
+		Age  int    `json:"age"`
+	}{}
+	cq := CypherQuery{Result: &res}
+	err := cq.SetResult([]string{"name", "age"}, [][]interface{}{{"Kirk", 34}, {"McCoy", 41}})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"name", "age"}, cq.Columns())
+	assert.Equal(t, 2, len(res))
+	assert.Equal(t, "McCoy", res[1].Name)
+	assert.Equal(t, 41, res[1].Age)
+	err = cq.SetResult([]string{"name"}, [][]interface{}{{"Kirk", 34}})
+	assert.NotNil(t, err)
+}
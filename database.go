@@ -6,9 +6,11 @@ package neoism
 
 import (
 	"errors"
+	"fmt"
 	"log"
 	"net/url"
 	"strconv"
+	"strings"
 
 	"gopkg.in/jmcvetta/napping.v3"
 )
@@ -16,18 +18,34 @@ import (
 // A Database is a REST client connected to a Neo4j database.
 type Database struct {
 	Session         *napping.Session
-	Url             string      `json:"-"` // Root URL for REST API
-	HrefNode        string      `json:"node"`
-	HrefRefNode     string      `json:"reference_node"`
-	HrefNodeIndex   string      `json:"node_index"`
-	HrefRelIndex    string      `json:"relationship_index"`
-	HrefExtInfo     string      `json:"extensions_info"`
-	HrefRelTypes    string      `json:"relationship_types"`
-	HrefBatch       string      `json:"batch"`
-	HrefCypher      string      `json:"cypher"`
-	HrefTransaction string      `json:"transaction"`
-	Version         string      `json:"neo4j_version"`
-	Extensions      interface{} `json:"extensions"`
+	Url             string     `json:"-"` // Root URL for REST API
+	HrefNode        string     `json:"node"`
+	HrefRefNode     string     `json:"reference_node"`
+	HrefNodeIndex   string     `json:"node_index"`
+	HrefRelIndex    string     `json:"relationship_index"`
+	HrefExtInfo     string     `json:"extensions_info"`
+	HrefRelTypes    string     `json:"relationship_types"`
+	HrefBatch       string     `json:"batch"`
+	HrefCypher      string     `json:"cypher"`
+	HrefTransaction string     `json:"transaction"`
+	Version         string     `json:"neo4j_version"`
+	Edition         string     `json:"neo4j_edition"` // Neo4j 4.0 and later
+	Extensions      Extensions `json:"extensions"`
+	// NotificationHandler, if set, is called with any notifications the
+	// server returns for queries executed on the transactional endpoint.
+	NotificationHandler NotificationHandler `json:"-"`
+	// DatabaseName is the database targeted on servers which host several,
+	// i.e. Neo4j 4.0 and later.  See Use.
+	DatabaseName   string `json:"-"`
+	hrefTxTemplate string // Transactional endpoint, with {databaseName}
+	readOnly       bool   // See ReadOnly
+	hooks          *hookSet
+	stats          *statsCollector
+	retry          *retryState
+	guard          *guardState
+	life           *lifecycle
+	auth           *authState
+	bolt           *boltPool // Set when connected with a bolt:// URL
 }
 
 // connectWithRetry tries to establish a connection to the Neo4j server.
@@ -42,11 +60,21 @@ func connectWithRetry(db *Database, parsedUrl *url.URL, retries int) (*Database,
 	//		Method: "GET",
 	//		Result: &db,
 	//		Error:  &e,
-	resp, err := db.Session.Get(db.Url, nil, &db, nil)
+	ar := authResponse{}
+	resp, err := db.Session.Get(db.Url, nil, &db, &ar)
 	if err != nil {
 		return nil, err
 	}
-	if resp.Status() != 200 {
+	switch {
+	case resp.Status() == 401:
+		return nil, Unauthorized
+	case resp.Status() == 403 && ar.PasswordChange != "":
+		return nil, &PasswordChangeRequiredError{
+			Username: db.username(),
+			db:       db,
+			url:      *parsedUrl,
+		}
+	case resp.Status() != 200:
 		log.Println("Status " + strconv.Itoa(resp.Status()) + " trying to connect to " + db.Url)
 		return nil, InvalidDatabase
 	}
@@ -54,14 +82,99 @@ func connectWithRetry(db *Database, parsedUrl *url.URL, retries int) (*Database,
 		parsedUrl.Path = "/db/data/"
 		return connectWithRetry(db, parsedUrl, retries+1)
 	}
+	if strings.Contains(db.HrefTransaction, databaseNameTemplate) {
+		// Neo4j 4.0 or later, which serves only the transactional endpoint,
+		// once for each database.
+		db.hrefTxTemplate = db.HrefTransaction
+		name, err := db.defaultDatabase()
+		if err != nil {
+			return nil, err
+		}
+		return db.Use(name)
+	}
 	return db, nil
 }
 
-// PropertyKeys lists all property keys ever used in the database. This
-// includes and property keys you have used, but deleted.  There is
-// currently no way to tell which ones are in use and which ones are not,
-// short of walking the entire set of properties in the database.
+const databaseNameTemplate = "{databaseName}"
+
+// defaultDatabase asks the server for the name of its default database.
+// Neo4j 4.0 - 4.2 do not support SHOW DEFAULT DATABASE, and reject it as a
+// syntax error, in which case the default name "neo4j" is assumed.  Any other
+// error is returned, as a *PasswordChangeRequiredError if the user must change
+// their password first.
+func (db *Database) defaultDatabase() (string, error) {
+	system, err := db.Use("system")
+	if err != nil {
+		return "", err
+	}
+	res := []struct {
+		Name string `json:"name"`
+	}{}
+	cq := CypherQuery{Statement: "SHOW DEFAULT DATABASE YIELD name", Result: &res}
+	err = system.Cypher(&cq)
+	if te, ok := err.(*TxError); ok {
+		switch te.Code {
+		case syntaxError:
+			return "neo4j", nil
+		case credentialsExpired:
+			u, _ := url.Parse(db.Url)
+			return "", &PasswordChangeRequiredError{
+				Username: db.username(),
+				db:       db,
+				url:      *u,
+			}
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	if len(res) != 1 || res[0].Name == "" {
+		return "", fmt.Errorf("Server reported %d default databases", len(res))
+	}
+	return res[0].Name, nil
+}
+
+// syntaxError is the code of the error returned for a statement the server
+// cannot parse.
+const syntaxError = "Neo.ClientError.Statement.SyntaxError"
+
+// Use returns a handle on the named database, on servers which host several,
+// i.e. Neo4j 4.0 and later.  Over Bolt, that takes protocol version 4, which
+// Neo4j 4.3 and later speak.  The handle shares its connection, hooks,
+// statistics and other settings with db.  On older servers, Use returns an
+// *UnsupportedError.
+func (db *Database) Use(name string) (*Database, error) {
+	if !db.Capabilities().MultiDatabase {
+		return nil, &UnsupportedError{Feature: "Multiple databases", Version: db.Version}
+	}
+	d := *db
+	d.DatabaseName = name
+	if db.hrefTxTemplate != "" {
+		d.HrefTransaction = strings.Replace(db.hrefTxTemplate, databaseNameTemplate, url.PathEscape(name), -1)
+	}
+	return &d, nil
+}
+
+// requireREST returns an *UnsupportedError for feature if the server does not
+// provide the REST API, as is the case for Neo4j 4.0 and later, and over Bolt.
+func (db *Database) requireREST(feature string) error {
+	return db.require(db.Capabilities().REST, feature)
+}
+
+// PropertyKeys lists all property keys ever used in the database.
+//
+// Deprecated: Use db.PropertyKeys.
 func PropertyKeys(db *Database) ([]string, error) {
+	return db.PropertyKeys()
+}
+
+// PropertyKeys lists all property keys ever used in the database. This
+// includes property keys you have used, but deleted.  To tell which ones are
+// in use, see PropertyUsage.
+func (db *Database) PropertyKeys() ([]string, error) {
+	if !db.Capabilities().REST {
+		return db.callList("PropertyKeys", "CALL db.propertyKeys() YIELD propertyKey AS name RETURN name")
+	}
 	propertyKeys := []string{}
 	ne := NeoError{}
 
@@ -151,7 +151,7 @@ func TestPropertyKeys(t *testing.T) {
 	}
 
 	// Get all live property keys on nodes and relationships
-	livePropertyKeys, err := PropertyKeys(db)
+	livePropertyKeys, err := db.PropertyKeys()
 	if err != nil {
 		t.Error(err)
 	}
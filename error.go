@@ -6,6 +6,7 @@ package neoism
 
 import (
 	"errors"
+	"fmt"
 )
 
 // One of these errors is returned if we receive an error or unexpected response
@@ -15,6 +16,7 @@ var (
 	InvalidDatabase = errors.New("Invalid database.  Check URI.")
 	NotAllowed      = errors.New("Operation not allowed.")
 	NotFound        = errors.New("Cannot find in database.")
+	Unauthorized    = errors.New("Authentication failed.  Check credentials.")
 	// A TxQueryError is returned when there is an error with one of the Cypher
 	// queries inside a transaction, but not with the transaction itself.
 	TxQueryError = errors.New("Error with a query inside a transaction.")
@@ -45,3 +47,15 @@ type TxError struct {
 func (t *TxError) Error() string {
 	return t.Message
 }
+
+// An UnsupportedError is returned by methods which use an API the server does
+// not provide, such as the REST API for nodes, relationships and legacy
+// indexes, which was removed in Neo4j 4.0 and is unavailable over Bolt.
+type UnsupportedError struct {
+	Feature string
+	Version string // Server version
+}
+
+func (e *UnsupportedError) Error() string {
+	return fmt.Sprintf("%s is not supported by Neo4j %s", e.Feature, e.Version)
+}
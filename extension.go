@@ -0,0 +1,229 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released under
+// the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for details.
+// Resist intellectual serfdom - the ownership of ideas is akin to slavery.
+
+package neoism
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+)
+
+// ErrNoExtension is returned by Invoke for an extension method which the
+// service root, node or relationship does not offer.
+var ErrNoExtension = errors.New("No such server extension method.")
+
+// Extensions maps the names of the server extensions, or plugins, offered by
+// a service root, node or relationship to their methods, and those to the
+// URLs at which they are invoked.
+type Extensions map[string]map[string]string
+
+// An ExtensionParameter describes a parameter of an extension method.
+type ExtensionParameter struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"` // e.g. "string", "integer", "node", or "strings" for a list
+	Description string `json:"description"`
+	Optional    bool   `json:"optional"`
+}
+
+// An ExtensionMethod describes a method of a server extension.
+type ExtensionMethod struct {
+	Extension   string               `json:"-"`
+	Name        string               `json:"name"`
+	Extends     string               `json:"extends"` // "graphdb", "node" or "relationship"
+	Description string               `json:"description"`
+	Parameters  []ExtensionParameter `json:"parameters"`
+	Href        string               `json:"-"`
+}
+
+// ExtensionMethods describes the extension methods offered by the service
+// root, sorted by extension and method name.  Server extensions were removed
+// in Neo4j 4.0.
+func (db *Database) ExtensionMethods() ([]*ExtensionMethod, error) {
+	return db.describeExtensions(db.Extensions)
+}
+
+// ExtensionMethods describes the extension methods offered by the node.
+func (n *Node) ExtensionMethods() ([]*ExtensionMethod, error) {
+	return n.Db.describeExtensions(n.Extensions)
+}
+
+// ExtensionMethods describes the extension methods offered by the
+// relationship.
+func (r *Relationship) ExtensionMethods() ([]*ExtensionMethod, error) {
+	return r.Db.describeExtensions(r.Extensions)
+}
+
+// describeExtensions fetches the description of every method in exts.
+func (db *Database) describeExtensions(exts Extensions) ([]*ExtensionMethod, error) {
+	if err := db.requireREST("Server extensions"); err != nil {
+		return nil, err
+	}
+	methods := []*ExtensionMethod{}
+	for ext, ms := range exts {
+		for name, href := range ms {
+			m := ExtensionMethod{}
+			ne := NeoError{}
+			resp, err := db.Session.Get(href, nil, &m, &ne)
+			if err != nil {
+				return nil, err
+			}
+			if resp.Status() != 200 {
+				return nil, ne
+			}
+			m.Extension = ext
+			m.Href = href
+			if m.Name == "" {
+				m.Name = name
+			}
+			methods = append(methods, &m)
+		}
+	}
+	sort.Slice(methods, func(i, j int) bool {
+		if methods[i].Extension != methods[j].Extension {
+			return methods[i].Extension < methods[j].Extension
+		}
+		return methods[i].Name < methods[j].Name
+	})
+	return methods, nil
+}
+
+// Invoke calls a method of a server extension offered by the service root,
+// and decodes the result into result, which may be nil if the result is not
+// wanted.  Parameters which are nodes or relationships are sent as their
+// URLs, as extensions expect.  Nodes, relationships and paths in the result
+// are usable as if fetched with Node, Relationship and so on, provided result
+// is a pointer to a *Node, *Relationship or *Path, a slice of those, or one
+// of those itself.
+func (db *Database) Invoke(extension, method string, params map[string]interface{}, result interface{}) error {
+	return db.invoke(db.Extensions, extension, method, params, result)
+}
+
+// Invoke calls a method of a server extension offered by the node.  See
+// Database.Invoke.
+func (n *Node) Invoke(extension, method string, params map[string]interface{}, result interface{}) error {
+	return n.Db.invoke(n.Extensions, extension, method, params, result)
+}
+
+// Invoke calls a method of a server extension offered by the relationship.
+// See Database.Invoke.
+func (r *Relationship) Invoke(extension, method string, params map[string]interface{}, result interface{}) error {
+	return r.Db.invoke(r.Extensions, extension, method, params, result)
+}
+
+func (db *Database) invoke(exts Extensions, extension, method string, params map[string]interface{}, result interface{}) error {
+	if err := db.requireREST("Server extensions"); err != nil {
+		return err
+	}
+	href, ok := exts[extension][method]
+	if !ok {
+		return ErrNoExtension
+	}
+	payload := map[string]interface{}{}
+	for k, v := range params {
+		switch e := v.(type) {
+		case *Node:
+			v = e.HrefSelf
+		case *Relationship:
+			v = e.HrefSelf
+		}
+		payload[k] = v
+	}
+	var raw json.RawMessage
+	ne := NeoError{}
+	resp, err := db.Session.Post(href, &payload, &raw, &ne)
+	if err != nil {
+		return err
+	}
+	switch resp.Status() {
+	case 200:
+	case 204:
+		return nil
+	case 404:
+		return NotFound
+	default:
+		return ne
+	}
+	if result == nil || len(raw) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(raw, result); err != nil {
+		return err
+	}
+	db.hydrate(result)
+	return nil
+}
+
+// hydrate sets the Db of the nodes, relationships and paths in result.
+func (db *Database) hydrate(result interface{}) {
+	switch r := result.(type) {
+	case *Node:
+		r.Db = db
+	case **Node:
+		if *r != nil {
+			(*r).Db = db
+		}
+	case *[]*Node:
+		for _, n := range *r {
+			n.Db = db
+		}
+	case *Relationship:
+		r.Db = db
+	case **Relationship:
+		if *r != nil {
+			(*r).Db = db
+		}
+	case *[]*Relationship:
+		for _, rel := range *r {
+			rel.Db = db
+		}
+	case *Path:
+		r.Db = db
+	case **Path:
+		if *r != nil {
+			(*r).Db = db
+		}
+	case *[]*Path:
+		for _, p := range *r {
+			p.Db = db
+		}
+	}
+}
+
+// A Path is a path through the graph, as returned by the REST API, e.g. by
+// a server extension.
+type Path struct {
+	Db                *Database `json:"-"`
+	HrefStart         string    `json:"start"`
+	HrefEnd           string    `json:"end"`
+	HrefNodes         []string  `json:"nodes"`
+	HrefRelationships []string  `json:"relationships"`
+	Length            int       `json:"length"`
+}
+
+// Nodes fetches the nodes along the path, in order.
+func (p *Path) Nodes() ([]*Node, error) {
+	nodes := make([]*Node, len(p.HrefNodes))
+	for i, href := range p.HrefNodes {
+		n, err := p.Db.getNodeByUri(href)
+		if err != nil {
+			return nil, err
+		}
+		nodes[i] = n
+	}
+	return nodes, nil
+}
+
+// Relationships fetches the relationships along the path, in order.
+func (p *Path) Relationships() ([]*Relationship, error) {
+	rels := make([]*Relationship, len(p.HrefRelationships))
+	for i, href := range p.HrefRelationships {
+		r, err := p.Db.getRelationshipByUri(href)
+		if err != nil {
+			return nil, err
+		}
+		rels[i] = r
+	}
+	return rels, nil
+}
@@ -0,0 +1,125 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released under
+// the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for details.
+// Resist intellectual serfdom - the ownership of ideas is akin to slavery.
+
+package neoism
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/jmcvetta/neoism/neoismtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExtensions(t *testing.T) {
+	fake := neoismtest.NewServer()
+	defer fake.Close()
+	var target interface{}
+	fake.AddExtension(neoismtest.ExtensionMethod{
+		Extension:   "GetAll",
+		Name:        "get_all_nodes",
+		Extends:     "graphdb",
+		Description: "Get all nodes from the Neo4j graph database",
+		Invoke: func(id int, params map[string]interface{}) (interface{}, error) {
+			return []neoismtest.NodeID{1, 2}, nil
+		},
+	})
+	fake.AddExtension(neoismtest.ExtensionMethod{
+		Extension:   "ShortestPath",
+		Name:        "shortestPath",
+		Extends:     "node",
+		Description: "Find the shortest path between two nodes",
+		Parameters: []neoismtest.ExtensionParameter{
+			{Name: "target", Type: "node", Description: "The node to find the path to"},
+			{Name: "maxDepth", Type: "integer", Optional: true},
+		},
+		Invoke: func(id int, params map[string]interface{}) (interface{}, error) {
+			target = params["target"]
+			if params["maxDepth"] == 0.0 {
+				return nil, errors.New("maxDepth must be positive")
+			}
+			return neoismtest.Path{
+				Nodes:         []neoismtest.NodeID{neoismtest.NodeID(id), 2},
+				Relationships: []neoismtest.RelationshipID{1},
+			}, nil
+		},
+	})
+	fake.AddExtension(neoismtest.ExtensionMethod{
+		Extension: "Weight",
+		Name:      "weight",
+		Extends:   "relationship",
+		Invoke: func(id int, params map[string]interface{}) (interface{}, error) {
+			return 0.5, nil
+		},
+	})
+	db, err := Connect(fake.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n0, _ := db.CreateNode(Props{"name": "Kirk"})
+	n1, _ := db.CreateNode(Props{"name": "Spock"})
+	r0, err := n0.Relate("knows", n1.Id(), Props{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	//
+	// Discovery
+	//
+	methods, err := db.ExtensionMethods()
+	if assert.Nil(t, err) && assert.Len(t, methods, 1) {
+		assert.Equal(t, "GetAll", methods[0].Extension)
+		assert.Equal(t, "get_all_nodes", methods[0].Name)
+		assert.Equal(t, "graphdb", methods[0].Extends)
+	}
+	methods, err = n0.ExtensionMethods()
+	if assert.Nil(t, err) && assert.Len(t, methods, 1) {
+		m := methods[0]
+		assert.Equal(t, "shortestPath", m.Name)
+		assert.Equal(t, "Find the shortest path between two nodes", m.Description)
+		assert.Equal(t, []ExtensionParameter{
+			{Name: "target", Type: "node", Description: "The node to find the path to"},
+			{Name: "maxDepth", Type: "integer", Optional: true},
+		}, m.Parameters)
+	}
+	//
+	// Invocation
+	//
+	nodes := []*Node{}
+	err = db.Invoke("GetAll", "get_all_nodes", nil, &nodes)
+	if assert.Nil(t, err) && assert.Len(t, nodes, 2) {
+		assert.Equal(t, n1.Id(), nodes[1].Id())
+		props, err := nodes[1].Properties()
+		assert.Nil(t, err)
+		assert.Equal(t, Props{"name": "Spock"}, props)
+	}
+	var p *Path
+	err = n0.Invoke("ShortestPath", "shortestPath", map[string]interface{}{"target": n1}, &p)
+	if assert.Nil(t, err) {
+		assert.Equal(t, n1.HrefSelf, target)
+		assert.Equal(t, 1, p.Length)
+		assert.Equal(t, n1.HrefSelf, p.HrefEnd)
+		rels, err := p.Relationships()
+		if assert.Nil(t, err) && assert.Len(t, rels, 1) {
+			assert.Equal(t, r0.Id(), rels[0].Id())
+		}
+	}
+	err = n0.Invoke("ShortestPath", "shortestPath", map[string]interface{}{"target": n1, "maxDepth": 0}, &p)
+	assert.IsType(t, NeoError{}, err)
+	var weight float64
+	err = r0.Invoke("Weight", "weight", nil, &weight)
+	assert.Nil(t, err)
+	assert.Equal(t, 0.5, weight)
+	assert.Equal(t, ErrNoExtension, db.Invoke("Weight", "weight", nil, nil))
+	//
+	// Extensions were removed in Neo4j 4.0.
+	//
+	fake4 := neoismtest.NewServerVersion("4.4.0")
+	defer fake4.Close()
+	db4, err := Connect(fake4.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db4.ExtensionMethods()
+	assert.IsType(t, &UnsupportedError{}, err)
+}
@@ -0,0 +1,50 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released under
+// the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for details.
+// Resist intellectual serfdom - the ownership of ideas is akin to slavery.
+
+package neoism
+
+// A Querier executes Cypher queries.  Code that depends on a Querier rather
+// than a *Database can be unit tested with a mock, such as the one in package
+// neoismmock.
+type Querier interface {
+	Cypher(q *CypherQuery) error
+	CypherBatch(qs []*CypherQuery) error
+	BeginTransaction(qs []*CypherQuery) (Transaction, error)
+}
+
+// A Transaction is an in-progress database transaction.  *Tx satisfies it.
+type Transaction interface {
+	Query(qs []*CypherQuery) error
+	Commit() error
+	Rollback() error
+}
+
+// A Graph executes Cypher queries and operates on nodes and relationships.
+// *Database satisfies it.
+type Graph interface {
+	Querier
+	CreateNode(p Props) (*Node, error)
+	Node(id int) (*Node, error)
+	GetOrCreateNode(label, key string, p Props) (n *Node, created bool, err error)
+	NodesByLabel(label string) ([]*Node, error)
+	Relationship(id int) (*Relationship, error)
+	Labels() ([]string, error)
+	RelTypes() ([]string, error)
+}
+
+var (
+	_ Graph       = (*Database)(nil)
+	_ Transaction = (*Tx)(nil)
+)
+
+// BeginTransaction is like Begin, but returns the transaction as a
+// Transaction, so *Database satisfies Querier.
+func (db *Database) BeginTransaction(qs []*CypherQuery) (Transaction, error) {
+	tx, err := db.Begin(qs)
+	if tx == nil {
+		// Avoid returning a non-nil interface holding a nil *Tx.
+		return nil, err
+	}
+	return tx, err
+}
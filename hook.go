@@ -0,0 +1,201 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released under
+// the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for details.
+// Resist intellectual serfdom - the ownership of ideas is akin to slavery.
+
+package neoism
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// An EventKind identifies what an Event describes.
+type EventKind int
+
+const (
+	// RequestEvent describes a single HTTP request to the server.
+	RequestEvent EventKind = iota
+	// QueryEvent describes the execution of a Cypher statement by Cypher,
+	// CypherBatch, Begin or Tx.Query.  Statements sent together in one
+	// request share a duration and error.
+	QueryEvent
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case RequestEvent:
+		return "request"
+	case QueryEvent:
+		return "query"
+	}
+	return "unknown"
+}
+
+// An Event describes an operation for hooks.  Before is called with the
+// fields describing the operation filled in; After is called with the same
+// Event, once Status, Duration and Err are also set.
+type Event struct {
+	Kind EventKind
+	// Method, URL and Header describe a RequestEvent.  Neither the URL nor
+	// Header include credentials.  Headers set by Before are sent with the
+	// request, e.g. to propagate a trace ID.
+	Method string
+	URL    string
+	Header http.Header
+	// Statement, Parameters and Query describe a QueryEvent.  Parameters
+	// have been passed through the database's Redactor, if any.
+	Statement  string
+	Parameters map[string]interface{}
+	Query      *CypherQuery
+	Start      time.Time
+	Status     int // HTTP status code of a RequestEvent; zero on error
+	Duration   time.Duration
+	Err        error
+	// Data is for hooks' own use, e.g. to carry a span from Before to
+	// After.  Keys should be unique to the hook.
+	Data map[string]interface{}
+}
+
+// A Hook is called around every REST request and every Cypher statement.
+// Hooks must be safe for concurrent use.
+type Hook interface {
+	Before(e *Event)
+	After(e *Event)
+}
+
+// A HookFunc is a Hook which calls f after each operation.
+type HookFunc func(e *Event)
+
+// Before implements Hook.
+func (f HookFunc) Before(e *Event) {}
+
+// After implements Hook.
+func (f HookFunc) After(e *Event) { f(e) }
+
+// A Redactor returns the value reported to hooks for the query parameter key.
+type Redactor func(key string, value interface{}) interface{}
+
+// Redacted replaces parameter values hidden by a Redactor.
+const Redacted = "[REDACTED]"
+
+// RedactKeys returns a Redactor which hides the values of the named
+// parameters.  Names are matched case-insensitively.
+func RedactKeys(keys ...string) Redactor {
+	m := map[string]bool{}
+	for _, k := range keys {
+		m[strings.ToLower(k)] = true
+	}
+	return func(key string, value interface{}) interface{} {
+		if m[strings.ToLower(key)] {
+			return Redacted
+		}
+		return value
+	}
+}
+
+// hookSet holds a database's hooks.  It is shared by copies of the Database.
+type hookSet struct {
+	mu       sync.RWMutex
+	hooks    []Hook
+	redactor Redactor
+}
+
+// AddHook registers h to be called around every REST request and every Cypher
+// statement.  Hooks are called in the order they were added.  Over Bolt,
+// they see QueryEvents only, as there are no HTTP requests.
+func (db *Database) AddHook(h Hook) {
+	db.hooks.mu.Lock()
+	defer db.hooks.mu.Unlock()
+	db.hooks.hooks = append(db.hooks.hooks, h)
+}
+
+// SetRedactor sets the Redactor applied to query parameters before they are
+// reported to hooks.  Statements carrying passwords, as sent by
+// ChangePassword and CreateUser, are never reported to hooks.
+func (db *Database) SetRedactor(r Redactor) {
+	db.hooks.mu.Lock()
+	defer db.hooks.mu.Unlock()
+	db.hooks.redactor = r
+}
+
+// list returns the registered hooks, or nil if there are none.
+func (hs *hookSet) list() []Hook {
+	if hs == nil {
+		return nil
+	}
+	hs.mu.RLock()
+	defer hs.mu.RUnlock()
+	return hs.hooks
+}
+
+// redact returns a copy of params passed through the redactor.
+func (hs *hookSet) redact(params map[string]interface{}) map[string]interface{} {
+	hs.mu.RLock()
+	r := hs.redactor
+	hs.mu.RUnlock()
+	if r == nil || params == nil {
+		return params
+	}
+	m := make(map[string]interface{}, len(params))
+	for k, v := range params {
+		m[k] = r(k, v)
+	}
+	return m
+}
+
+// observe calls hooks around f, which performs the operation described by e.
+func observe(hooks []Hook, e *Event, f func() error) error {
+	e.Data = map[string]interface{}{}
+	for _, h := range hooks {
+		h.Before(e)
+	}
+	e.Start = time.Now()
+	e.Err = f()
+	e.Duration = time.Since(e.Start)
+	for _, h := range hooks {
+		h.After(e)
+	}
+	return e.Err
+}
+
+// observeQueries calls hooks around f, which executes qs, with a QueryEvent
+// for each query.  Queries carrying credentials are not reported, whatever
+// the Redactor, since hooks also see the query itself.
+func (db *Database) observeQueries(qs []*CypherQuery, f func() error) error {
+	hooks := db.hooks.list()
+	if len(hooks) == 0 {
+		return f()
+	}
+	for _, q := range qs {
+		if q.secret {
+			return f()
+		}
+	}
+	events := make([]*Event, len(qs))
+	for i, q := range qs {
+		events[i] = &Event{
+			Kind:       QueryEvent,
+			Statement:  q.Statement,
+			Parameters: db.hooks.redact(q.Parameters),
+			Query:      q,
+			Data:       map[string]interface{}{},
+		}
+		for _, h := range hooks {
+			h.Before(events[i])
+		}
+	}
+	start := time.Now()
+	err := f()
+	d := time.Since(start)
+	for _, e := range events {
+		e.Start = start
+		e.Duration = d
+		e.Err = err
+		for _, h := range hooks {
+			h.After(e)
+		}
+	}
+	return err
+}
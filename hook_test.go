@@ -0,0 +1,156 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released under
+// the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for details.
+// Resist intellectual serfdom - the ownership of ideas is akin to slavery.
+
+package neoism
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/jmcvetta/neoism/neoismtest"
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingHook struct {
+	sync.Mutex
+	before []*Event
+	after  []*Event
+}
+
+func (h *recordingHook) Before(e *Event) {
+	h.Lock()
+	defer h.Unlock()
+	if e.Kind == RequestEvent {
+		e.Header.Set("X-Trace-Id", "abc123")
+	}
+	h.before = append(h.before, e)
+}
+
+func (h *recordingHook) After(e *Event) {
+	h.Lock()
+	defer h.Unlock()
+	h.after = append(h.after, e)
+}
+
+func (h *recordingHook) events(kind EventKind) []*Event {
+	h.Lock()
+	defer h.Unlock()
+	es := []*Event{}
+	for _, e := range h.after {
+		if e.Kind == kind {
+			es = append(es, e)
+		}
+	}
+	return es
+}
+
+type headerCapture struct {
+	sync.Mutex
+	headers []http.Header
+}
+
+func (c *headerCapture) RoundTrip(req *http.Request) (*http.Response, error) {
+	c.Lock()
+	c.headers = append(c.headers, req.Header)
+	c.Unlock()
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestHooks(t *testing.T) {
+	fake := neoismtest.NewServer()
+	defer fake.Close()
+	fake.HandleCypher(`^MATCH`, neoismtest.CypherResponse{
+		Columns: []string{"n.name"},
+		Rows:    [][]interface{}{{"Kirk"}},
+	})
+	capture := &headerCapture{}
+	db, err := ConnectWithClient(fake.URL, &http.Client{Transport: capture})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &recordingHook{}
+	db.AddHook(h)
+	db.SetRedactor(RedactKeys("Password"))
+	cq := CypherQuery{
+		Statement:  "MATCH (n:User {name: {name}, password: {password}}) RETURN n.name",
+		Parameters: map[string]interface{}{"name": "kirk", "password": "ncc-1701"},
+	}
+	err = db.Cypher(&cq)
+	assert.Nil(t, err)
+	_, err = db.Node(999999)
+	assert.Equal(t, NotFound, err)
+	//
+	// Query events
+	//
+	qe := h.events(QueryEvent)
+	assert.Equal(t, 1, len(qe))
+	assert.Equal(t, cq.Statement, qe[0].Statement)
+	assert.Equal(t, &cq, qe[0].Query)
+	assert.Equal(t, "kirk", qe[0].Parameters["name"])
+	assert.Equal(t, Redacted, qe[0].Parameters["password"])
+	assert.Equal(t, "ncc-1701", cq.Parameters["password"]) // Query is untouched
+	assert.Nil(t, qe[0].Err)
+	//
+	// Request events
+	//
+	re := h.events(RequestEvent)
+	assert.Equal(t, 2, len(re))
+	assert.Equal(t, "POST", re[0].Method)
+	assert.Equal(t, db.HrefCypher, re[0].URL)
+	assert.Equal(t, 200, re[0].Status)
+	assert.Equal(t, "GET", re[1].Method)
+	assert.Equal(t, 404, re[1].Status)
+	assert.Equal(t, h.after[0], h.before[1]) // Before and After see the same events
+	//
+	// Headers set by Before are sent
+	//
+	last := capture.headers[len(capture.headers)-1]
+	assert.Equal(t, "abc123", last.Get("X-Trace-Id"))
+}
+
+func TestHooksWithoutCredentials(t *testing.T) {
+	fake := neoismtest.NewServer()
+	defer fake.Close()
+	fake.SetUser("neo4j", "secret", false)
+	capture := &headerCapture{}
+	client := &http.Client{Transport: capture}
+	db, err := ConnectWithAuth(fake.URL, client, BasicAuth("neo4j", "secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &recordingHook{}
+	db.AddHook(h)
+	_, err = db.Node(999999)
+	assert.Equal(t, NotFound, err)
+	re := h.events(RequestEvent)
+	assert.Equal(t, 1, len(re))
+	assert.Equal(t, "", re[0].Header.Get("Authorization"))
+	assert.Equal(t, "abc123", re[0].Header.Get("X-Trace-Id"))
+	last := capture.headers[len(capture.headers)-1]
+	assert.NotEqual(t, "", last.Get("Authorization"))
+	assert.Equal(t, "abc123", last.Get("X-Trace-Id"))
+}
+
+func TestHookFunc(t *testing.T) {
+	fake := neoismtest.NewServer()
+	defer fake.Close()
+	db, err := Connect(fake.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var es []*Event
+	db.AddHook(HookFunc(func(e *Event) {
+		es = append(es, e)
+	}))
+	tx, err := db.Begin([]*CypherQuery{})
+	assert.Nil(t, err)
+	err = tx.Query([]*CypherQuery{{Statement: "NOT SCRIPTED"}})
+	assert.Equal(t, TxQueryError, err)
+	assert.Equal(t, 3, len(es)) // Begin request, Query request, query
+	assert.Equal(t, RequestEvent, es[1].Kind)
+	assert.Equal(t, QueryEvent, es[2].Kind)
+	assert.Equal(t, "NOT SCRIPTED", es[2].Statement)
+	assert.Equal(t, TxQueryError, es[2].Err)
+}
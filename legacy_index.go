@@ -9,6 +9,9 @@ import (
 )
 
 func (db *Database) createIndex(href, name, idxType, provider string) (*index, error) {
+	if err := db.requireREST("Legacy indexes"); err != nil {
+		return nil, err
+	}
 	idx := new(index)
 	idx.db = db
 	idx.Name = name
@@ -46,6 +49,9 @@ func (db *Database) createIndex(href, name, idxType, provider string) (*index, e
 }
 
 func (db *Database) indexes(href string) ([]*index, error) {
+	if err := db.requireREST("Legacy indexes"); err != nil {
+		return nil, err
+	}
 	res := map[string]indexResponse{}
 	nis := []*index{}
 	ne := NeoError{}
@@ -67,6 +73,9 @@ func (db *Database) indexes(href string) ([]*index, error) {
 }
 
 func (db *Database) index(href, name string) (*index, error) {
+	if err := db.requireREST("Legacy indexes"); err != nil {
+		return nil, err
+	}
 	idx := new(index)
 	idx.db = db
 	idx.Name = name
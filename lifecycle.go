@@ -0,0 +1,180 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released under
+// the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for details.
+// Resist intellectual serfdom - the ownership of ideas is akin to slavery.
+
+package neoism
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+)
+
+// ErrClosed is returned, wrapped in a *url.Error for REST API calls, for work
+// submitted to a Database after Close was called.
+var ErrClosed = errors.New("Database is closed")
+
+// lifecycle tracks a database's in-flight requests and open transactions.  It
+// is shared by copies of the Database.
+type lifecycle struct {
+	mu       sync.Mutex
+	closing  bool
+	closed   bool
+	inFlight int
+	idle     chan struct{} // Closed when inFlight drops to zero
+	txs      map[*Tx]struct{}
+}
+
+func newLifecycle() *lifecycle {
+	return &lifecycle{txs: map[*Tx]struct{}{}}
+}
+
+// enter registers an in-flight request.  Once Close has been called, only
+// requests rolling back an open transaction are allowed.  req is nil for
+// requests which do not use HTTP.
+func (l *lifecycle) enter(req *http.Request) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed || l.closing && !l.isRollback(req) {
+		return ErrClosed
+	}
+	if l.inFlight == 0 {
+		l.idle = make(chan struct{})
+	}
+	l.inFlight++
+	return nil
+}
+
+// isRollback returns true if req rolls back an open transaction.  l.mu must
+// be held.
+func (l *lifecycle) isRollback(req *http.Request) bool {
+	if req == nil || req.Method != "DELETE" {
+		return false
+	}
+	for t := range l.txs {
+		if t.Location == req.URL.String() {
+			return true
+		}
+	}
+	return false
+}
+
+// exit unregisters an in-flight request.
+func (l *lifecycle) exit() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inFlight--
+	if l.inFlight == 0 {
+		close(l.idle)
+	}
+}
+
+// wait waits until no requests are in flight.
+func (l *lifecycle) wait(ctx context.Context) error {
+	for {
+		l.mu.Lock()
+		n, idle := l.inFlight, l.idle
+		l.mu.Unlock()
+		if n == 0 {
+			return nil
+		}
+		select {
+		case <-idle:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// txOpened records that t has been opened.
+func (db *Database) txOpened(t *Tx) {
+	db.stats.txBegun()
+	if l := db.life; l != nil {
+		l.mu.Lock()
+		l.txs[t] = struct{}{}
+		l.mu.Unlock()
+	}
+}
+
+// txEnded records that t has been committed or rolled back, if it has not
+// already ended.  It is safe to call from several goroutines, as Close does
+// while the owner of t may be ending it.
+func (db *Database) txEnded(t *Tx, committed bool) {
+	if !t.end() {
+		return
+	}
+	db.stats.txClosed(committed)
+	if l := db.life; l != nil {
+		l.mu.Lock()
+		delete(l.txs, t)
+		l.mu.Unlock()
+	}
+}
+
+// OpenTransactions returns the transactions opened by Begin which have not
+// yet been committed or rolled back.
+func (db *Database) OpenTransactions() []*Tx {
+	txs := []*Tx{}
+	if db.life == nil {
+		return txs
+	}
+	db.life.mu.Lock()
+	defer db.life.mu.Unlock()
+	for t := range db.life.txs {
+		txs = append(txs, t)
+	}
+	return txs
+}
+
+// Close shuts the database down gracefully: it refuses new work, waits for
+// in-flight requests to finish, rolls back transactions which are still open
+// and closes idle connections.  If ctx is done before in-flight requests
+// finish, open transactions are rolled back anyway and ctx's error is
+// returned.  Transactions the server no longer knows, e.g. because they
+// expired, or which their owner ends meanwhile, are not an error.  Calling Close more than once has no effect.
+func (db *Database) Close(ctx context.Context) error {
+	l := db.life
+	if l == nil {
+		// Not made by Connect, so there is no work to track.
+		if db.Session != nil && db.Session.Client != nil {
+			db.Session.Client.CloseIdleConnections()
+		}
+		if db.bolt != nil {
+			db.bolt.close()
+		}
+		return nil
+	}
+	l.mu.Lock()
+	if l.closing || l.closed {
+		l.mu.Unlock()
+		return nil
+	}
+	l.closing = true
+	l.mu.Unlock()
+	result := l.wait(ctx)
+	for _, t := range db.OpenTransactions() {
+		err := t.Rollback()
+		if err != nil && t.ended() {
+			continue // Ended by its owner meanwhile
+		}
+		db.txEnded(t, false)
+		if err != nil && err != NotFound && result == nil {
+			result = err
+		}
+	}
+	l.mu.Lock()
+	l.closed = true
+	l.mu.Unlock()
+	db.Session.Client.CloseIdleConnections()
+	if db.bolt != nil {
+		db.bolt.close()
+	}
+	return result
+}
@@ -0,0 +1,174 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released under
+// the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for details.
+// Resist intellectual serfdom - the ownership of ideas is akin to slavery.
+
+package neoism
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jmcvetta/neoism/neoismtest"
+	"github.com/stretchr/testify/assert"
+)
+
+// gateTransport holds requests to paths containing substring until the gate
+// is opened.
+type gateTransport struct {
+	substring string
+	held      chan struct{}
+	gate      chan struct{}
+}
+
+func (g *gateTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if strings.Contains(req.URL.Path, g.substring) {
+		g.held <- struct{}{}
+		<-g.gate
+	}
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestClose(t *testing.T) {
+	fake := neoismtest.NewServer()
+	defer fake.Close()
+	gt := &gateTransport{
+		substring: "/labels",
+		held:      make(chan struct{}, 1),
+		gate:      make(chan struct{}),
+	}
+	db, err := ConnectWithClient(fake.URL, &http.Client{Transport: gt})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx0, err := db.Begin([]*CypherQuery{})
+	assert.Nil(t, err)
+	tx1, err := db.Begin([]*CypherQuery{})
+	assert.Nil(t, err)
+	assert.Nil(t, tx1.Commit())
+	assert.Equal(t, []*Tx{tx0}, db.OpenTransactions())
+	//
+	// Close waits for in-flight requests
+	//
+	go db.Labels()
+	<-gt.held
+	closed := make(chan error)
+	go func() {
+		closed <- db.Close(context.Background())
+	}()
+	time.Sleep(10 * time.Millisecond)
+	select {
+	case <-closed:
+		t.Fatal("Close returned with a request in flight")
+	default:
+	}
+	_, err = db.Node(1)
+	assert.True(t, errors.Is(err, ErrClosed))
+	_, err = db.Begin([]*CypherQuery{})
+	assert.True(t, errors.Is(err, ErrClosed))
+	close(gt.gate)
+	assert.Nil(t, <-closed)
+	//
+	// Open transactions were rolled back
+	//
+	assert.Equal(t, 0, len(db.OpenTransactions()))
+	assert.Equal(t, int64(1), db.Stats().Rollbacks)
+	assert.True(t, errors.Is(tx0.Query([]*CypherQuery{}), ErrClosed))
+	assert.Nil(t, db.Close(context.Background()))
+}
+
+func TestCloseExpiredTransactions(t *testing.T) {
+	fake := neoismtest.NewServer()
+	defer fake.Close()
+	db, err := Connect(fake.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Transactions the server has forgotten, as if they expired.
+	tx0, err := db.Begin([]*CypherQuery{})
+	assert.Nil(t, err)
+	tx0.Location += "0"
+	tx1, err := db.Begin([]*CypherQuery{})
+	assert.Nil(t, err)
+	tx1.Location += "0"
+	assert.Equal(t, NotFound, tx0.Query([]*CypherQuery{{Statement: "RETURN 1"}}))
+	assert.Equal(t, []*Tx{tx1}, db.OpenTransactions())
+	assert.Nil(t, db.Close(context.Background()))
+	assert.Equal(t, 0, len(db.OpenTransactions()))
+	assert.Equal(t, int64(2), db.Stats().Rollbacks)
+	//
+	// A Database not made by Connect can be closed too.
+	//
+	assert.Nil(t, (&Database{}).Close(context.Background()))
+}
+
+func TestCloseConcurrentRollback(t *testing.T) {
+	fake := neoismtest.NewServer()
+	defer fake.Close()
+	db, err := Connect(fake.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const n = 10
+	txs := make([]*Tx, n)
+	for i := range txs {
+		txs[i], err = db.Begin([]*CypherQuery{})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The owners roll back while Close does the same; each transaction is
+	// counted once.
+	wg := sync.WaitGroup{}
+	for _, tx := range txs {
+		wg.Add(1)
+		go func(tx *Tx) {
+			defer wg.Done()
+			tx.Rollback()
+		}(tx)
+	}
+	assert.Nil(t, db.Close(context.Background()))
+	wg.Wait()
+	s := db.Stats()
+	assert.Equal(t, int64(0), s.OpenTransactions)
+	assert.Equal(t, int64(n), s.Rollbacks)
+}
+
+func TestCloseTimeout(t *testing.T) {
+	fake := neoismtest.NewServer()
+	defer fake.Close()
+	gt := &gateTransport{
+		substring: "/labels",
+		held:      make(chan struct{}, 1),
+		gate:      make(chan struct{}),
+	}
+	db, err := ConnectWithClient(fake.URL, &http.Client{Transport: gt})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go db.Labels()
+	<-gt.held
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, db.Close(ctx))
+	close(gt.gate)
+}
+
+func TestBoltClose(t *testing.T) {
+	s := newBoltStub(t, 3)
+	defer s.close()
+	db, err := Connect(s.url(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Begin([]*CypherQuery{})
+	assert.Nil(t, err)
+	assert.Nil(t, db.Close(context.Background()))
+	assert.Equal(t, []string{"BEGIN", "ROLLBACK"}, s.messages())
+	assert.Equal(t, ErrClosed, db.Cypher(&CypherQuery{Statement: "RETURN 1"}))
+	assert.Equal(t, 0, len(db.bolt.idle))
+}
@@ -0,0 +1,490 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released under
+// the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for details.
+// Resist intellectual serfdom - the ownership of ideas is akin to slavery.
+
+/*
+Package migrations applies versioned schema and data migrations to a Neo4j
+database using neoism.
+
+Each applied migration is recorded as a :Migration node holding its version,
+name and checksum, created in the same transaction as the migration itself.
+A :MigrationLock node, guarded by a unique constraint, prevents two deployers
+from migrating the same database at the same time.  Migrations require Neo4j
+3.0 or later.
+
+Example Usage:
+
+	db, _ := neoism.Connect("http://localhost:7474/db/data")
+	ms, _ := migrations.LoadDir("db/migrations")
+	m, _ := migrations.New(db, ms...)
+	err := m.Up()
+*/
+package migrations
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmcvetta/neoism"
+)
+
+// One of these errors is returned if migrations cannot be run.  ErrLocked
+// persists if a deployer died while holding the lock; release it with
+// ForceUnlock.
+var (
+	ErrLocked     = errors.New("Migrations are locked by another deployer.")
+	ErrNoDown     = errors.New("Migration cannot be reverted.")
+	ErrNoMigrator = errors.New("Migration has neither a Go function nor Cypher.")
+)
+
+// A Migration is a single versioned change to the database.  It is either a
+// pair of Go functions or a pair of Cypher scripts, which may contain several
+// semicolon-separated statements.  Down is optional; a migration without one
+// cannot be reverted.
+//
+// A migration runs in a transaction in which its :Migration node is also
+// created, or deleted when it is reverted, so either both happen or neither
+// does.  Go functions are passed that transaction, and must run their
+// statements in it.  Neo4j does not allow schema and data changes in the same
+// transaction, so the transaction of a migration that changes the schema is
+// committed before the :Migration node is touched.  Such migrations must set
+// Schema, unless they are Cypher scripts of index and constraint statements
+// only, which are recognised as such.
+type Migration struct {
+	Version    int64
+	Name       string
+	Up         func(db *neoism.Database, tx *neoism.Tx) error
+	Down       func(db *neoism.Database, tx *neoism.Tx) error
+	UpCypher   string
+	DownCypher string
+	Schema     bool
+}
+
+// Checksum returns a hash of the migration's Up Cypher, used to detect
+// migrations that were changed after being applied.  Go migrations have no
+// checksum.
+func (m *Migration) Checksum() string {
+	if m.UpCypher == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(m.UpCypher))
+	return hex.EncodeToString(sum[:])
+}
+
+// up applies the migration in tx.
+func (m *Migration) up(db *neoism.Database, tx *neoism.Tx) error {
+	switch {
+	case m.Up != nil:
+		return m.Up(db, tx)
+	case m.UpCypher != "":
+		return tx.RunScript(m.UpCypher)
+	}
+	return ErrNoMigrator
+}
+
+// down reverts the migration in tx.
+func (m *Migration) down(db *neoism.Database, tx *neoism.Tx) error {
+	switch {
+	case m.Down != nil:
+		return m.Down(db, tx)
+	case m.DownCypher != "":
+		return tx.RunScript(m.DownCypher)
+	}
+	return ErrNoDown
+}
+
+// schemaRegex matches statements which create or drop an index or
+// constraint, e.g. "CREATE INDEX", "CREATE RANGE INDEX" or "DROP CONSTRAINT".
+var schemaRegex = regexp.MustCompile(`(?i)^(CREATE|DROP)(\s+\w+)*?\s+(INDEX|CONSTRAINT)\b`)
+
+// changesSchema reports whether applying, or if up is false reverting, the
+// migration changes the schema.
+func (m *Migration) changesSchema(up bool) bool {
+	if m.Schema {
+		return true
+	}
+	script := m.UpCypher
+	if !up {
+		script = m.DownCypher
+	}
+	stmts, err := neoism.SplitScript(script)
+	if err != nil || len(stmts) == 0 {
+		return false
+	}
+	for _, s := range stmts {
+		if !schemaRegex.MatchString(s.Statement) {
+			return false
+		}
+	}
+	return true
+}
+
+// fileRegex matches migration file names such as "0001_add_people.up.cypher".
+var fileRegex = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.cypher$`)
+
+// LoadDir reads Cypher migrations from the files in dir.  Files must be named
+// VERSION_NAME.up.cypher or VERSION_NAME.down.cypher, e.g.
+// "0001_add_people.up.cypher".  Other files are ignored.
+func LoadDir(dir string) ([]*Migration, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int64]*Migration{}
+	for _, e := range entries {
+		match := fileRegex.FindStringSubmatch(e.Name())
+		if e.IsDir() || match == nil {
+			continue
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		b, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("Migration %d has conflicting names %q and %q", version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.UpCypher = string(b)
+		} else {
+			m.DownCypher = string(b)
+		}
+	}
+	ms := []*Migration{}
+	for _, m := range byVersion {
+		if m.UpCypher == "" {
+			return nil, fmt.Errorf("Migration %d has no up file", m.Version)
+		}
+		ms = append(ms, m)
+	}
+	sort.Sort(byVersionOrder(ms))
+	return ms, nil
+}
+
+type byVersionOrder []*Migration
+
+func (s byVersionOrder) Len() int           { return len(s) }
+func (s byVersionOrder) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byVersionOrder) Less(i, j int) bool { return s[i].Version < s[j].Version }
+
+// A Migrator applies an ordered set of migrations to a database.
+type Migrator struct {
+	db         *neoism.Database
+	migrations []*Migration
+	// Owner identifies this deployer in the migration lock.  Defaults to
+	// hostname and process ID.
+	Owner string
+}
+
+// New returns a Migrator for the given migrations, which are sorted by
+// version.  Versions must be unique.
+func New(db *neoism.Database, ms ...*Migration) (*Migrator, error) {
+	sorted := make([]*Migration, len(ms))
+	copy(sorted, ms)
+	sort.Sort(byVersionOrder(sorted))
+	for i, m := range sorted {
+		if i > 0 && sorted[i-1].Version == m.Version {
+			return nil, fmt.Errorf("Duplicate migration version %d", m.Version)
+		}
+	}
+	host, _ := os.Hostname()
+	mg := Migrator{
+		db:         db,
+		migrations: sorted,
+		Owner:      fmt.Sprintf("%s:%d", host, os.Getpid()),
+	}
+	return &mg, nil
+}
+
+// A Status describes a known migration and whether it has been applied.
+type Status struct {
+	Version   int64
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+	// Modified is true if the migration was changed after being applied.
+	Modified bool
+}
+
+// record is a :Migration node as returned by Cypher.
+type record struct {
+	Version   int64  `json:"version"`
+	Name      string `json:"name"`
+	Checksum  string `json:"checksum"`
+	AppliedAt string `json:"applied_at"`
+}
+
+// applied fetches the records of all applied migrations, keyed by version.
+func (mg *Migrator) applied() (map[int64]record, error) {
+	res := []record{}
+	cq := neoism.CypherQuery{
+		Statement: `
+			MATCH (m:Migration)
+			RETURN m.version AS version, m.name AS name,
+				m.checksum AS checksum, m.applied_at AS applied_at
+		`,
+		Result: &res,
+	}
+	err := mg.db.Cypher(&cq)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[int64]record, len(res))
+	for _, r := range res {
+		m[r.Version] = r
+	}
+	return m, nil
+}
+
+// Status reports on every known migration, in version order.
+func (mg *Migrator) Status() ([]Status, error) {
+	applied, err := mg.applied()
+	if err != nil {
+		return nil, err
+	}
+	ss := make([]Status, len(mg.migrations))
+	for i, m := range mg.migrations {
+		ss[i] = Status{Version: m.Version, Name: m.Name}
+		r, ok := applied[m.Version]
+		if !ok {
+			continue
+		}
+		ss[i].Applied = true
+		ss[i].AppliedAt, _ = time.Parse(time.RFC3339, r.AppliedAt)
+		ss[i].Modified = r.Checksum != m.Checksum()
+	}
+	return ss, nil
+}
+
+// Up applies all pending migrations in version order.  It refuses to run if an
+// applied migration has been modified since it was applied.
+func (mg *Migrator) Up() error {
+	return mg.locked(func() error {
+		applied, err := mg.applied()
+		if err != nil {
+			return err
+		}
+		for _, m := range mg.migrations {
+			if r, ok := applied[m.Version]; ok && r.Checksum != m.Checksum() {
+				return fmt.Errorf("Migration %d (%s) was modified after being applied", m.Version, m.Name)
+			}
+		}
+		for _, m := range mg.migrations {
+			if _, ok := applied[m.Version]; ok {
+				continue
+			}
+			err := mg.run(m.up, m.changesSchema(true), recordUp(m))
+			if err != nil {
+				return fmt.Errorf("Migration %d (%s) failed: %s", m.Version, m.Name, err)
+			}
+		}
+		return nil
+	})
+}
+
+// Down reverts the most recently applied migration.  It does nothing if no
+// migrations have been applied.
+func (mg *Migrator) Down() error {
+	return mg.locked(func() error {
+		applied, err := mg.applied()
+		if err != nil {
+			return err
+		}
+		for i := len(mg.migrations) - 1; i >= 0; i-- {
+			m := mg.migrations[i]
+			if _, ok := applied[m.Version]; !ok {
+				continue
+			}
+			err := mg.run(m.down, m.changesSchema(false), recordDown(m))
+			if err != nil {
+				return fmt.Errorf("Reverting migration %d (%s) failed: %s", m.Version, m.Name, err)
+			}
+			return nil
+		}
+		return nil
+	})
+}
+
+// run applies or reverts a migration with f, then runs record, all in one
+// transaction; or, if schema is true, runs record once the transaction has
+// been committed.
+func (mg *Migrator) run(f func(db *neoism.Database, tx *neoism.Tx) error, schema bool, record *neoism.CypherQuery) error {
+	tx, err := mg.db.Begin([]*neoism.CypherQuery{})
+	if err != nil {
+		return err
+	}
+	err = f(mg.db, tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if schema {
+		err = tx.Commit()
+		if err != nil {
+			return err
+		}
+		return mg.db.Cypher(record)
+	}
+	err = tx.Query([]*neoism.CypherQuery{record})
+	if err == neoism.TxQueryError && len(tx.Errors) > 0 {
+		err = &tx.Errors[len(tx.Errors)-1]
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// recordUp returns the statement creating the :Migration node for an applied
+// migration.
+func recordUp(m *Migration) *neoism.CypherQuery {
+	return &neoism.CypherQuery{
+		Statement: `
+			CREATE (:Migration {version: $version, name: $name,
+				checksum: $checksum, applied_at: $applied_at})
+		`,
+		Parameters: neoism.Props{
+			"version":    m.Version,
+			"name":       m.Name,
+			"checksum":   m.Checksum(),
+			"applied_at": time.Now().UTC().Format(time.RFC3339),
+		},
+	}
+}
+
+// recordDown returns the statement deleting the :Migration node for a
+// reverted migration.
+func recordDown(m *Migration) *neoism.CypherQuery {
+	return &neoism.CypherQuery{
+		Statement:  `MATCH (m:Migration {version: $version}) DELETE m`,
+		Parameters: neoism.Props{"version": m.Version},
+	}
+}
+
+// lockName is the name of the :MigrationLock node.
+const lockName = "migrations"
+
+// locked runs f while holding the migration lock.  If the lock cannot be
+// released, that is reported along with any error from f.
+func (mg *Migrator) locked(f func() error) error {
+	err := mg.lock()
+	if err != nil {
+		return err
+	}
+	err = f()
+	uerr := mg.unlock()
+	switch {
+	case uerr == nil:
+		return err
+	case err == nil:
+		return fmt.Errorf("Releasing the migration lock: %w", uerr)
+	}
+	return fmt.Errorf("%w (releasing the migration lock also failed: %v)", err, uerr)
+}
+
+// lock acquires the migration lock, returning ErrLocked if another deployer
+// holds it.  The unique constraint on :MigrationLock(name) guarantees that
+// concurrent MERGEs cannot both create a lock node.
+func (mg *Migrator) lock() error {
+	err := mg.lockConstraint()
+	if err != nil {
+		return err
+	}
+	res := []struct {
+		Owner string `json:"owner"`
+	}{}
+	cq := neoism.CypherQuery{
+		Statement: `
+			MERGE (l:MigrationLock {name: $name})
+			ON CREATE SET l.owner = $owner, l.acquired_at = $now
+			RETURN l.owner AS owner
+		`,
+		Parameters: neoism.Props{
+			"name":  lockName,
+			"owner": mg.Owner,
+			"now":   time.Now().UTC().Format(time.RFC3339),
+		},
+		Result: &res,
+	}
+	err = mg.db.Cypher(&cq)
+	if err != nil {
+		return err
+	}
+	if len(res) != 1 || res[0].Owner != mg.Owner {
+		return ErrLocked
+	}
+	return nil
+}
+
+// lockConstraint creates the unique constraint on :MigrationLock(name), if
+// it does not exist yet, with the schema REST API or, on servers without it,
+// with Cypher.
+func (mg *Migrator) lockConstraint() error {
+	if mg.db.Capabilities().SchemaREST {
+		_, err := mg.db.CreateUniqueConstraint("MigrationLock", "name")
+		if err != nil && err != neoism.NotAllowed {
+			return err // NotAllowed means the constraint already exists
+		}
+		return nil
+	}
+	current, err := mg.db.CurrentSchema()
+	if err != nil {
+		return err
+	}
+	for _, c := range current.UniqueConstraints {
+		if c.Label == "MigrationLock" && len(c.PropertyKeys) == 1 && c.PropertyKeys[0] == "name" {
+			return nil
+		}
+	}
+	plan := neoism.SchemaPlan{{
+		Action:       neoism.SchemaCreateUniqueConstraint,
+		Label:        "MigrationLock",
+		PropertyKeys: []string{"name"},
+	}}
+	_, err = mg.db.Apply(plan, false)
+	if te, ok := err.(*neoism.TxError); ok && strings.HasSuffix(te.Code, "AlreadyExists") {
+		return nil // Created meanwhile by another deployer
+	}
+	return err
+}
+
+// unlock releases the migration lock if it is held by this Migrator.
+func (mg *Migrator) unlock() error {
+	cq := neoism.CypherQuery{
+		Statement: `
+			MATCH (l:MigrationLock {name: $name})
+			WHERE l.owner = $owner
+			DELETE l
+		`,
+		Parameters: neoism.Props{"name": lockName, "owner": mg.Owner},
+	}
+	return mg.db.Cypher(&cq)
+}
+
+// ForceUnlock releases the migration lock regardless of who holds it.  Use it
+// to recover from a deployer that died while migrating.
+func (mg *Migrator) ForceUnlock() error {
+	cq := neoism.CypherQuery{
+		Statement:  `MATCH (l:MigrationLock {name: $name}) DELETE l`,
+		Parameters: neoism.Props{"name": lockName},
+	}
+	return mg.db.Cypher(&cq)
+}
@@ -0,0 +1,105 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released under
+// the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for details.
+// Resist intellectual serfdom - the ownership of ideas is akin to slavery.
+
+package neoism
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jmcvetta/neoism/neoismtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnectNeo4j4(t *testing.T) {
+	fake := neoismtest.NewServerVersion("4.4.0")
+	defer fake.Close()
+	fake.HandleCypher("^SHOW DEFAULT DATABASE", neoismtest.CypherResponse{
+		Columns: []string{"name"},
+		Rows:    [][]interface{}{{"movies"}},
+	})
+	fake.HandleCypher("^RETURN 1", neoismtest.CypherResponse{
+		Columns: []string{"n"},
+		Rows:    [][]interface{}{{1}},
+	})
+	db, err := Connect(fake.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "4.4.0", db.Version)
+	assert.Equal(t, "movies", db.DatabaseName)
+	assert.Equal(t, fake.URL+"/db/movies/tx", db.HrefTransaction)
+	//
+	// Cypher, batches and transactions use the transactional endpoint.
+	//
+	res := []struct{ N int }{}
+	cq := CypherQuery{Statement: "RETURN 1 AS n", Result: &res}
+	assert.Nil(t, db.Cypher(&cq))
+	assert.Equal(t, 1, len(res))
+	assert.Nil(t, db.CypherBatch([]*CypherQuery{{Statement: "RETURN 1"}, {Statement: "RETURN 1"}}))
+	system, err := db.Use("system")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "system", system.DatabaseName)
+	tx, err := system.Begin([]*CypherQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, strings.HasPrefix(tx.Location, fake.URL+"/db/system/tx/"), tx.Location)
+	assert.Nil(t, tx.Commit())
+	assert.Equal(t, "movies", db.DatabaseName)
+	//
+	// The REST API is gone.
+	//
+	_, err = db.CreateNode(Props{})
+	if ue, ok := err.(*UnsupportedError); assert.True(t, ok, "%v", err) {
+		assert.Equal(t, "CreateNode", ue.Feature)
+		assert.Equal(t, "4.4.0", ue.Version)
+	}
+	_, err = db.LegacyNodeIndexes()
+	assert.IsType(t, &UnsupportedError{}, err)
+	_, err = db.Relationship(1)
+	assert.IsType(t, &UnsupportedError{}, err)
+}
+
+func TestUseNeo4j3(t *testing.T) {
+	fake := neoismtest.NewServer()
+	defer fake.Close()
+	db, err := Connect(fake.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "", db.DatabaseName)
+	_, err = db.Use("system")
+	assert.IsType(t, &UnsupportedError{}, err)
+}
+
+func TestDefaultDatabase(t *testing.T) {
+	//
+	// Neo4j 4.0 - 4.2 reject SHOW DEFAULT DATABASE as a syntax error.
+	//
+	fake := neoismtest.NewServerVersion("4.0.0")
+	defer fake.Close()
+	db, err := Connect(fake.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "neo4j", db.DatabaseName)
+	//
+	// Other errors are not hidden.
+	//
+	fake44 := neoismtest.NewServerVersion("4.4.0")
+	defer fake44.Close()
+	fake44.HandleCypher("^SHOW DEFAULT DATABASE", neoismtest.CypherResponse{
+		Error: &neoismtest.CypherError{
+			Code:    "Neo.ClientError.Security.Forbidden",
+			Message: "Permission denied.",
+		},
+	})
+	_, err = Connect(fake44.URL)
+	if te, ok := err.(*TxError); assert.True(t, ok, "%v", err) {
+		assert.Equal(t, "Neo.ClientError.Security.Forbidden", te.Code)
+	}
+}
@@ -0,0 +1,368 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released under
+// the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for details.
+// Resist intellectual serfdom - the ownership of ideas is akin to slavery.
+
+// Package neoismmock provides an in-memory mock of neoism.Graph, for unit
+// testing code which depends on the neoism.Graph, neoism.Querier or
+// neoism.Transaction interfaces rather than on a *neoism.Database.
+//
+// Each method of Graph and Tx calls the corresponding function field, e.g.
+// CypherFunc, if it is set.  Otherwise Cypher queries are answered from
+// expectations registered with ExpectCypher, and all other methods return
+// ErrUnexpected.  Every call is recorded:
+//
+//	g := neoismmock.New()
+//	g.ExpectCypher(`^MATCH \(n:Person\)`).Return([]string{"n.name"}, []interface{}{"Kirk"})
+//	err := codeUnderTest(g)
+//	g.AssertExpectations(t)
+package neoismmock
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+
+	"github.com/jmcvetta/neoism"
+)
+
+// ErrUnexpected is returned by calls for which no function or expectation
+// has been provided.
+var ErrUnexpected = errors.New("neoismmock: unexpected call")
+
+// A Call is a recorded method call.
+type Call struct {
+	Method string
+	Args   []interface{}
+}
+
+// An Expectation answers Cypher statements matching a regular expression.
+type Expectation struct {
+	pattern *regexp.Regexp
+	columns []string
+	rows    [][]interface{}
+	err     error
+	times   int
+	calls   int
+}
+
+// Return sets the columns and rows returned for matching statements.
+func (e *Expectation) Return(columns []string, rows ...[]interface{}) *Expectation {
+	e.columns = columns
+	e.rows = rows
+	return e
+}
+
+// ReturnError makes matching statements fail with err.
+func (e *Expectation) ReturnError(err error) *Expectation {
+	e.err = err
+	return e
+}
+
+// Times limits the expectation to n matching statements, after which it no
+// longer matches.  AssertExpectations then requires exactly n.  By default an
+// expectation matches any number of statements, and AssertExpectations
+// requires at least one.
+func (e *Expectation) Times(n int) *Expectation {
+	e.times = n
+	return e
+}
+
+// exhausted returns true if the expectation may not match any more statements.
+func (e *Expectation) exhausted() bool {
+	return e.times > 0 && e.calls >= e.times
+}
+
+// unmet returns a description of why the expectation is not met, or "".
+func (e *Expectation) unmet() string {
+	switch {
+	case e.times > 0 && e.calls != e.times:
+		return fmt.Sprintf("expected %d statements matching %q, got %d", e.times, e.pattern, e.calls)
+	case e.calls == 0:
+		return fmt.Sprintf("expected a statement matching %q", e.pattern)
+	}
+	return ""
+}
+
+// A Graph is a mock implementation of neoism.Graph.
+type Graph struct {
+	CypherFunc           func(q *neoism.CypherQuery) error
+	CypherBatchFunc      func(qs []*neoism.CypherQuery) error
+	BeginTransactionFunc func(qs []*neoism.CypherQuery) (neoism.Transaction, error)
+	CreateNodeFunc       func(p neoism.Props) (*neoism.Node, error)
+	NodeFunc             func(id int) (*neoism.Node, error)
+	GetOrCreateNodeFunc  func(label, key string, p neoism.Props) (*neoism.Node, bool, error)
+	NodesByLabelFunc     func(label string) ([]*neoism.Node, error)
+	RelationshipFunc     func(id int) (*neoism.Relationship, error)
+	LabelsFunc           func() ([]string, error)
+	RelTypesFunc         func() ([]string, error)
+
+	mu           sync.Mutex
+	calls        []Call
+	expectations []*Expectation
+}
+
+var _ neoism.Graph = (*Graph)(nil)
+
+// New returns a Graph without functions or expectations.
+func New() *Graph {
+	return &Graph{}
+}
+
+// ExpectCypher registers an expectation for statements matching the regular
+// expression pattern, executed by Cypher, CypherBatch, BeginTransaction or
+// Tx.Query.  Expectations are tried in the order they were registered.  A
+// matching statement without a Return or ReturnError gets an empty result.
+func (g *Graph) ExpectCypher(pattern string) *Expectation {
+	e := &Expectation{pattern: regexp.MustCompile(pattern)}
+	g.mu.Lock()
+	g.expectations = append(g.expectations, e)
+	g.mu.Unlock()
+	return e
+}
+
+// TB is the subset of testing.TB used by AssertExpectations.
+type TB interface {
+	Helper()
+	Errorf(format string, args ...interface{})
+}
+
+// AssertExpectations reports an error to t for every expectation which was
+// not met.  It returns false if any were not.
+func (g *Graph) AssertExpectations(t TB) bool {
+	t.Helper()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	ok := true
+	for _, e := range g.expectations {
+		if msg := e.unmet(); msg != "" {
+			t.Errorf("neoismmock: %s", msg)
+			ok = false
+		}
+	}
+	return ok
+}
+
+// Calls returns all recorded calls, in order.
+func (g *Graph) Calls() []Call {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]Call{}, g.calls...)
+}
+
+// CallsTo returns the recorded calls to method, e.g. "Cypher" or "Tx.Commit".
+func (g *Graph) CallsTo(method string) []Call {
+	calls := []Call{}
+	for _, c := range g.Calls() {
+		if c.Method == method {
+			calls = append(calls, c)
+		}
+	}
+	return calls
+}
+
+// Statements returns the text of every Cypher statement executed, in order.
+func (g *Graph) Statements() []string {
+	stmts := []string{}
+	for _, c := range g.Calls() {
+		for _, a := range c.Args {
+			switch a := a.(type) {
+			case *neoism.CypherQuery:
+				stmts = append(stmts, a.Statement)
+			case []*neoism.CypherQuery:
+				for _, q := range a {
+					stmts = append(stmts, q.Statement)
+				}
+			}
+		}
+	}
+	return stmts
+}
+
+func (g *Graph) record(method string, args ...interface{}) {
+	g.mu.Lock()
+	g.calls = append(g.calls, Call{Method: method, Args: args})
+	g.mu.Unlock()
+}
+
+// query answers qs from the registered expectations.
+func (g *Graph) query(qs []*neoism.CypherQuery) error {
+	for _, q := range qs {
+		g.mu.Lock()
+		var match *Expectation
+		for _, e := range g.expectations {
+			if !e.exhausted() && e.pattern.MatchString(q.Statement) {
+				match = e
+				match.calls++
+				break
+			}
+		}
+		g.mu.Unlock()
+		if match == nil {
+			return fmt.Errorf("%w: no expectation matches %q", ErrUnexpected, q.Statement)
+		}
+		if match.err != nil {
+			return match.err
+		}
+		err := q.SetResult(match.columns, match.rows)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Cypher implements neoism.Querier.
+func (g *Graph) Cypher(q *neoism.CypherQuery) error {
+	g.record("Cypher", q)
+	if g.CypherFunc != nil {
+		return g.CypherFunc(q)
+	}
+	return g.query([]*neoism.CypherQuery{q})
+}
+
+// CypherBatch implements neoism.Querier.
+func (g *Graph) CypherBatch(qs []*neoism.CypherQuery) error {
+	g.record("CypherBatch", qs)
+	if g.CypherBatchFunc != nil {
+		return g.CypherBatchFunc(qs)
+	}
+	return g.query(qs)
+}
+
+// BeginTransaction implements neoism.Querier.  Unless BeginTransactionFunc is
+// set, it returns a *Tx.
+func (g *Graph) BeginTransaction(qs []*neoism.CypherQuery) (neoism.Transaction, error) {
+	g.record("BeginTransaction", qs)
+	if g.BeginTransactionFunc != nil {
+		return g.BeginTransactionFunc(qs)
+	}
+	tx := &Tx{g: g}
+	return tx, g.query(qs)
+}
+
+// CreateNode implements neoism.Graph.
+func (g *Graph) CreateNode(p neoism.Props) (*neoism.Node, error) {
+	g.record("CreateNode", p)
+	if g.CreateNodeFunc != nil {
+		return g.CreateNodeFunc(p)
+	}
+	return nil, ErrUnexpected
+}
+
+// Node implements neoism.Graph.
+func (g *Graph) Node(id int) (*neoism.Node, error) {
+	g.record("Node", id)
+	if g.NodeFunc != nil {
+		return g.NodeFunc(id)
+	}
+	return nil, ErrUnexpected
+}
+
+// GetOrCreateNode implements neoism.Graph.
+func (g *Graph) GetOrCreateNode(label, key string, p neoism.Props) (*neoism.Node, bool, error) {
+	g.record("GetOrCreateNode", label, key, p)
+	if g.GetOrCreateNodeFunc != nil {
+		return g.GetOrCreateNodeFunc(label, key, p)
+	}
+	return nil, false, ErrUnexpected
+}
+
+// NodesByLabel implements neoism.Graph.
+func (g *Graph) NodesByLabel(label string) ([]*neoism.Node, error) {
+	g.record("NodesByLabel", label)
+	if g.NodesByLabelFunc != nil {
+		return g.NodesByLabelFunc(label)
+	}
+	return nil, ErrUnexpected
+}
+
+// Relationship implements neoism.Graph.
+func (g *Graph) Relationship(id int) (*neoism.Relationship, error) {
+	g.record("Relationship", id)
+	if g.RelationshipFunc != nil {
+		return g.RelationshipFunc(id)
+	}
+	return nil, ErrUnexpected
+}
+
+// Labels implements neoism.Graph.
+func (g *Graph) Labels() ([]string, error) {
+	g.record("Labels")
+	if g.LabelsFunc != nil {
+		return g.LabelsFunc()
+	}
+	return nil, ErrUnexpected
+}
+
+// RelTypes implements neoism.Graph.
+func (g *Graph) RelTypes() ([]string, error) {
+	g.record("RelTypes")
+	if g.RelTypesFunc != nil {
+		return g.RelTypesFunc()
+	}
+	return nil, ErrUnexpected
+}
+
+// A Tx is a mock implementation of neoism.Transaction, returned by
+// Graph.BeginTransaction.  Its calls are recorded by the Graph, with methods
+// named "Tx.Query", "Tx.Commit" and "Tx.Rollback".
+type Tx struct {
+	QueryFunc    func(qs []*neoism.CypherQuery) error
+	CommitFunc   func() error
+	RollbackFunc func() error
+
+	g          *Graph
+	committed  bool // Guarded by g.mu
+	rolledBack bool // Guarded by g.mu
+}
+
+var _ neoism.Transaction = (*Tx)(nil)
+
+// Query implements neoism.Transaction.
+func (tx *Tx) Query(qs []*neoism.CypherQuery) error {
+	tx.g.record("Tx.Query", qs)
+	if tx.QueryFunc != nil {
+		return tx.QueryFunc(qs)
+	}
+	return tx.g.query(qs)
+}
+
+// Commit implements neoism.Transaction.
+func (tx *Tx) Commit() error {
+	tx.g.record("Tx.Commit")
+	if tx.CommitFunc != nil {
+		return tx.CommitFunc()
+	}
+	tx.g.mu.Lock()
+	tx.committed = true
+	tx.g.mu.Unlock()
+	return nil
+}
+
+// Rollback implements neoism.Transaction.
+func (tx *Tx) Rollback() error {
+	tx.g.record("Tx.Rollback")
+	if tx.RollbackFunc != nil {
+		return tx.RollbackFunc()
+	}
+	tx.g.mu.Lock()
+	tx.rolledBack = true
+	tx.g.mu.Unlock()
+	return nil
+}
+
+// Committed reports whether Commit has succeeded, unless CommitFunc is set.
+func (tx *Tx) Committed() bool {
+	tx.g.mu.Lock()
+	defer tx.g.mu.Unlock()
+	return tx.committed
+}
+
+// RolledBack reports whether Rollback has succeeded, unless RollbackFunc is
+// set.
+func (tx *Tx) RolledBack() bool {
+	tx.g.mu.Lock()
+	defer tx.g.mu.Unlock()
+	return tx.rolledBack
+}
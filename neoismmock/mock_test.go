@@ -0,0 +1,100 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released under
+// the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for details.
+// Resist intellectual serfdom - the ownership of ideas is akin to slavery.
+
+package neoismmock
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/jmcvetta/neoism"
+	"github.com/stretchr/testify/assert"
+)
+
+// names is an example of code under test, depending only on a Querier.
+func names(q neoism.Querier, label string) ([]string, error) {
+	res := []struct {
+		Name string `json:"n.name"`
+	}{}
+	cq := neoism.CypherQuery{
+		Statement: "MATCH (n:" + label + ") RETURN n.name",
+		Result:    &res,
+	}
+	err := q.Cypher(&cq)
+	if err != nil {
+		return nil, err
+	}
+	s := []string{}
+	for _, r := range res {
+		s = append(s, r.Name)
+	}
+	return s, nil
+}
+
+func TestExpectCypher(t *testing.T) {
+	g := New()
+	g.ExpectCypher(`^MATCH \(n:Person\)`).Return([]string{"n.name"}, []interface{}{"Kirk"}, []interface{}{"McCoy"})
+	g.ExpectCypher(`^MATCH \(n:Ship\)`).ReturnError(neoism.NotFound).Times(1)
+	s, err := names(g, "Person")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"Kirk", "McCoy"}, s)
+	_, err = names(g, "Ship")
+	assert.Equal(t, neoism.NotFound, err)
+	_, err = names(g, "Ship")
+	assert.NotNil(t, err) // Expectation exhausted
+	assert.Equal(t, 3, len(g.CallsTo("Cypher")))
+	assert.Equal(t, 3, len(g.Statements()))
+	//
+	// Unmet expectations are reported
+	//
+	g.ExpectCypher(`^CREATE`)
+	rec := &recordingTB{}
+	assert.False(t, g.AssertExpectations(rec))
+	assert.Equal(t, 1, len(rec.errors)) // CREATE never executed
+}
+
+func TestTx(t *testing.T) {
+	g := New()
+	g.ExpectCypher(`^CREATE`)
+	tx, err := g.BeginTransaction([]*neoism.CypherQuery{{Statement: "CREATE (n)"}})
+	assert.Nil(t, err)
+	assert.Nil(t, tx.Query([]*neoism.CypherQuery{{Statement: "CREATE (m)"}}))
+	assert.Nil(t, tx.Commit())
+	assert.True(t, tx.(*Tx).Committed())
+	assert.False(t, tx.(*Tx).RolledBack())
+	assert.Equal(t, []string{"CREATE (n)", "CREATE (m)"}, g.Statements())
+	assert.Equal(t, 1, len(g.CallsTo("Tx.Commit")))
+	assert.True(t, g.AssertExpectations(t))
+	err = tx.Query([]*neoism.CypherQuery{{Statement: "MATCH (n) RETURN n"}})
+	assert.True(t, errors.Is(err, ErrUnexpected), "%v", err)
+}
+
+func TestFuncs(t *testing.T) {
+	g := New()
+	_, err := g.Labels()
+	assert.Equal(t, ErrUnexpected, err)
+	g.LabelsFunc = func() ([]string, error) {
+		return []string{"Person"}, nil
+	}
+	labels, err := g.Labels()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"Person"}, labels)
+	g.CypherFunc = func(q *neoism.CypherQuery) error {
+		return errors.New("boom")
+	}
+	_, err = names(g, "Person")
+	assert.Equal(t, "boom", err.Error())
+	assert.Equal(t, 3, len(g.Calls()))
+}
+
+type recordingTB struct {
+	errors []string
+}
+
+func (r *recordingTB) Helper() {}
+
+func (r *recordingTB) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,98 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released under
+// the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for details.
+// Resist intellectual serfdom - the ownership of ideas is akin to slavery.
+
+package neoismtest
+
+import (
+	"net/http"
+	"strings"
+)
+
+type user struct {
+	name           string
+	password       string
+	changeRequired bool
+}
+
+// SetUser makes the fake require authentication as the user, with HTTP
+// basic authentication or a token given to SetBearerToken.  If
+// changeRequired is true, the fake refuses all requests but those to the
+// user REST API until the password is changed, as Neo4j 2.2 to 3.x do for the
+// initial password.  The discovery document at the root is served to
+// anyone.
+func (s *Server) SetUser(name, password string, changeRequired bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.user = &user{name: name, password: password, changeRequired: changeRequired}
+}
+
+// SetBearerToken makes the fake accept a bearer token in place of the
+// user's password.  It has no effect unless SetUser is called too.
+func (s *Server) SetBearerToken(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bearer = token
+}
+
+// Password returns the user's current password.
+func (s *Server) Password() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.user == nil {
+		return ""
+	}
+	return s.user.password
+}
+
+// authenticate checks the credentials of r, and returns an error response if
+// they are refused.  The caller must hold s.mu.
+func (s *Server) authenticate(r *http.Request) *response {
+	if s.user == nil || r.URL.Path == "/" {
+		return nil
+	}
+	name, password, ok := r.BasicAuth()
+	bearer := s.bearer != "" && r.Header.Get("Authorization") == "Bearer "+s.bearer
+	if !bearer && (!ok || name != s.user.name || password != s.user.password) {
+		resp := neoError(401, "Neo.ClientError.Security.Unauthorized", "Invalid username or password.")
+		return &resp
+	}
+	if s.user.changeRequired && !strings.HasPrefix(r.URL.Path, "/user/") {
+		resp := neoError(403, "Neo.ClientError.Security.Forbidden", "User is required to change their password.")
+		resp.body.(map[string]interface{})["password_change"] = s.URL + "/user/" + s.user.name + "/password"
+		return &resp
+	}
+	return nil
+}
+
+// serveUser handles the user REST API, with the path relative to /user/.
+// The caller must hold s.mu.
+func (s *Server) serveUser(method, p string, body []byte) response {
+	parts := strings.Split(strings.Trim(p, "/"), "/")
+	if s.user == nil || parts[0] != s.user.name {
+		return notFound("No such user: " + parts[0])
+	}
+	r := request{method: method, body: body}
+	switch {
+	case len(parts) == 1 && method == "GET":
+		return response{status: 200, body: map[string]interface{}{
+			"username":                 s.user.name,
+			"password_change_required": s.user.changeRequired,
+			"password_change":          s.URL + "/user/" + s.user.name + "/password",
+		}}
+	case len(parts) == 2 && parts[1] == "password" && method == "POST":
+		payload := struct {
+			Password string `json:"password"`
+		}{}
+		if err := r.decode(&payload); err != nil || payload.Password == "" {
+			return neoError(422, "Neo.ClientError.Request.InvalidFormat", "Required parameter 'password' is missing.")
+		}
+		if payload.Password == s.user.password {
+			return neoError(422, "Neo.ClientError.General.InvalidArguments", "Old password and new password cannot be the same.")
+		}
+		s.user.password = payload.Password
+		s.user.changeRequired = false
+		return response{status: 200}
+	}
+	return notAllowed()
+}
@@ -0,0 +1,52 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released under
+// the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for details.
+// Resist intellectual serfdom - the ownership of ideas is akin to slavery.
+
+package neoismtest
+
+// Cluster roles, for SetClusterRole.
+const (
+	Standalone  = ""
+	Leader      = "LEADER"
+	Follower    = "FOLLOWER"
+	ReadReplica = "READ_REPLICA"
+)
+
+const managePath = "/db/manage/server/"
+
+// SetClusterRole sets the role the fake reports from the causal clustering
+// status endpoints, /db/manage/server/core/* and
+// /db/manage/server/read-replica/*, or /db/{databaseName}/cluster/* from
+// version 4 on.  A Standalone fake, the default, serves none of them.
+//
+// The fake does not refuse writes when it is not the leader; script a
+// Neo.ClientError.Cluster.NotALeader error with HandleCypher to simulate that.
+func (s *Server) SetClusterRole(role string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.role = role
+}
+
+// serveClusterStatus handles a status endpoint, with its path relative to
+// /db/manage/server/.  The caller must hold s.mu.
+func (s *Server) serveClusterStatus(p string) response {
+	var ok bool
+	switch {
+	case s.role == Standalone:
+		return notFound("No such resource: " + managePath + p)
+	case p == "core/available":
+		ok = s.role == Leader || s.role == Follower
+	case p == "core/writable":
+		ok = s.role == Leader
+	case p == "core/read-only":
+		ok = s.role == Follower || s.role == ReadReplica && s.major() >= 4
+	case p == "read-replica/available":
+		ok = s.role == ReadReplica
+	default:
+		return notFound("No such resource: " + managePath + p)
+	}
+	if !ok {
+		return response{status: 404, body: false}
+	}
+	return response{status: 200, body: true}
+}
@@ -0,0 +1,252 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released under
+// the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for details.
+// Resist intellectual serfdom - the ownership of ideas is akin to slavery.
+
+package neoismtest
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// A CypherResponse is the scripted result of a Cypher statement.
+type CypherResponse struct {
+	Columns []string
+	Rows    [][]interface{}
+	// Stats are returned when the client asks for them, keyed as the server
+	// does, e.g. "nodes_created".
+	Stats map[string]interface{}
+	// Plan, if not nil, is returned as the statement's execution plan by the
+	// transactional endpoint.
+	Plan          map[string]interface{}
+	Notifications []map[string]interface{}
+	// If Error is not nil, the statement fails.
+	Error *CypherError
+}
+
+// A CypherError is an error returned for a failed statement.
+type CypherError struct {
+	Code    string
+	Message string
+}
+
+// A Responder computes the response to a Cypher statement.
+type Responder func(statement string, parameters map[string]interface{}) CypherResponse
+
+type responder struct {
+	re *regexp.Regexp
+	f  Responder
+}
+
+// HandleCypher scripts the response to any statement matching the regular
+// expression pattern.  Responders are tried in the order they were added.
+func (s *Server) HandleCypher(pattern string, resp CypherResponse) {
+	s.HandleCypherFunc(pattern, func(string, map[string]interface{}) CypherResponse {
+		return resp
+	})
+}
+
+// HandleCypherFunc registers f to respond to any statement matching the
+// regular expression pattern.
+func (s *Server) HandleCypherFunc(pattern string, f Responder) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.responders = append(s.responders, &responder{re: regexp.MustCompile(pattern), f: f})
+}
+
+// Statements returns the Cypher statements received so far, in order.
+func (s *Server) Statements() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.statements...)
+}
+
+// respond finds the scripted response to a statement.  The caller must hold
+// s.mu; it is released while the responder runs.
+func (s *Server) respond(stmt string, params map[string]interface{}) CypherResponse {
+	s.statements = append(s.statements, stmt)
+	for _, r := range s.responders {
+		if r.re.MatchString(stmt) {
+			s.mu.Unlock()
+			defer s.mu.Lock()
+			return r.f(stmt, params)
+		}
+	}
+	return CypherResponse{Error: &CypherError{
+		Code:    "Neo.ClientError.Statement.SyntaxError",
+		Message: "neoismtest: no response scripted for statement: " + stmt,
+	}}
+}
+
+// stats returns the response's stats, or empty stats if none were scripted.
+func (c *CypherResponse) stats() map[string]interface{} {
+	if c.Stats == nil {
+		return map[string]interface{}{"contains_updates": false}
+	}
+	return c.Stats
+}
+
+// serveCypher handles the legacy /cypher endpoint.
+func (s *Server) serveCypher(r request) response {
+	if r.method != "POST" || len(r.seg) != 1 {
+		return notAllowed()
+	}
+	payload := struct {
+		Query  string                 `json:"query"`
+		Params map[string]interface{} `json:"params"`
+	}{}
+	err := r.decode(&payload)
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	c := s.respond(payload.Query, payload.Params)
+	if c.Error != nil {
+		return neoError(400, c.Error.Code, c.Error.Message)
+	}
+	data := c.Rows
+	if data == nil {
+		data = [][]interface{}{}
+	}
+	columns := c.Columns
+	if columns == nil {
+		columns = []string{}
+	}
+	body := map[string]interface{}{
+		"columns": columns,
+		"data":    data,
+	}
+	if r.query.Get("includeStats") == "true" {
+		body["stats"] = c.stats()
+	}
+	return response{status: 200, body: body}
+}
+
+type txStatement struct {
+	Statement    string                 `json:"statement"`
+	Parameters   map[string]interface{} `json:"parameters"`
+	IncludeStats bool                   `json:"includeStats"`
+}
+
+// serveTransaction handles the transactional endpoint.
+func (s *Server) serveTransaction(r request) response {
+	seg := r.seg
+	if r.method == "DELETE" && len(seg) == 2 {
+		id, err := strconv.Atoi(seg[1])
+		if err != nil || !s.txs[id] {
+			return s.txNotFound()
+		}
+		delete(s.txs, id)
+		return response{status: 200, body: s.txBody(r.txBase, 0, nil, nil, nil)}
+	}
+	if r.method != "POST" {
+		return notAllowed()
+	}
+	id := 0
+	commit := false
+	switch {
+	case len(seg) == 1:
+		// Begin
+	case len(seg) == 2 && seg[1] == "commit":
+		commit = true
+	case len(seg) == 2 || (len(seg) == 3 && seg[2] == "commit"):
+		var err error
+		id, err = strconv.Atoi(seg[1])
+		if err != nil || !s.txs[id] {
+			return s.txNotFound()
+		}
+		commit = len(seg) == 3
+	default:
+		return notFound("No such resource")
+	}
+	payload := struct {
+		Statements []txStatement `json:"statements"`
+	}{}
+	err := r.decode(&payload)
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	results := []interface{}{}
+	notifications := []interface{}{}
+	errs := []interface{}{}
+	for _, st := range payload.Statements {
+		c := s.respond(st.Statement, st.Parameters)
+		if c.Error != nil {
+			errs = append(errs, map[string]string{"code": c.Error.Code, "message": c.Error.Message})
+			break
+		}
+		data := []interface{}{}
+		for _, row := range c.Rows {
+			data = append(data, map[string]interface{}{"row": row, "meta": []interface{}{}})
+		}
+		columns := c.Columns
+		if columns == nil {
+			columns = []string{}
+		}
+		res := map[string]interface{}{"columns": columns, "data": data}
+		if st.IncludeStats {
+			res["stats"] = c.stats()
+		}
+		if c.Plan != nil {
+			res["plan"] = map[string]interface{}{"root": c.Plan}
+		}
+		results = append(results, res)
+		for _, n := range c.Notifications {
+			notifications = append(notifications, n)
+		}
+	}
+	status := 200
+	location := ""
+	switch {
+	case len(errs) > 0 || commit:
+		// A failed statement rolls back the transaction.
+		delete(s.txs, id)
+		id = 0
+		if len(seg) == 1 {
+			status = 201
+		}
+	case id == 0:
+		s.nextTx++
+		id = s.nextTx
+		s.txs[id] = true
+		status = 201
+		location = fmt.Sprintf("%s/%d", r.txBase, id)
+	}
+	resp := response{status: status, location: location, body: s.txBody(r.txBase, id, results, notifications, errs)}
+	return resp
+}
+
+// txBody builds the response body for the transactional endpoint at base.  If
+// id is not zero, the transaction is still open.
+func (s *Server) txBody(base string, id int, results, notifications, errs []interface{}) map[string]interface{} {
+	if results == nil {
+		results = []interface{}{}
+	}
+	if errs == nil {
+		errs = []interface{}{}
+	}
+	body := map[string]interface{}{
+		"results": results,
+		"errors":  errs,
+	}
+	if len(notifications) > 0 {
+		body["notifications"] = notifications
+	}
+	if id != 0 {
+		body["commit"] = fmt.Sprintf("%s/%d/commit", base, id)
+		body["transaction"] = map[string]string{
+			"expires": time.Now().Add(time.Minute).UTC().Format(http.TimeFormat),
+		}
+	}
+	return body
+}
+
+func (s *Server) txNotFound() response {
+	errs := []interface{}{map[string]string{
+		"code":    "Neo.ClientError.Transaction.TransactionNotFound",
+		"message": "Unrecognized transaction id. Transaction may have timed out and been rolled back.",
+	}}
+	return response{status: 404, body: s.txBody("", 0, nil, nil, errs)}
+}
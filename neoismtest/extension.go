@@ -0,0 +1,215 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released under
+// the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for details.
+// Resist intellectual serfdom - the ownership of ideas is akin to slavery.
+
+package neoismtest
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+// An ExtensionParameter describes a parameter of an ExtensionMethod.
+type ExtensionParameter struct {
+	Name        string
+	Type        string
+	Description string
+	Optional    bool
+}
+
+// An ExtensionMethod is a server extension method served by the fake.
+type ExtensionMethod struct {
+	Extension   string
+	Name        string
+	Extends     string // "graphdb", "node" or "relationship"
+	Description string
+	Parameters  []ExtensionParameter
+	// Invoke computes the result of calling the method on the node or
+	// relationship with the given ID, or 0 if it extends graphdb.  If it
+	// returns an error, the call fails with status 400.  NodeIDs,
+	// RelationshipIDs and Paths in the result, also in slices, are returned
+	// in their REST representation.
+	Invoke func(id int, params map[string]interface{}) (interface{}, error)
+}
+
+// A NodeID in an extension result is returned as the node with that ID.
+type NodeID int
+
+// A RelationshipID in an extension result is returned as the relationship
+// with that ID.
+type RelationshipID int
+
+// A Path in an extension result is returned as a path along the nodes and
+// relationships.
+type Path struct {
+	Nodes         []NodeID
+	Relationships []RelationshipID
+}
+
+// AddExtension makes the fake offer an extension method, on the service
+// root, or on every node or relationship, as m.Extends says.
+func (s *Server) AddExtension(m ExtensionMethod) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.extensions = append(s.extensions, &m)
+}
+
+// extensionHrefs returns the extension methods which extend kind, as listed
+// in the REST representation of the service root, a node or a relationship.
+// The caller must hold s.mu.
+func (s *Server) extensionHrefs(kind string, id int) map[string]interface{} {
+	exts := map[string]interface{}{}
+	for _, m := range s.extensions {
+		if m.Extends != kind {
+			continue
+		}
+		ms, ok := exts[m.Extension].(map[string]string)
+		if !ok {
+			ms = map[string]string{}
+			exts[m.Extension] = ms
+		}
+		ms[m.Name] = s.extensionHref(m, id)
+	}
+	return exts
+}
+
+func (s *Server) extensionHref(m *ExtensionMethod, id int) string {
+	if m.Extends == "graphdb" {
+		return s.href("ext", m.Extension, "graphdb", m.Name)
+	}
+	return s.href("ext", m.Extension, m.Extends, id, m.Name)
+}
+
+// serveExtension handles /ext/{extension}/graphdb/{method} and
+// /ext/{extension}/{node|relationship}/{id}/{method}.  The caller must hold
+// s.mu; it is released while the method runs.
+func (s *Server) serveExtension(r request) response {
+	if len(r.seg) < 4 {
+		return response{status: 200, body: map[string]interface{}{}}
+	}
+	kind := r.seg[2]
+	id := 0
+	name := r.seg[3]
+	if kind != "graphdb" {
+		if len(r.seg) != 5 {
+			return notFound("No such extension method")
+		}
+		var err error
+		id, err = strconv.Atoi(r.seg[3])
+		if err != nil {
+			return notFound("No such extension method")
+		}
+		name = r.seg[4]
+		if kind == "node" && s.nodes[id] == nil || kind == "relationship" && s.rels[id] == nil {
+			return notFound("No such " + kind)
+		}
+	}
+	var m *ExtensionMethod
+	for _, e := range s.extensions {
+		if e.Extension == r.seg[1] && e.Extends == kind && e.Name == name {
+			m = e
+			break
+		}
+	}
+	if m == nil {
+		return notFound("No such extension method")
+	}
+	switch r.method {
+	case "GET":
+		params := make([]map[string]interface{}, len(m.Parameters))
+		for i, p := range m.Parameters {
+			params[i] = map[string]interface{}{
+				"name":        p.Name,
+				"type":        p.Type,
+				"description": p.Description,
+				"optional":    p.Optional,
+			}
+		}
+		return response{status: 200, body: map[string]interface{}{
+			"name":        m.Name,
+			"extends":     m.Extends,
+			"description": m.Description,
+			"parameters":  params,
+		}}
+	case "POST":
+		params := map[string]interface{}{}
+		if len(r.body) > 0 {
+			if err := json.Unmarshal(r.body, &params); err != nil {
+				return badRequest(err.Error())
+			}
+		}
+		s.mu.Unlock()
+		result, err := m.Invoke(id, params)
+		s.mu.Lock()
+		if err != nil {
+			return neoError(400, "BadInputException", err.Error())
+		}
+		if result == nil {
+			return response{status: 204}
+		}
+		return response{status: 200, body: s.extensionResult(result)}
+	}
+	return notAllowed()
+}
+
+// extensionResult replaces the NodeIDs, RelationshipIDs and Paths in an
+// extension result with their REST representation.  The caller must hold
+// s.mu.
+func (s *Server) extensionResult(v interface{}) interface{} {
+	switch r := v.(type) {
+	case NodeID:
+		if n := s.nodes[int(r)]; n != nil {
+			return s.nodeRepr(n)
+		}
+		return nil
+	case RelationshipID:
+		if rl := s.rels[int(r)]; rl != nil {
+			return s.relRepr(rl)
+		}
+		return nil
+	case Path:
+		nodes := make([]string, len(r.Nodes))
+		for i, id := range r.Nodes {
+			nodes[i] = s.href("node", int(id))
+		}
+		rels := make([]string, len(r.Relationships))
+		for i, id := range r.Relationships {
+			rels[i] = s.href("relationship", int(id))
+		}
+		p := map[string]interface{}{
+			"nodes":         nodes,
+			"relationships": rels,
+			"length":        len(rels),
+		}
+		if len(nodes) > 0 {
+			p["start"] = nodes[0]
+			p["end"] = nodes[len(nodes)-1]
+		}
+		return p
+	case []NodeID:
+		res := make([]interface{}, len(r))
+		for i, id := range r {
+			res[i] = s.extensionResult(id)
+		}
+		return res
+	case []RelationshipID:
+		res := make([]interface{}, len(r))
+		for i, id := range r {
+			res[i] = s.extensionResult(id)
+		}
+		return res
+	case []Path:
+		res := make([]interface{}, len(r))
+		for i, p := range r {
+			res[i] = s.extensionResult(p)
+		}
+		return res
+	case []interface{}:
+		res := make([]interface{}, len(r))
+		for i, e := range r {
+			res[i] = s.extensionResult(e)
+		}
+		return res
+	}
+	return v
+}
@@ -0,0 +1,436 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released under
+// the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for details.
+// Resist intellectual serfdom - the ownership of ideas is akin to slavery.
+
+package neoismtest
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type node struct {
+	id     int
+	props  map[string]interface{}
+	labels []string
+}
+
+type rel struct {
+	id         int
+	typ        string
+	start, end int
+	props      map[string]interface{}
+}
+
+// nodeRepr returns the REST representation of a node.
+func (s *Server) nodeRepr(n *node) map[string]interface{} {
+	self := s.href("node", n.id)
+	labels := append([]string{}, n.labels...)
+	return map[string]interface{}{
+		"self":                         self,
+		"property":                     self + "/properties/{key}",
+		"properties":                   self + "/properties",
+		"data":                         n.props,
+		"extensions":                   s.extensionHrefs("node", n.id),
+		"labels":                       self + "/labels",
+		"create_relationship":          self + "/relationships",
+		"all_relationships":            self + "/relationships/all",
+		"incoming_relationships":       self + "/relationships/in",
+		"outgoing_relationships":       self + "/relationships/out",
+		"all_typed_relationships":      self + "/relationships/all/{-list|&|types}",
+		"incoming_typed_relationships": self + "/relationships/in/{-list|&|types}",
+		"outgoing_typed_relationships": self + "/relationships/out/{-list|&|types}",
+		"traverse":                     self + "/traverse/{returnType}",
+		"paged_traverse":               self + "/paged/traverse/{returnType}{?pageSize,leaseTime}",
+		"metadata": map[string]interface{}{
+			"id":     n.id,
+			"labels": labels,
+		},
+	}
+}
+
+// relRepr returns the REST representation of a relationship.
+func (s *Server) relRepr(r *rel) map[string]interface{} {
+	self := s.href("relationship", r.id)
+	return map[string]interface{}{
+		"self":       self,
+		"property":   self + "/properties/{key}",
+		"properties": self + "/properties",
+		"type":       r.typ,
+		"start":      s.href("node", r.start),
+		"end":        s.href("node", r.end),
+		"data":       r.props,
+		"extensions": s.extensionHrefs("relationship", r.id),
+		"metadata": map[string]interface{}{
+			"id":   r.id,
+			"type": r.typ,
+		},
+	}
+}
+
+// idFromHref extracts the trailing numeric ID from an entity URL.
+func idFromHref(href string) (int, bool) {
+	parts := strings.Split(strings.TrimRight(href, "/"), "/")
+	id, err := strconv.Atoi(parts[len(parts)-1])
+	return id, err == nil
+}
+
+// setProps replaces an entity's properties, recording the keys used.
+func (s *Server) setProps(props map[string]interface{}) (map[string]interface{}, bool) {
+	m := map[string]interface{}{}
+	for k, v := range props {
+		if v == nil {
+			return nil, false // Neo4j does not store null properties
+		}
+		s.propertyKeys[k] = true
+		m[k] = v
+	}
+	return m, true
+}
+
+func (s *Server) createNode(props map[string]interface{}) (*node, bool) {
+	m, ok := s.setProps(props)
+	if !ok {
+		return nil, false
+	}
+	s.nextNode++
+	n := &node{id: s.nextNode, props: m}
+	s.nodes[n.id] = n
+	return n, true
+}
+
+// serveNode handles requests under /node.
+func (s *Server) serveNode(r request) response {
+	seg := r.seg
+	if len(seg) == 1 {
+		if r.method != "POST" {
+			return notAllowed()
+		}
+		props := map[string]interface{}{}
+		err := r.decode(&props)
+		if err != nil {
+			return badRequest(err.Error())
+		}
+		n, ok := s.createNode(props)
+		if !ok {
+			return badRequest("Could not set property, null is not a valid value")
+		}
+		return response{status: 201, location: s.href("node", n.id), body: s.nodeRepr(n)}
+	}
+	id, err := strconv.Atoi(seg[1])
+	if err != nil {
+		return notFound("Invalid node ID " + seg[1])
+	}
+	n, ok := s.nodes[id]
+	if !ok {
+		return notFound("Cannot find node with id [" + seg[1] + "] in database.")
+	}
+	if len(seg) == 2 {
+		switch r.method {
+		case "GET":
+			return response{status: 200, body: s.nodeRepr(n)}
+		case "DELETE":
+			for _, rl := range s.rels {
+				if rl.start == id || rl.end == id {
+					return neoError(409, "Neo.ClientError.Schema.ConstraintValidationFailed",
+						"The node with id "+seg[1]+" cannot be deleted. Check that the node is orphaned before deletion.")
+				}
+			}
+			delete(s.nodes, id)
+			s.unindex("node", id)
+			return response{status: 204}
+		}
+		return notAllowed()
+	}
+	switch seg[2] {
+	case "properties":
+		return s.serveProperties(r, n.props, seg[3:])
+	case "relationships":
+		return s.serveNodeRels(r, n, seg[3:])
+	case "labels":
+		return s.serveNodeLabels(r, n, seg[3:])
+	}
+	return notFound("No such resource")
+}
+
+// serveProperties handles requests for an entity's properties.  rest is the
+// path following "properties".
+func (s *Server) serveProperties(r request, props map[string]interface{}, rest []string) response {
+	if len(rest) == 0 {
+		switch r.method {
+		case "GET":
+			if len(props) == 0 {
+				return response{status: 204}
+			}
+			return response{status: 200, body: props}
+		case "PUT":
+			m := map[string]interface{}{}
+			err := r.decode(&m)
+			if err != nil {
+				return badRequest(err.Error())
+			}
+			m, ok := s.setProps(m)
+			if !ok {
+				return badRequest("Could not set property, null is not a valid value")
+			}
+			for k := range props {
+				delete(props, k)
+			}
+			for k, v := range m {
+				props[k] = v
+			}
+			return response{status: 204}
+		case "DELETE":
+			for k := range props {
+				delete(props, k)
+			}
+			return response{status: 204}
+		}
+		return notAllowed()
+	}
+	key := rest[0]
+	switch r.method {
+	case "GET":
+		v, ok := props[key]
+		if !ok {
+			return notFound("No such property, '" + key + "'.")
+		}
+		return response{status: 200, body: v}
+	case "PUT":
+		var v interface{}
+		err := r.decode(&v)
+		if err != nil || v == nil {
+			return badRequest("Could not set property, invalid value")
+		}
+		s.propertyKeys[key] = true
+		props[key] = v
+		return response{status: 204}
+	case "DELETE":
+		if _, ok := props[key]; !ok {
+			return notFound("No such property, '" + key + "'.")
+		}
+		delete(props, key)
+		return response{status: 204}
+	}
+	return notAllowed()
+}
+
+// serveNodeRels handles requests for a node's relationships.  rest is the
+// path following "relationships".
+func (s *Server) serveNodeRels(r request, n *node, rest []string) response {
+	if len(rest) == 0 {
+		if r.method != "POST" {
+			return notAllowed()
+		}
+		payload := struct {
+			To   string                 `json:"to"`
+			Type string                 `json:"type"`
+			Data map[string]interface{} `json:"data"`
+		}{}
+		err := r.decode(&payload)
+		if err != nil {
+			return badRequest(err.Error())
+		}
+		endID, ok := idFromHref(payload.To)
+		if _, exists := s.nodes[endID]; !ok || !exists {
+			return badRequest("Node specified by the 'to' URI does not exist")
+		}
+		if payload.Type == "" {
+			return badRequest("Relationship type must not be empty")
+		}
+		props, ok := s.setProps(payload.Data)
+		if !ok {
+			return badRequest("Could not set property, null is not a valid value")
+		}
+		s.nextRel++
+		rl := &rel{id: s.nextRel, typ: payload.Type, start: n.id, end: endID, props: props}
+		s.rels[rl.id] = rl
+		s.relTypes[rl.typ] = true
+		return response{status: 201, location: s.href("relationship", rl.id), body: s.relRepr(rl)}
+	}
+	if r.method != "GET" {
+		return notAllowed()
+	}
+	dir := rest[0]
+	types := map[string]bool{}
+	if len(rest) > 1 {
+		for _, t := range strings.Split(rest[1], "&") {
+			types[t] = true
+		}
+	}
+	ids := []int{}
+	for id, rl := range s.rels {
+		match := false
+		switch dir {
+		case "all":
+			match = rl.start == n.id || rl.end == n.id
+		case "in":
+			match = rl.end == n.id
+		case "out":
+			match = rl.start == n.id
+		default:
+			return notFound("No such direction: " + dir)
+		}
+		if match && (len(types) == 0 || types[rl.typ]) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	reprs := []interface{}{}
+	for _, id := range ids {
+		reprs = append(reprs, s.relRepr(s.rels[id]))
+	}
+	return response{status: 200, body: reprs}
+}
+
+// serveNodeLabels handles requests for a node's labels.  rest is the path
+// following "labels".
+func (s *Server) serveNodeLabels(r request, n *node, rest []string) response {
+	if len(rest) == 1 {
+		if r.method != "DELETE" {
+			return notAllowed()
+		}
+		labels := []string{}
+		for _, l := range n.labels {
+			if l != rest[0] {
+				labels = append(labels, l)
+			}
+		}
+		n.labels = labels
+		return response{status: 204}
+	}
+	if r.method == "GET" {
+		return response{status: 200, body: append([]string{}, n.labels...)}
+	}
+	if r.method != "POST" && r.method != "PUT" {
+		return notAllowed()
+	}
+	var v interface{}
+	err := r.decode(&v)
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	labels := []string{}
+	switch v := v.(type) {
+	case string:
+		labels = append(labels, v)
+	case []interface{}:
+		for _, l := range v {
+			str, ok := l.(string)
+			if !ok {
+				return badRequest("Label names must be strings")
+			}
+			labels = append(labels, str)
+		}
+	default:
+		return badRequest("Label names must be strings")
+	}
+	for _, l := range labels {
+		if l == "" {
+			return badRequest("Unable to add label, invalid label name")
+		}
+	}
+	if r.method == "PUT" {
+		n.labels = nil
+	}
+	for _, l := range labels {
+		if err := s.checkConstraints(n, l); err != nil {
+			return *err
+		}
+		if !hasString(n.labels, l) {
+			n.labels = append(n.labels, l)
+		}
+		s.labels[l] = true
+	}
+	return response{status: 204}
+}
+
+func hasString(ss []string, s string) bool {
+	for _, x := range ss {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+// serveRelationship handles requests under /relationship.
+func (s *Server) serveRelationship(r request) response {
+	seg := r.seg
+	if len(seg) == 2 && seg[1] == "types" {
+		if r.method != "GET" {
+			return notAllowed()
+		}
+		return response{status: 200, body: sortedKeys(s.relTypes)}
+	}
+	if len(seg) < 2 {
+		return notAllowed()
+	}
+	id, err := strconv.Atoi(seg[1])
+	if err != nil {
+		return notFound("Invalid relationship ID " + seg[1])
+	}
+	rl, ok := s.rels[id]
+	if !ok {
+		return notFound("Cannot find relationship with id [" + seg[1] + "] in database.")
+	}
+	if len(seg) == 2 {
+		switch r.method {
+		case "GET":
+			return response{status: 200, body: s.relRepr(rl)}
+		case "DELETE":
+			delete(s.rels, id)
+			s.unindex("relationship", id)
+			return response{status: 204}
+		}
+		return notAllowed()
+	}
+	if seg[2] == "properties" {
+		return s.serveProperties(r, rl.props, seg[3:])
+	}
+	return notFound("No such resource")
+}
+
+// serveLabels handles /labels, /label/{name}/nodes and /propertykeys.
+func (s *Server) serveLabels(r request) response {
+	if r.method != "GET" {
+		return notAllowed()
+	}
+	seg := r.seg
+	switch {
+	case seg[0] == "labels" && len(seg) == 1:
+		return response{status: 200, body: sortedKeys(s.labels)}
+	case seg[0] == "propertykeys" && len(seg) == 1:
+		return response{status: 200, body: sortedKeys(s.propertyKeys)}
+	case seg[0] == "label" && len(seg) == 3 && seg[2] == "nodes":
+		reprs := []interface{}{}
+		for _, id := range s.nodeIDs() {
+			n := s.nodes[id]
+			if hasString(n.labels, seg[1]) {
+				reprs = append(reprs, s.nodeRepr(n))
+			}
+		}
+		return response{status: 200, body: reprs}
+	}
+	return notFound("No such resource")
+}
+
+// nodeIDs returns the IDs of all nodes, in order.
+func (s *Server) nodeIDs() []int {
+	ids := make([]int, 0, len(s.nodes))
+	for id := range s.nodes {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
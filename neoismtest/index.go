@@ -0,0 +1,435 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released under
+// the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for details.
+// Resist intellectual serfdom - the ownership of ideas is akin to slavery.
+
+package neoismtest
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A legacyIndex is a legacy (pre-2.0) node or relationship index.
+type legacyIndex struct {
+	config  map[string]string
+	entries []indexEntry
+}
+
+type indexEntry struct {
+	key   string
+	value string
+	id    int
+}
+
+// indexRepr returns the REST representation of a legacy index.
+func (s *Server) indexRepr(kind, name string, idx *legacyIndex) map[string]interface{} {
+	m := map[string]interface{}{
+		"template": s.href("index", kind, name) + "/{key}/{value}",
+	}
+	for k, v := range idx.config {
+		m[k] = v
+	}
+	return m
+}
+
+// unindex removes all entries for a deleted entity.
+func (s *Server) unindex(kind string, id int) {
+	for _, idx := range s.indexes[kind] {
+		idx.remove("", "", id)
+	}
+}
+
+// remove deletes the entries for an entity, optionally restricted to a key
+// and value.
+func (idx *legacyIndex) remove(key, value string, id int) {
+	entries := []indexEntry{}
+	for _, e := range idx.entries {
+		if e.id == id && (key == "" || e.key == key) && (value == "" || e.value == value) {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	idx.entries = entries
+}
+
+// find returns the IDs of entities matching key and value.  The value may
+// contain '*' wildcards.
+func (idx *legacyIndex) find(key, value string) []int {
+	ids := []int{}
+	seen := map[int]bool{}
+	for _, e := range idx.entries {
+		if e.key != key || seen[e.id] {
+			continue
+		}
+		if ok, _ := path.Match(value, e.value); ok || e.value == value {
+			ids = append(ids, e.id)
+			seen[e.id] = true
+		}
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// query evaluates a simple Lucene query, made of key:value terms joined by
+// AND or OR, with AND binding tighter.  Parentheses are not supported.
+func (idx *legacyIndex) query(q string) ([]int, bool) {
+	union := map[int]bool{}
+	for _, clause := range strings.Split(q, " OR ") {
+		var matches map[int]bool
+		for _, term := range strings.Split(clause, " AND ") {
+			parts := strings.SplitN(strings.TrimSpace(term), ":", 2)
+			if len(parts) != 2 {
+				return nil, false
+			}
+			found := map[int]bool{}
+			for _, id := range idx.find(parts[0], parts[1]) {
+				if matches == nil || matches[id] {
+					found[id] = true
+				}
+			}
+			matches = found
+		}
+		for id := range matches {
+			union[id] = true
+		}
+	}
+	ids := []int{}
+	for id := range union {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids, true
+}
+
+// repr returns the REST representation of the entity of the given kind.
+func (s *Server) repr(kind string, id int) (interface{}, bool) {
+	if kind == "node" {
+		n, ok := s.nodes[id]
+		if !ok {
+			return nil, false
+		}
+		return s.nodeRepr(n), true
+	}
+	rl, ok := s.rels[id]
+	if !ok {
+		return nil, false
+	}
+	return s.relRepr(rl), true
+}
+
+// reprs returns the REST representations of the entities with the given IDs.
+func (s *Server) reprs(kind string, ids []int) []interface{} {
+	result := []interface{}{}
+	for _, id := range ids {
+		if r, ok := s.repr(kind, id); ok {
+			result = append(result, r)
+		}
+	}
+	return result
+}
+
+// serveIndex handles requests under /index.
+func (s *Server) serveIndex(r request) response {
+	seg := r.seg
+	if len(seg) < 2 || s.indexes[seg[1]] == nil {
+		return notFound("No such resource")
+	}
+	kind := seg[1]
+	indexes := s.indexes[kind]
+	if len(seg) == 2 {
+		switch r.method {
+		case "GET":
+			if len(indexes) == 0 {
+				return response{status: 204}
+			}
+			m := map[string]interface{}{}
+			for name, idx := range indexes {
+				m[name] = s.indexRepr(kind, name, idx)
+			}
+			return response{status: 200, body: m}
+		case "POST":
+			payload := struct {
+				Name   string            `json:"name"`
+				Config map[string]string `json:"config"`
+			}{}
+			err := r.decode(&payload)
+			if err != nil || payload.Name == "" {
+				return badRequest("Index name must be supplied")
+			}
+			idx, ok := indexes[payload.Name]
+			if !ok {
+				idx = &legacyIndex{config: payload.Config}
+				indexes[payload.Name] = idx
+			}
+			return response{
+				status:   201,
+				location: s.href("index", kind, payload.Name),
+				body:     s.indexRepr(kind, payload.Name, idx),
+			}
+		}
+		return notAllowed()
+	}
+	name := seg[2]
+	idx, ok := indexes[name]
+	if r.method == "POST" && len(seg) == 3 {
+		if r.query.Get("uniqueness") != "" {
+			return s.getOrCreate(r, kind, name)
+		}
+		if !ok {
+			return notFound("No index named " + name)
+		}
+		return s.addToIndex(r, kind, name, idx)
+	}
+	if !ok {
+		return notFound("No index named " + name)
+	}
+	rest := seg[3:]
+	switch r.method {
+	case "GET":
+		switch len(rest) {
+		case 0:
+			q := r.query.Get("query")
+			if q == "" {
+				return response{status: 200, body: s.indexRepr(kind, name, idx)}
+			}
+			ids, ok := idx.query(q)
+			if !ok {
+				return badRequest("Unsupported query " + q)
+			}
+			return response{status: 200, body: s.reprs(kind, ids)}
+		case 2:
+			return response{status: 200, body: s.reprs(kind, idx.find(rest[0], rest[1]))}
+		}
+	case "DELETE":
+		if len(rest) == 0 {
+			delete(indexes, name)
+			return response{status: 204}
+		}
+		id, err := strconv.Atoi(rest[len(rest)-1])
+		if err != nil {
+			return notFound("Invalid ID " + rest[len(rest)-1])
+		}
+		key, value := "", ""
+		if len(rest) > 1 {
+			key = rest[0]
+		}
+		if len(rest) > 2 {
+			value = rest[1]
+		}
+		idx.remove(key, value, id)
+		return response{status: 204}
+	}
+	return notAllowed()
+}
+
+type indexPayload struct {
+	URI        string                 `json:"uri"`
+	Key        string                 `json:"key"`
+	Value      interface{}            `json:"value"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+// addToIndex associates an entity with a key/value pair in an index.
+func (s *Server) addToIndex(r request, kind, name string, idx *legacyIndex) response {
+	payload := indexPayload{}
+	err := r.decode(&payload)
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	id, ok := idFromHref(payload.URI)
+	if !ok || payload.Key == "" {
+		return badRequest("Key and URI must be supplied")
+	}
+	repr, ok := s.repr(kind, id)
+	if !ok {
+		return notFound("No such entity " + payload.URI)
+	}
+	value := fmt.Sprint(payload.Value)
+	idx.entries = append(idx.entries, indexEntry{key: payload.Key, value: value, id: id})
+	return response{status: 201, location: s.href("index", kind, name, payload.Key, value, id), body: repr}
+}
+
+// getOrCreate implements ?uniqueness=get_or_create on node indexes, creating
+// the index if necessary.
+func (s *Server) getOrCreate(r request, kind, name string) response {
+	if kind != "node" {
+		return badRequest("Only node indexes support get_or_create in this fake")
+	}
+	payload := indexPayload{}
+	err := r.decode(&payload)
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	idx, ok := s.indexes[kind][name]
+	if !ok {
+		idx = &legacyIndex{}
+		s.indexes[kind][name] = idx
+	}
+	value := fmt.Sprint(payload.Value)
+	if ids := idx.find(payload.Key, value); len(ids) > 0 {
+		return response{status: 200, body: s.nodeRepr(s.nodes[ids[0]])}
+	}
+	n, ok := s.createNode(payload.Properties)
+	if !ok {
+		return badRequest("Could not set property, null is not a valid value")
+	}
+	idx.entries = append(idx.entries, indexEntry{key: payload.Key, value: value, id: n.id})
+	return response{status: 201, location: s.href("node", n.id), body: s.nodeRepr(n)}
+}
+
+// A schemaEntry is a schema index or unique constraint.
+type schemaEntry struct {
+	label string
+	keys  []string
+}
+
+func (e schemaEntry) matches(label, key string) bool {
+	return e.label == label && (key == "" || (len(e.keys) == 1 && e.keys[0] == key))
+}
+
+func (e schemaEntry) indexRepr() map[string]interface{} {
+	return map[string]interface{}{"label": e.label, "property_keys": e.keys}
+}
+
+func (e schemaEntry) constraintRepr() map[string]interface{} {
+	return map[string]interface{}{"label": e.label, "type": "UNIQUENESS", "property_keys": e.keys}
+}
+
+// checkConstraints returns an error response if adding label to n would
+// violate a unique constraint.
+func (s *Server) checkConstraints(n *node, label string) *response {
+	for _, c := range s.constraints {
+		if c.label != label {
+			continue
+		}
+		v, ok := n.props[c.keys[0]]
+		if !ok {
+			continue
+		}
+		for _, other := range s.nodes {
+			if other.id != n.id && hasString(other.labels, label) && fmt.Sprint(other.props[c.keys[0]]) == fmt.Sprint(v) {
+				resp := neoError(409, "Neo.ClientError.Schema.ConstraintValidationFailed",
+					fmt.Sprintf("Node(%d) already exists with label `%s` and property `%s`", other.id, label, c.keys[0]))
+				return &resp
+			}
+		}
+	}
+	return nil
+}
+
+// serveSchema handles requests under /schema.
+func (s *Server) serveSchema(r request) response {
+	seg := r.seg
+	if len(seg) < 2 {
+		return notFound("No such resource")
+	}
+	switch seg[1] {
+	case "index":
+		return s.serveSchemaIndex(r, seg[2:])
+	case "constraint":
+		return s.serveConstraint(r, seg[2:])
+	}
+	return notFound("No such resource")
+}
+
+func (s *Server) serveSchemaIndex(r request, rest []string) response {
+	switch {
+	case r.method == "GET" && len(rest) <= 1:
+		result := []interface{}{}
+		for _, e := range s.schemaIndexes {
+			if len(rest) == 0 || e.label == rest[0] {
+				result = append(result, e.indexRepr())
+			}
+		}
+		return response{status: 200, body: result}
+	case r.method == "POST" && len(rest) == 1:
+		payload := struct {
+			PropertyKeys []string `json:"property_keys"`
+		}{}
+		err := r.decode(&payload)
+		if err != nil || len(payload.PropertyKeys) != 1 || payload.PropertyKeys[0] == "" {
+			return badRequest("A single property key must be supplied")
+		}
+		e := schemaEntry{label: rest[0], keys: payload.PropertyKeys}
+		for _, x := range s.schemaIndexes {
+			if x.matches(e.label, e.keys[0]) {
+				return neoError(409, "Neo.ClientError.Schema.EquivalentSchemaRuleAlreadyExists", "Index already exists")
+			}
+		}
+		s.schemaIndexes = append(s.schemaIndexes, e)
+		s.labels[e.label] = true
+		s.propertyKeys[e.keys[0]] = true
+		return response{status: 200, body: e.indexRepr()}
+	case r.method == "DELETE" && len(rest) == 2:
+		for i, e := range s.schemaIndexes {
+			if e.matches(rest[0], rest[1]) {
+				s.schemaIndexes = append(s.schemaIndexes[:i], s.schemaIndexes[i+1:]...)
+				return response{status: 204}
+			}
+		}
+		return notFound("No such index")
+	}
+	return notAllowed()
+}
+
+func (s *Server) serveConstraint(r request, rest []string) response {
+	if len(rest) >= 2 && rest[1] != "uniqueness" {
+		return notFound("No such constraint type " + rest[1])
+	}
+	switch {
+	case r.method == "GET" && len(rest) <= 3:
+		label, key := "", ""
+		if len(rest) > 0 {
+			label = rest[0]
+		}
+		if len(rest) > 2 {
+			key = rest[2]
+		}
+		result := []interface{}{}
+		for _, e := range s.constraints {
+			if label == "" || e.matches(label, key) {
+				result = append(result, e.constraintRepr())
+			}
+		}
+		return response{status: 200, body: result}
+	case r.method == "POST" && len(rest) == 2:
+		payload := struct {
+			PropertyKeys []string `json:"property_keys"`
+		}{}
+		err := r.decode(&payload)
+		if err != nil || len(payload.PropertyKeys) != 1 || payload.PropertyKeys[0] == "" {
+			return badRequest("A single property key must be supplied")
+		}
+		e := schemaEntry{label: rest[0], keys: payload.PropertyKeys}
+		for _, x := range s.constraints {
+			if x.matches(e.label, e.keys[0]) {
+				return neoError(409, "Neo.ClientError.Schema.EquivalentSchemaRuleAlreadyExists", "Constraint already exists")
+			}
+		}
+		seen := map[string]bool{}
+		for _, n := range s.nodes {
+			if v, ok := n.props[e.keys[0]]; ok && hasString(n.labels, e.label) {
+				if seen[fmt.Sprint(v)] {
+					return neoError(409, "Neo.ClientError.Schema.ConstraintValidationFailed", "Existing data violates the constraint")
+				}
+				seen[fmt.Sprint(v)] = true
+			}
+		}
+		s.constraints = append(s.constraints, e)
+		s.labels[e.label] = true
+		s.propertyKeys[e.keys[0]] = true
+		return response{status: 200, body: e.constraintRepr()}
+	case r.method == "DELETE" && len(rest) == 3:
+		for i, e := range s.constraints {
+			if e.matches(rest[0], rest[2]) {
+				s.constraints = append(s.constraints[:i], s.constraints[i+1:]...)
+				return response{status: 204}
+			}
+		}
+		return notFound("No such constraint")
+	}
+	return notAllowed()
+}
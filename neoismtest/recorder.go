@@ -0,0 +1,277 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released under
+// the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for details.
+// Resist intellectual serfdom - the ownership of ideas is akin to slavery.
+
+package neoismtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"testing"
+)
+
+// A Mode determines whether a Recorder records or replays interactions.
+type Mode int
+
+// Recorder modes.
+const (
+	// Replay serves recorded responses, without contacting a server.
+	Replay Mode = iota
+	// Record passes requests to a real server and records them.
+	Record
+)
+
+// An Interaction is a recorded request and its response.
+type Interaction struct {
+	Request struct {
+		Method string `json:"method"`
+		URL    string `json:"url"` // Normalized, see Normalize
+		Body   string `json:"body,omitempty"`
+	} `json:"request"`
+	Response struct {
+		Status   int    `json:"status"`
+		Location string `json:"location,omitempty"`
+		Body     string `json:"body,omitempty"`
+	} `json:"response"`
+	used bool
+}
+
+// A Recorder is an http.RoundTripper which records the requests it sends and
+// the responses received to a golden file, or replays responses from a golden
+// file without contacting a server.  Use it with neoism.ConnectWithClient:
+//
+//	rec := neoismtest.NewTestRecorder(t, "testdata/cypher.json")
+//	db, err := neoism.ConnectWithClient(neo4jUrl, rec.Client())
+type Recorder struct {
+	mode         Mode
+	filename     string
+	transport    http.RoundTripper
+	mu           sync.Mutex
+	interactions []*Interaction
+	onMiss       func(err error) // Called for requests without a recording
+}
+
+// NewRecorder returns a Recorder for the golden file filename.  In Replay mode
+// the file is loaded immediately.  In Record mode, requests are sent using
+// transport, or http.DefaultTransport if transport is nil, and the file is
+// written by Save.
+func NewRecorder(filename string, mode Mode, transport http.RoundTripper) (*Recorder, error) {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	r := Recorder{
+		mode:      mode,
+		filename:  filename,
+		transport: transport,
+	}
+	if mode == Replay {
+		b, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		err = json.Unmarshal(b, &r.interactions)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &r, nil
+}
+
+// RecordEnv is the environment variable which, if set to a non-empty value,
+// makes NewTestRecorder record rather than replay.
+const RecordEnv = "NEOISM_RECORD"
+
+// NewTestRecorder returns a Recorder for use in a test.  It records if the
+// NEOISM_RECORD environment variable is set, and replays otherwise.  A replay
+// fails the test if a request has no recorded response, or, when the test
+// finishes, if any recorded interaction went unused.  A recording is saved
+// when the test finishes.
+func NewTestRecorder(t testing.TB, filename string) *Recorder {
+	t.Helper()
+	mode := Replay
+	if os.Getenv(RecordEnv) != "" {
+		mode = Record
+	}
+	r, err := NewRecorder(filename, mode, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.onMiss = func(err error) {
+		t.Error(err)
+	}
+	t.Cleanup(func() {
+		if mode == Record {
+			err := r.Save()
+			if err != nil {
+				t.Error(err)
+			}
+			return
+		}
+		for _, i := range r.Unused() {
+			t.Errorf("neoismtest: recorded request was not replayed: %s %s", i.Request.Method, i.Request.URL)
+		}
+	})
+	return r
+}
+
+// Client returns an http.Client which sends requests through the Recorder.
+func (r *Recorder) Client() *http.Client {
+	return &http.Client{Transport: r}
+}
+
+// Save writes the recorded interactions to the golden file, creating its
+// directory if necessary.
+func (r *Recorder) Save() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	b, err := json.MarshalIndent(r.interactions, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(r.filename), 0755)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(r.filename, b, 0644)
+}
+
+// Unused returns the recorded interactions which have not been replayed.
+func (r *Recorder) Unused() []*Interaction {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	unused := []*Interaction{}
+	for _, i := range r.interactions {
+		if !i.used {
+			unused = append(unused, i)
+		}
+	}
+	return unused
+}
+
+// RoundTrip implements http.RoundTripper.
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	body := []byte{}
+	if req.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	server := req.URL.Scheme + "://" + req.URL.Host
+	if r.mode == Record {
+		return r.record(req, server, body)
+	}
+	return r.replay(req, server, body)
+}
+
+// record sends the request and records the interaction.
+func (r *Recorder) record(req *http.Request, server string, body []byte) (*http.Response, error) {
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	resp, err := r.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(respBody))
+	i := Interaction{}
+	i.Request.Method = req.Method
+	i.Request.URL = Normalize(req.URL.String())
+	i.Request.Body = Normalize(string(body))
+	i.Response.Status = resp.StatusCode
+	i.Response.Location = replaceServer(resp.Header.Get("Location"), server)
+	i.Response.Body = replaceServer(string(respBody), server)
+	r.mu.Lock()
+	r.interactions = append(r.interactions, &i)
+	r.mu.Unlock()
+	return resp, nil
+}
+
+// replay serves the first unused recorded interaction matching the request.
+func (r *Recorder) replay(req *http.Request, server string, body []byte) (*http.Response, error) {
+	u := Normalize(req.URL.String())
+	b := Normalize(string(body))
+	resp := r.find(req, u, b, server)
+	if resp != nil {
+		return resp, nil
+	}
+	err := fmt.Errorf("neoismtest: no recorded response for %s %s %s", req.Method, u, b)
+	if r.onMiss != nil {
+		r.onMiss(err)
+	}
+	return nil, err
+}
+
+// find returns the response of the first unused recorded interaction
+// matching the request, with normalized URL u and body b, or nil.
+func (r *Recorder) find(req *http.Request, u, b, server string) *http.Response {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, i := range r.interactions {
+		if i.used || i.Request.Method != req.Method || i.Request.URL != u || i.Request.Body != b {
+			continue
+		}
+		i.used = true
+		resp := http.Response{
+			Status:        fmt.Sprintf("%d %s", i.Response.Status, http.StatusText(i.Response.Status)),
+			StatusCode:    i.Response.Status,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        http.Header{},
+			Request:       req,
+			ContentLength: int64(len(i.Response.Body)),
+		}
+		respBody := restoreServer(i.Response.Body, server)
+		resp.Body = ioutil.NopCloser(bytes.NewReader([]byte(respBody)))
+		resp.ContentLength = int64(len(respBody))
+		if i.Response.Body != "" {
+			resp.Header.Set("Content-Type", "application/json")
+		}
+		if i.Response.Location != "" {
+			resp.Header.Set("Location", restoreServer(i.Response.Location, server))
+		}
+		return &resp
+	}
+	return nil
+}
+
+const serverPlaceholder = "{server}"
+
+var (
+	serverRegex = regexp.MustCompile(`https?://[^/"\s]+`)
+	idRegex     = regexp.MustCompile(`/(node|relationship|transaction|tx)/\d+`)
+)
+
+// Normalize makes a URL, or a body containing URLs, independent of the
+// server it was sent to and of the IDs the server generated: scheme and host
+// are replaced by "{server}", and IDs of nodes, relationships and
+// transactions, including the /db/{name}/tx transactions of Neo4j 4.x, by
+// "{id}", e.g. "http://localhost:7474/db/data/node/12"
+// becomes "{server}/db/data/node/{id}".
+func Normalize(s string) string {
+	s = serverRegex.ReplaceAllString(s, serverPlaceholder)
+	return idRegex.ReplaceAllString(s, "/$1/{id}")
+}
+
+// replaceServer replaces occurrences of server in s with a placeholder.
+func replaceServer(s, server string) string {
+	return string(bytes.Replace([]byte(s), []byte(server), []byte(serverPlaceholder), -1))
+}
+
+// restoreServer replaces the placeholder in s with server.
+func restoreServer(s, server string) string {
+	return string(bytes.Replace([]byte(s), []byte(serverPlaceholder), []byte(server), -1))
+}
@@ -0,0 +1,149 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released under
+// the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for details.
+// Resist intellectual serfdom - the ownership of ideas is akin to slavery.
+
+package neoismtest_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jmcvetta/neoism"
+	"github.com/jmcvetta/neoism/neoismtest"
+	"github.com/stretchr/testify/assert"
+)
+
+// exercise creates and reads back a node and runs a transaction.
+func exercise(t *testing.T, db *neoism.Database) {
+	n, err := db.CreateNode(neoism.Props{"name": "Alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	props, err := n.Properties()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, neoism.Props{"name": "Alice"}, props)
+	tx, err := db.Begin([]*neoism.CypherQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, tx.Rollback())
+}
+
+func TestRecorder(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "testdata", "recorder.json")
+	//
+	// Record against the fake server
+	//
+	fake := neoismtest.NewServer()
+	rec, err := neoismtest.NewRecorder(filename, neoismtest.Record, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := neoism.ConnectWithClient(fake.URL, rec.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	exercise(t, db)
+	fake.Close()
+	if err := rec.Save(); err != nil {
+		t.Fatal(err)
+	}
+	//
+	// Replay, at a different address, without a server
+	//
+	rep, err := neoismtest.NewRecorder(filename, neoismtest.Replay, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := "http://replay.invalid:7474"
+	db, err = neoism.ConnectWithClient(url, rep.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, url+"/db/data/", db.Url)
+	exercise(t, db)
+	assert.Equal(t, 0, len(rep.Unused()))
+	//
+	// Requests which were not recorded fail
+	//
+	_, err = db.CreateNode(neoism.Props{"name": "Bob"})
+	assert.NotNil(t, err)
+}
+
+// replayFixture was recorded from exercise against the fake server; set
+// NEOISM_RECORD to record it again.
+var replayFixture = filepath.Join("testdata", "replay.json")
+
+func TestReplayFixture(t *testing.T) {
+	url := "http://replay.invalid:7474"
+	if os.Getenv(neoismtest.RecordEnv) != "" {
+		fake := neoismtest.NewServer()
+		defer fake.Close()
+		url = fake.URL
+	}
+	rec := neoismtest.NewTestRecorder(t, replayFixture)
+	db, err := neoism.ConnectWithClient(url, rec.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	exercise(t, db)
+}
+
+// A recordingTB collects the errors reported to it, and the cleanups
+// registered with it.
+type recordingTB struct {
+	testing.TB
+	errors   []string
+	cleanups []func()
+}
+
+func (tb *recordingTB) Helper() {}
+
+func (tb *recordingTB) Error(args ...interface{}) {
+	tb.errors = append(tb.errors, fmt.Sprint(args...))
+}
+
+func (tb *recordingTB) Errorf(format string, args ...interface{}) {
+	tb.errors = append(tb.errors, fmt.Sprintf(format, args...))
+}
+
+func (tb *recordingTB) Cleanup(f func()) {
+	tb.cleanups = append(tb.cleanups, f)
+}
+
+func TestReplayMissing(t *testing.T) {
+	if os.Getenv(neoismtest.RecordEnv) != "" {
+		t.Skip("replays only")
+	}
+	tb := &recordingTB{TB: t}
+	rec := neoismtest.NewTestRecorder(tb, replayFixture)
+	db, err := neoism.ConnectWithClient("http://replay.invalid:7474", rec.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 0, len(tb.errors))
+	_, err = db.CreateNode(neoism.Props{"name": "Bob"})
+	assert.NotNil(t, err)
+	if assert.Equal(t, 1, len(tb.errors)) {
+		assert.True(t, strings.Contains(tb.errors[0], "no recorded response"), tb.errors[0])
+	}
+	// The rest of the recording went unused.
+	for _, f := range tb.cleanups {
+		f()
+	}
+	assert.True(t, len(tb.errors) > 1)
+	for _, e := range tb.errors[1:] {
+		assert.True(t, strings.Contains(e, "was not replayed"), e)
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	assert.Equal(t, "{server}/db/data/node/{id}/properties", neoismtest.Normalize("http://localhost:7474/db/data/node/12/properties"))
+	assert.Equal(t, `{"to":"{server}/db/data/node/{id}"}`, neoismtest.Normalize(`{"to":"https://neo4j.example.com/db/data/node/3"}`))
+	assert.Equal(t, "{server}/db/data/transaction/{id}/commit", neoismtest.Normalize("http://127.0.0.1:7474/db/data/transaction/7/commit"))
+}
@@ -0,0 +1,354 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released under
+// the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for details.
+// Resist intellectual serfdom - the ownership of ideas is akin to slavery.
+
+/*
+Package neoismtest provides an in-process fake Neo4j server, so code using
+neoism can be tested without a live database.
+
+The fake implements the service root, the node, relationship, property and
+label endpoints, legacy indexes, the schema endpoints, /batch, /cypher, the
+transactional endpoint, the causal clustering status endpoints, the user
+endpoints and server extensions added with AddExtension.  Nodes,
+relationships and indexes are kept in memory.  Cypher is not interpreted;
+instead responses are scripted by matching the statement against regular
+expressions.
+
+Example Usage:
+
+	fake := neoismtest.NewServer()
+	defer fake.Close()
+	fake.HandleCypher(`^MATCH \(n:Person\)`, neoismtest.CypherResponse{
+		Columns: []string{"n.name"},
+		Rows:    [][]interface{}{{"Kirk"}, {"McCoy"}},
+	})
+	db, _ := neoism.Connect(fake.URL)
+
+This package does not import neoism, so it may be used by neoism's own tests.
+*/
+package neoismtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Version is the Neo4j version reported by the fake's service root, unless
+// another is given to NewServerVersion.
+const Version = "3.5.0"
+
+// A Server is a fake Neo4j server listening on a local loopback address.
+type Server struct {
+	*httptest.Server
+	mu            sync.Mutex
+	nodes         map[int]*node
+	rels          map[int]*rel
+	nextNode      int
+	nextRel       int
+	labels        map[string]bool
+	propertyKeys  map[string]bool
+	relTypes      map[string]bool
+	indexes       map[string]map[string]*legacyIndex // Keyed on "node" or "relationship", then name
+	schemaIndexes []schemaEntry
+	constraints   []schemaEntry
+	responders    []*responder
+	statements    []string
+	txs           map[int]bool
+	nextTx        int
+	version       string
+	role          string // Cluster role; see SetClusterRole
+	user          *user  // If set, authentication is required
+	bearer        string
+	extensions    []*ExtensionMethod
+}
+
+// NewServer starts and returns a new fake server with an empty graph.  The
+// caller should call Close when finished, to shut it down.
+func NewServer() *Server {
+	return NewServerVersion(Version)
+}
+
+// NewServerVersion is like NewServer, but the fake reports the given Neo4j
+// version.  From version 4 on, the fake serves the discovery document and
+// per-database transactional endpoints, /db/{databaseName}/tx, of Neo4j 4.x
+// and 5.x, and none of the REST API.
+func NewServerVersion(version string) *Server {
+	s := &Server{
+		version:      version,
+		nodes:        map[int]*node{},
+		rels:         map[int]*rel{},
+		labels:       map[string]bool{},
+		propertyKeys: map[string]bool{},
+		relTypes:     map[string]bool{},
+		indexes: map[string]map[string]*legacyIndex{
+			"node":         {},
+			"relationship": {},
+		},
+		txs: map[int]bool{},
+	}
+	s.Server = httptest.NewServer(s)
+	return s
+}
+
+// DataURL returns the root URL of the fake's REST API.
+func (s *Server) DataURL() string {
+	return s.URL + dataPath
+}
+
+const dataPath = "/db/data/"
+
+// href returns the absolute URL for a path relative to the REST API root.
+func (s *Server) href(parts ...interface{}) string {
+	strs := make([]string, len(parts))
+	for i, p := range parts {
+		strs[i] = fmt.Sprint(p)
+	}
+	return s.DataURL() + strings.Join(strs, "/")
+}
+
+// A response is the result of dispatching a request.
+type response struct {
+	status   int
+	location string
+	body     interface{} // Marshalled as JSON, unless nil
+}
+
+// neoError builds an error response in the format used by the REST API.
+func neoError(status int, code, msg string) response {
+	body := map[string]interface{}{
+		"message":    msg,
+		"exception":  code,
+		"stacktrace": []string{},
+		"errors": []map[string]string{
+			{"code": code, "message": msg},
+		},
+	}
+	return response{status: status, body: body}
+}
+
+func notFound(msg string) response {
+	return neoError(404, "Neo.ClientError.Statement.EntityNotFound", msg)
+}
+
+func badRequest(msg string) response {
+	return neoError(400, "Neo.ClientError.Request.Invalid", msg)
+}
+
+func notAllowed() response {
+	return neoError(405, "Neo.ClientError.Request.Invalid", "Method not allowed")
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	s.mu.Lock()
+	var resp response
+	if refused := s.authenticate(r); refused != nil {
+		resp = *refused
+	} else {
+		resp = s.dispatch(r.Method, r.URL, body)
+	}
+	s.mu.Unlock()
+	if resp.location != "" {
+		w.Header().Set("Location", resp.location)
+	}
+	if resp.body == nil {
+		w.WriteHeader(resp.status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(resp.status)
+	json.NewEncoder(w).Encode(resp.body)
+}
+
+// major returns the major version the fake reports.
+func (s *Server) major() int {
+	major, _ := strconv.Atoi(strings.SplitN(s.version, ".", 2)[0])
+	return major
+}
+
+// dispatch routes a request to its handler.  The caller must hold s.mu.
+func (s *Server) dispatch(method string, u *url.URL, body []byte) response {
+	if s.major() >= 4 {
+		return s.dispatch4(method, u, body)
+	}
+	p := u.EscapedPath()
+	if !strings.HasPrefix(p, dataPath) {
+		if p == "/" || p == "" {
+			// Discovery document, which neoism follows to /db/data/.
+			return response{status: 200, body: map[string]string{
+				"data":       s.DataURL(),
+				"management": s.URL + "/db/manage/",
+			}}
+		}
+		if strings.HasPrefix(p, "/user/") {
+			return s.serveUser(method, strings.TrimPrefix(p, "/user/"), body)
+		}
+		if strings.HasPrefix(p, managePath) {
+			return s.serveClusterStatus(strings.TrimPrefix(p, managePath))
+		}
+		return notFound("No such resource: " + p)
+	}
+	p = strings.Trim(strings.TrimPrefix(p, dataPath), "/")
+	seg := []string{}
+	if p != "" {
+		for _, raw := range strings.Split(p, "/") {
+			part, err := url.PathUnescape(raw)
+			if err != nil {
+				return badRequest(err.Error())
+			}
+			seg = append(seg, part)
+		}
+	}
+	req := request{method: method, seg: seg, query: u.Query(), body: body, txBase: s.href("transaction")}
+	if len(seg) == 0 {
+		if method != "GET" {
+			return notAllowed()
+		}
+		return response{status: 200, body: s.serviceRoot()}
+	}
+	switch seg[0] {
+	case "node":
+		return s.serveNode(req)
+	case "relationship":
+		return s.serveRelationship(req)
+	case "labels", "label", "propertykeys":
+		return s.serveLabels(req)
+	case "index":
+		return s.serveIndex(req)
+	case "schema":
+		return s.serveSchema(req)
+	case "batch":
+		return s.serveBatch(req)
+	case "cypher":
+		return s.serveCypher(req)
+	case "transaction":
+		return s.serveTransaction(req)
+	case "ext":
+		return s.serveExtension(req)
+	}
+	return notFound("No such resource: " + p)
+}
+
+// dispatch4 routes a request to a Neo4j 4.x or 5.x fake.  The caller must
+// hold s.mu.
+func (s *Server) dispatch4(method string, u *url.URL, body []byte) response {
+	p := strings.Trim(u.EscapedPath(), "/")
+	if p == "" {
+		return response{status: 200, body: map[string]string{
+			"transaction":   s.URL + "/db/{databaseName}/tx",
+			"bolt_direct":   "bolt://" + s.Listener.Addr().String(),
+			"bolt_routing":  "neo4j://" + s.Listener.Addr().String(),
+			"neo4j_version": s.version,
+			"neo4j_edition": "community",
+		}}
+	}
+	parts := strings.Split(p, "/")
+	if len(parts) == 4 && parts[0] == "db" && parts[2] == "cluster" {
+		return s.serveClusterStatus("core/" + parts[3])
+	}
+	if len(parts) < 3 || parts[0] != "db" || parts[2] != "tx" {
+		return notFound("No such resource: " + u.Path)
+	}
+	seg := append([]string{"transaction"}, parts[3:]...)
+	req := request{
+		method: method,
+		seg:    seg,
+		query:  u.Query(),
+		body:   body,
+		txBase: s.URL + "/" + strings.Join(parts[:3], "/"),
+	}
+	return s.serveTransaction(req)
+}
+
+// A request is a request being dispatched, with its path relative to the
+// REST API root split into unescaped segments.
+type request struct {
+	method string
+	seg    []string
+	query  url.Values
+	body   []byte
+	txBase string // URL of the transactional endpoint
+}
+
+// decode unmarshals the request body into v.
+func (r request) decode(v interface{}) error {
+	if len(r.body) == 0 {
+		return nil
+	}
+	return json.Unmarshal(r.body, v)
+}
+
+// serviceRoot describes the REST API, as returned by GET /db/data/.
+func (s *Server) serviceRoot() map[string]interface{} {
+	return map[string]interface{}{
+		"extensions":         s.extensionHrefs("graphdb", 0),
+		"node":               s.href("node"),
+		"node_index":         s.href("index", "node"),
+		"relationship_index": s.href("index", "relationship"),
+		"extensions_info":    s.href("ext"),
+		"relationship_types": s.href("relationship", "types"),
+		"batch":              s.href("batch"),
+		"cypher":             s.href("cypher"),
+		"indexes":            s.href("schema", "index"),
+		"constraints":        s.href("schema", "constraint"),
+		"transaction":        s.href("transaction"),
+		"node_labels":        s.href("labels"),
+		"neo4j_version":      s.version,
+	}
+}
+
+// A batchJob is a single job submitted to /batch.
+type batchJob struct {
+	Method string          `json:"method"`
+	To     string          `json:"to"`
+	ID     int             `json:"id"`
+	Body   json.RawMessage `json:"body"`
+}
+
+// serveBatch executes a list of jobs in order, stopping at the first failure.
+func (s *Server) serveBatch(r request) response {
+	if r.method != "POST" || len(r.seg) != 1 {
+		return notAllowed()
+	}
+	jobs := []batchJob{}
+	err := r.decode(&jobs)
+	if err != nil {
+		return badRequest(err.Error())
+	}
+	results := []map[string]interface{}{}
+	for _, job := range jobs {
+		to := strings.TrimPrefix(job.To, s.DataURL())
+		u, err := url.Parse(dataPath + strings.TrimPrefix(to, "/"))
+		if err != nil {
+			return badRequest(err.Error())
+		}
+		body := []byte(job.Body)
+		if string(body) == "null" {
+			body = nil
+		}
+		resp := s.dispatch(strings.ToUpper(job.Method), u, body)
+		if resp.status >= 400 {
+			return resp
+		}
+		results = append(results, map[string]interface{}{
+			"id":       job.ID,
+			"from":     job.To,
+			"location": resp.location,
+			"status":   resp.status,
+			"body":     resp.body,
+		})
+	}
+	return response{status: 200, body: results}
+}
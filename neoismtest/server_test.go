@@ -0,0 +1,155 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released under
+// the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for details.
+// Resist intellectual serfdom - the ownership of ideas is akin to slavery.
+
+package neoismtest_test
+
+import (
+	"testing"
+
+	"github.com/jmcvetta/neoism"
+	"github.com/jmcvetta/neoism/neoismtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func connectFake(t *testing.T) (*neoismtest.Server, *neoism.Database) {
+	fake := neoismtest.NewServer()
+	db, err := neoism.Connect(fake.URL)
+	if err != nil {
+		fake.Close()
+		t.Fatal(err)
+	}
+	return fake, db
+}
+
+func TestConnect(t *testing.T) {
+	fake, db := connectFake(t)
+	defer fake.Close()
+	assert.Equal(t, fake.DataURL(), db.Url)
+	assert.Equal(t, neoismtest.Version, db.Version)
+}
+
+func TestNodesAndRelationships(t *testing.T) {
+	fake, db := connectFake(t)
+	defer fake.Close()
+	kirk, err := db.CreateNode(neoism.Props{"name": "Kirk"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	spock, _ := db.CreateNode(neoism.Props{"name": "Spock"})
+	assert.Equal(t, nil, kirk.AddLabel("Person", "Captain"))
+	labels, _ := kirk.Labels()
+	assert.Equal(t, []string{"Person", "Captain"}, labels)
+	r, err := kirk.Relate("commands", spock.Id(), neoism.Props{"since": 2265})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rels, _ := spock.Incoming("commands")
+	assert.Equal(t, 1, len(rels))
+	assert.Equal(t, r.Id(), rels[0].Id())
+	start, _ := r.Start()
+	assert.Equal(t, kirk.Id(), start.Id())
+	assert.Equal(t, neoism.CannotDelete, kirk.Delete())
+	name, _ := spock.Property("name")
+	assert.Equal(t, "Spock", name)
+	assert.Equal(t, nil, r.Delete())
+	assert.Equal(t, nil, kirk.Delete())
+	_, err = db.Node(kirk.Id())
+	assert.Equal(t, neoism.NotFound, err)
+	people, _ := db.NodesByLabel("Person")
+	assert.Equal(t, 0, len(people))
+}
+
+func TestLegacyIndex(t *testing.T) {
+	fake, db := connectFake(t)
+	defer fake.Close()
+	idx, err := db.CreateLegacyNodeIndex("people", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n0, _ := db.CreateNode(neoism.Props{})
+	idx.Add(n0, "name", "Kirk")
+	found, err := idx.Find("name", "Kirk")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(found))
+	n1, created, err := db.GetOrCreateNode("people", "name", neoism.Props{"name": "Kirk"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, false, created)
+	assert.Equal(t, n0.Id(), n1.Id())
+}
+
+func TestSchema(t *testing.T) {
+	fake, db := connectFake(t)
+	defer fake.Close()
+	_, err := db.CreateIndex("Person", "name")
+	assert.Equal(t, nil, err)
+	_, err = db.CreateUniqueConstraint("Person", "email")
+	assert.Equal(t, nil, err)
+	_, err = db.CreateUniqueConstraint("Person", "email")
+	assert.Equal(t, neoism.NotAllowed, err)
+	current, err := db.CurrentSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, len(current.Indexes))
+	assert.Equal(t, 1, len(current.UniqueConstraints))
+}
+
+func TestScriptedCypher(t *testing.T) {
+	fake, db := connectFake(t)
+	defer fake.Close()
+	fake.HandleCypher(`^MATCH \(n:Person\)`, neoismtest.CypherResponse{
+		Columns: []string{"n.name"},
+		Rows:    [][]interface{}{{"Kirk"}, {"McCoy"}},
+		Stats:   map[string]interface{}{"nodes_created": 0},
+	})
+	fake.HandleCypherFunc(`^CREATE`, func(stmt string, params map[string]interface{}) neoismtest.CypherResponse {
+		return neoismtest.CypherResponse{
+			Columns: []string{"name"},
+			Rows:    [][]interface{}{{params["name"]}},
+		}
+	})
+	res := []struct {
+		Name string `json:"n.name"`
+	}{}
+	cq := neoism.CypherQuery{
+		Statement:    "MATCH (n:Person) RETURN n.name",
+		Result:       &res,
+		IncludeStats: true,
+	}
+	err := db.Cypher(&cq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 2, len(res))
+	assert.Equal(t, "McCoy", res[1].Name)
+	_, err = cq.Stats()
+	assert.Equal(t, nil, err)
+	// Transactions
+	created := []struct {
+		Name string `json:"name"`
+	}{}
+	q0 := neoism.CypherQuery{
+		Statement:  "CREATE (n:Person {name: {name}}) RETURN n.name AS name",
+		Parameters: neoism.Props{"name": "Scotty"},
+		Result:     &created,
+	}
+	tx, err := db.Begin([]*neoism.CypherQuery{&q0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "Scotty", created[0].Name)
+	assert.Equal(t, nil, tx.Commit())
+	assert.Equal(t, neoism.NotFound, tx.Query([]*neoism.CypherQuery{&q0}))
+	// Unscripted statements fail
+	err = db.Cypher(&neoism.CypherQuery{Statement: "foobar"})
+	_, ok := err.(neoism.NeoError)
+	assert.Equal(t, true, ok)
+	tx, err = db.Begin([]*neoism.CypherQuery{{Statement: "foobar"}})
+	assert.Equal(t, neoism.TxQueryError, err)
+	assert.Equal(t, 1, len(tx.Errors))
+	// Batches
+	err = db.CypherBatch([]*neoism.CypherQuery{&cq, &q0})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 6, len(fake.Statements()))
+}
@@ -12,6 +12,9 @@ import (
 
 // CreateNode creates a Node in the database.
 func (db *Database) CreateNode(p Props) (*Node, error) {
+	if err := db.requireREST("CreateNode"); err != nil {
+		return nil, err
+	}
 	n := Node{}
 	n.Db = db
 	ne := NeoError{}
@@ -36,6 +39,9 @@ func (db *Database) Node(id int) (*Node, error) {
 
 // GetOrCreateNode creates a node if it doesn’t already exist.
 func (db *Database) GetOrCreateNode(label, key string, p Props) (n *Node, created bool, err error) {
+	if err := db.requireREST("GetOrCreateNode"); err != nil {
+		return nil, false, err
+	}
 	/*
 		valInterface, ok := p[key]
 		if !ok {
@@ -79,6 +85,9 @@ func (db *Database) GetOrCreateNode(label, key string, p Props) (n *Node, create
 
 // getNodeByUri fetches a Node from the database based on its URI.
 func (db *Database) getNodeByUri(uri string) (*Node, error) {
+	if err := db.requireREST("Fetching nodes"); err != nil {
+		return nil, err
+	}
 	n := Node{}
 	n.Db = db
 	ne := NeoError{}
@@ -110,7 +119,7 @@ type Node struct {
 	HrefIncomingTypedRels string                 `json:"incoming_typed_relationships"`
 	HrefLabels            string                 `json:"labels"`
 	Data                  map[string]interface{} `json:"data"`
-	Extensions            map[string]interface{} `json:"extensions"`
+	Extensions            Extensions             `json:"extensions"`
 }
 
 // Id gets the ID number of this Node.
@@ -258,6 +267,9 @@ func (n *Node) SetLabels(labels []string) error {
 
 // NodesByLabel gets all nodes with a given label.
 func (db *Database) NodesByLabel(label string) ([]*Node, error) {
+	if err := db.requireREST("NodesByLabel"); err != nil {
+		return nil, err
+	}
 	uri := join(db.Url, "label", label, "nodes")
 	res := []*Node{}
 	ne := NeoError{}
@@ -279,6 +291,9 @@ func (db *Database) NodesByLabel(label string) ([]*Node, error) {
 
 // Labels lists all labels.
 func (db *Database) Labels() ([]string, error) {
+	if err := db.requireREST("Labels"); err != nil {
+		return nil, err
+	}
 	uri := join(db.Url, "labels")
 	labels := []string{}
 	ne := NeoError{}
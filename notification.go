@@ -0,0 +1,141 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released under
+// the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for details.
+// Resist intellectual serfdom - the ownership of ideas is akin to slavery.
+
+package neoism
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Severities of notifications returned by the server.
+const (
+	SeverityInformation = "INFORMATION"
+	SeverityWarning     = "WARNING"
+)
+
+// A Notification is a warning or hint returned by the server about a query,
+// such as use of deprecated syntax or a cartesian product.
+type Notification struct {
+	Code        string                `json:"code"`
+	Title       string                `json:"title"`
+	Description string                `json:"description"`
+	Severity    string                `json:"severity"`
+	Position    *NotificationPosition `json:"position"` // Nil if not specific to a position
+}
+
+// A NotificationPosition locates the part of a query a notification refers to.
+type NotificationPosition struct {
+	Offset int `json:"offset"`
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// String formats the notification for logging.
+func (n Notification) String() string {
+	s := fmt.Sprintf("%s %s: %s", n.Severity, n.Code, n.Title)
+	if n.Position != nil {
+		s += fmt.Sprintf(" (line %d, column %d)", n.Position.Line, n.Position.Column)
+	}
+	return s
+}
+
+// atLeast reports whether the notification is at least as severe as min.
+func (n Notification) atLeast(min string) bool {
+	return severityRank(n.Severity) >= severityRank(min)
+}
+
+func severityRank(severity string) int {
+	switch strings.ToUpper(severity) {
+	case SeverityInformation:
+		return 1
+	case SeverityWarning:
+		return 2
+	}
+	return 0
+}
+
+// A NotificationHandler is called with the notifications produced by a query.
+// If it returns an error, that error is returned by the method which executed
+// the query.  Notifications arrive with the results, once the query has run:
+// rejecting a query in a transaction rolls the transaction back, but a query
+// executed outside one by Cypher or CypherBatch may already be committed.  To
+// have rejections undo writes, execute them with Begin and Tx.Query.
+type NotificationHandler func(q *CypherQuery, ns []Notification) error
+
+// A NotificationError is returned by RejectNotifications when a query
+// produces notifications.
+type NotificationError struct {
+	Statement     string
+	Notifications []Notification
+}
+
+// Error lists the notifications.
+func (e *NotificationError) Error() string {
+	msgs := make([]string, len(e.Notifications))
+	for i, n := range e.Notifications {
+		msgs[i] = n.String()
+	}
+	return "Query produced notifications: " + strings.Join(msgs, "; ")
+}
+
+// LogNotifications returns a NotificationHandler which logs notifications at
+// least as severe as min.
+func LogNotifications(min string) NotificationHandler {
+	return func(q *CypherQuery, ns []Notification) error {
+		for _, n := range ns {
+			if n.atLeast(min) {
+				log.Printf("neoism: %s in query: %s", n, q.Statement)
+			}
+		}
+		return nil
+	}
+}
+
+// RejectNotifications returns a NotificationHandler which fails any query
+// producing notifications at least as severe as min with a
+// *NotificationError.  This is useful in test suites.
+func RejectNotifications(min string) NotificationHandler {
+	return func(q *CypherQuery, ns []Notification) error {
+		e := NotificationError{Statement: q.Statement}
+		for _, n := range ns {
+			if n.atLeast(min) {
+				e.Notifications = append(e.Notifications, n)
+			}
+		}
+		if len(e.Notifications) > 0 {
+			return &e
+		}
+		return nil
+	}
+}
+
+// Notifications returns the notifications returned by the server for this
+// query.  Notifications are only returned by the transactional endpoint, so
+// are not available for queries executed with CypherBatch, nor for queries
+// executed with Cypher unless an execution plan was requested.  The server
+// reports notifications for a whole request rather than for each statement,
+// so every query executed in the same call receives the same notifications.
+func (cq *CypherQuery) Notifications() []Notification {
+	return cq.notifications
+}
+
+// handleNotifications passes the notifications for each query to the
+// database's NotificationHandler, if one is set.
+func (db *Database) handleNotifications(qs []*CypherQuery) error {
+	if db.NotificationHandler == nil {
+		return nil
+	}
+	for _, q := range qs {
+		if len(q.notifications) == 0 {
+			continue
+		}
+		err := db.NotificationHandler(q, q.notifications)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released under
+// the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for details.
+// Resist intellectual serfdom - the ownership of ideas is akin to slavery.
+
+package neoism
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/jmcvetta/neoism/neoismtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNotificationsUnmarshal(t *testing.T) {
+	raw := `{
+		"results": [{"columns": ["a", "b"], "data": []}],
+		"notifications": [{
+			"code": "Neo.ClientNotification.Statement.CartesianProductWarning",
+			"severity": "WARNING",
+			"title": "This query builds a cartesian product between disconnected patterns.",
+			"description": "If a part of a query contains multiple disconnected patterns...",
+			"position": {"offset": 0, "line": 1, "column": 1}
+		}],
+		"errors": []
+	}`
+	tr := txResponse{}
+	err := json.Unmarshal([]byte(raw), &tr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cq := CypherQuery{Statement: "MATCH (a), (b) RETURN a, b"}
+	err = tr.unmarshal([]*CypherQuery{&cq})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns := cq.Notifications()
+	assert.Equal(t, 1, len(ns))
+	assert.Equal(t, SeverityWarning, ns[0].Severity)
+	assert.Equal(t, 1, ns[0].Position.Line)
+	db := Database{}
+	assert.Equal(t, nil, db.handleNotifications([]*CypherQuery{&cq}))
+	db.NotificationHandler = LogNotifications(SeverityWarning)
+	assert.Equal(t, nil, db.handleNotifications([]*CypherQuery{&cq}))
+	db.NotificationHandler = RejectNotifications(SeverityWarning)
+	err = db.handleNotifications([]*CypherQuery{&cq})
+	ne, ok := err.(*NotificationError)
+	if !ok {
+		t.Fatal("Expected a NotificationError")
+	}
+	assert.Equal(t, cq.Statement, ne.Statement)
+	ns[0].Severity = SeverityInformation
+	assert.Equal(t, nil, db.handleNotifications([]*CypherQuery{&cq}))
+}
+
+func TestTxNotifications(t *testing.T) {
+	db := connectTest(t)
+	defer cleanup(t, db)
+	db.NotificationHandler = RejectNotifications(SeverityWarning)
+	cq := CypherQuery{
+		Statement: "MATCH (a:Person), (b:Person) RETURN a, b",
+	}
+	tx, err := db.Begin([]*CypherQuery{&cq})
+	if tx != nil {
+		defer tx.Rollback()
+	}
+	_, ok := err.(*NotificationError)
+	assert.Equal(t, true, ok)
+	assert.NotEqual(t, 0, len(cq.Notifications()))
+}
+
+func TestBeginRejectedRollsBack(t *testing.T) {
+	fake := neoismtest.NewServer()
+	defer fake.Close()
+	fake.HandleCypher(`^MATCH`, neoismtest.CypherResponse{
+		Columns: []string{"a", "b"},
+		Notifications: []map[string]interface{}{{
+			"code":     "Neo.ClientNotification.Statement.CartesianProductWarning",
+			"severity": SeverityWarning,
+			"title":    "This query builds a cartesian product between disconnected patterns.",
+		}},
+	})
+	db, err := Connect(fake.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.NotificationHandler = RejectNotifications(SeverityWarning)
+	cq := CypherQuery{
+		Statement: "MATCH (a:Person), (b:Person) RETURN a, b",
+	}
+	tx, err := db.Begin([]*CypherQuery{&cq})
+	assert.Nil(t, tx)
+	_, ok := err.(*NotificationError)
+	assert.True(t, ok, "%v", err)
+	s := db.Stats()
+	assert.Equal(t, int64(0), s.OpenTransactions)
+	assert.Equal(t, int64(1), s.Rollbacks)
+	//
+	// So does a query rejected in an open transaction.
+	//
+	db.NotificationHandler = nil
+	tx, err = db.Begin([]*CypherQuery{&cq})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.NotificationHandler = RejectNotifications(SeverityWarning)
+	err = tx.Query([]*CypherQuery{&cq})
+	_, ok = err.(*NotificationError)
+	assert.True(t, ok, "%v", err)
+	assert.Equal(t, NotFound, tx.Commit())
+	s = db.Stats()
+	assert.Equal(t, int64(0), s.OpenTransactions)
+	assert.Equal(t, int64(2), s.Rollbacks)
+}
+
+func TestCypherRejectedIsCommitted(t *testing.T) {
+	fake := neoismtest.NewServerVersion("4.4.0")
+	defer fake.Close()
+	fake.HandleCypher(`^CREATE`, neoismtest.CypherResponse{
+		Columns: []string{"n"},
+		Notifications: []map[string]interface{}{{
+			"code":     "Neo.ClientNotification.Statement.FeatureDeprecationWarning",
+			"severity": SeverityWarning,
+		}},
+	})
+	db, err := Connect(fake.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.NotificationHandler = RejectNotifications(SeverityWarning)
+	err = db.Cypher(&CypherQuery{Statement: "CREATE (n) RETURN n"})
+	_, ok := err.(*NotificationError)
+	assert.True(t, ok, "%v", err)
+	// Outside a transaction, the rejected statement has been committed.
+	assert.Contains(t, fake.Statements(), "CREATE (n) RETURN n")
+	assert.Equal(t, int64(0), db.Stats().Rollbacks)
+}
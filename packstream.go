@@ -0,0 +1,466 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released under
+// the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for details.
+// Resist intellectual serfdom - the ownership of ideas is akin to slavery.
+
+package neoism
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// PackStream structure signatures of graph entities.
+const (
+	psNode                = 0x4E
+	psRelationship        = 0x52
+	psUnboundRelationship = 0x72
+	psPath                = 0x50
+)
+
+// A psStruct is a PackStream structure: a Bolt message, or a value such as a
+// node.
+type psStruct struct {
+	Signature byte
+	Fields    []interface{}
+}
+
+// packer encodes values in PackStream format.
+type packer struct {
+	bytes.Buffer
+}
+
+func (p *packer) header(tiny byte, n int, b8, b16, b32 byte) {
+	switch {
+	case n < 16 && tiny != 0:
+		p.WriteByte(tiny + byte(n))
+	case n <= math.MaxUint8 && b8 != 0:
+		p.WriteByte(b8)
+		p.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		p.WriteByte(b16)
+		binary.Write(p, binary.BigEndian, uint16(n))
+	default:
+		p.WriteByte(b32)
+		binary.Write(p, binary.BigEndian, uint32(n))
+	}
+}
+
+func (p *packer) packInt(i int64) {
+	switch {
+	case i >= -16 && i <= 127:
+		p.WriteByte(byte(int8(i)))
+	case i >= math.MinInt8 && i <= math.MaxInt8:
+		p.WriteByte(0xC8)
+		p.WriteByte(byte(int8(i)))
+	case i >= math.MinInt16 && i <= math.MaxInt16:
+		p.WriteByte(0xC9)
+		binary.Write(p, binary.BigEndian, int16(i))
+	case i >= math.MinInt32 && i <= math.MaxInt32:
+		p.WriteByte(0xCA)
+		binary.Write(p, binary.BigEndian, int32(i))
+	default:
+		p.WriteByte(0xCB)
+		binary.Write(p, binary.BigEndian, i)
+	}
+}
+
+// pack encodes v.  Values of types without a direct PackStream equivalent,
+// such as structs, are encoded as their JSON representation would be.
+func (p *packer) pack(v interface{}) error {
+	switch v := v.(type) {
+	case nil:
+		p.WriteByte(0xC0)
+	case bool:
+		if v {
+			p.WriteByte(0xC3)
+		} else {
+			p.WriteByte(0xC2)
+		}
+	case int:
+		p.packInt(int64(v))
+	case int8:
+		p.packInt(int64(v))
+	case int16:
+		p.packInt(int64(v))
+	case int32:
+		p.packInt(int64(v))
+	case int64:
+		p.packInt(v)
+	case uint8:
+		p.packInt(int64(v))
+	case uint16:
+		p.packInt(int64(v))
+	case uint32:
+		p.packInt(int64(v))
+	case float32:
+		return p.pack(float64(v))
+	case float64:
+		p.WriteByte(0xC1)
+		binary.Write(p, binary.BigEndian, v)
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			p.packInt(i)
+			return nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return err
+		}
+		return p.pack(f)
+	case string:
+		p.header(0x80, len(v), 0xD0, 0xD1, 0xD2)
+		p.WriteString(v)
+	case []byte:
+		p.header(0, len(v), 0xCC, 0xCD, 0xCE)
+		p.Write(v)
+	case []interface{}:
+		p.header(0x90, len(v), 0xD4, 0xD5, 0xD6)
+		for _, e := range v {
+			if err := p.pack(e); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		p.header(0xA0, len(v), 0xD8, 0xD9, 0xDA)
+		for k, e := range v {
+			p.pack(k)
+			if err := p.pack(e); err != nil {
+				return err
+			}
+		}
+	case Props:
+		return p.pack(map[string]interface{}(v))
+	case *psStruct:
+		if len(v.Fields) >= 16 {
+			return errors.New("PackStream structure has too many fields")
+		}
+		p.WriteByte(0xB0 + byte(len(v.Fields)))
+		p.WriteByte(v.Signature)
+		for _, f := range v.Fields {
+			if err := p.pack(f); err != nil {
+				return err
+			}
+		}
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		d := json.NewDecoder(bytes.NewReader(b))
+		d.UseNumber()
+		var j interface{}
+		err = d.Decode(&j)
+		if err != nil {
+			return err
+		}
+		return p.pack(j)
+	}
+	return nil
+}
+
+// unpacker decodes values in PackStream format.
+type unpacker struct {
+	*bytes.Reader
+}
+
+func (u *unpacker) size(n int) (int, error) {
+	var err error
+	switch n {
+	case 1:
+		var s uint8
+		err = binary.Read(u, binary.BigEndian, &s)
+		return int(s), err
+	case 2:
+		var s uint16
+		err = binary.Read(u, binary.BigEndian, &s)
+		return int(s), err
+	default:
+		var s uint32
+		err = binary.Read(u, binary.BigEndian, &s)
+		return int(s), err
+	}
+}
+
+func (u *unpacker) str(n int) (string, error) {
+	b := make([]byte, n)
+	_, err := io.ReadFull(u, b)
+	return string(b), err
+}
+
+func (u *unpacker) list(n int) ([]interface{}, error) {
+	l := make([]interface{}, n)
+	for i := range l {
+		v, err := u.unpack()
+		if err != nil {
+			return nil, err
+		}
+		l[i] = v
+	}
+	return l, nil
+}
+
+func (u *unpacker) dict(n int) (map[string]interface{}, error) {
+	m := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		k, err := u.unpack()
+		if err != nil {
+			return nil, err
+		}
+		ks, ok := k.(string)
+		if !ok {
+			return nil, fmt.Errorf("PackStream map key is %T, not string", k)
+		}
+		v, err := u.unpack()
+		if err != nil {
+			return nil, err
+		}
+		m[ks] = v
+	}
+	return m, nil
+}
+
+func (u *unpacker) structure(n int) (*psStruct, error) {
+	sig, err := u.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	fields, err := u.list(n)
+	if err != nil {
+		return nil, err
+	}
+	return &psStruct{Signature: sig, Fields: fields}, nil
+}
+
+// unpack decodes a value: nil, bool, int64, float64, string, []byte,
+// []interface{}, map[string]interface{} or *psStruct.
+func (u *unpacker) unpack() (interface{}, error) {
+	m, err := u.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case m <= 0x7F || m >= 0xF0:
+		return int64(int8(m)), nil
+	case m >= 0x80 && m <= 0x8F:
+		return u.str(int(m & 0x0F))
+	case m >= 0x90 && m <= 0x9F:
+		return u.list(int(m & 0x0F))
+	case m >= 0xA0 && m <= 0xAF:
+		return u.dict(int(m & 0x0F))
+	case m >= 0xB0 && m <= 0xBF:
+		return u.structure(int(m & 0x0F))
+	}
+	switch m {
+	case 0xC0:
+		return nil, nil
+	case 0xC1:
+		var f float64
+		err = binary.Read(u, binary.BigEndian, &f)
+		return f, err
+	case 0xC2:
+		return false, nil
+	case 0xC3:
+		return true, nil
+	case 0xC8:
+		var i int8
+		err = binary.Read(u, binary.BigEndian, &i)
+		return int64(i), err
+	case 0xC9:
+		var i int16
+		err = binary.Read(u, binary.BigEndian, &i)
+		return int64(i), err
+	case 0xCA:
+		var i int32
+		err = binary.Read(u, binary.BigEndian, &i)
+		return int64(i), err
+	case 0xCB:
+		var i int64
+		err = binary.Read(u, binary.BigEndian, &i)
+		return i, err
+	case 0xCC, 0xCD, 0xCE:
+		n, err := u.size(1 << (m - 0xCC))
+		if err != nil {
+			return nil, err
+		}
+		b := make([]byte, n)
+		_, err = io.ReadFull(u, b)
+		return b, err
+	case 0xD0, 0xD1, 0xD2:
+		n, err := u.size(1 << (m - 0xD0))
+		if err != nil {
+			return nil, err
+		}
+		return u.str(n)
+	case 0xD4, 0xD5, 0xD6:
+		n, err := u.size(1 << (m - 0xD4))
+		if err != nil {
+			return nil, err
+		}
+		return u.list(n)
+	case 0xD8, 0xD9, 0xDA:
+		n, err := u.size(1 << (m - 0xD8))
+		if err != nil {
+			return nil, err
+		}
+		return u.dict(n)
+	case 0xDC, 0xDD:
+		n, err := u.size(1 << (m - 0xDC))
+		if err != nil {
+			return nil, err
+		}
+		return u.structure(n)
+	}
+	return nil, fmt.Errorf("Unknown PackStream marker 0x%02X", m)
+}
+
+// jsonValue converts a decoded PackStream value to the value the
+// transactional endpoint would return in a result row: nodes and
+// relationships become their property maps, and paths lists of them.
+func jsonValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case float64:
+		return boltFloat(v)
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, e := range v {
+			l[i] = jsonValue(e)
+		}
+		return l
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[k] = jsonValue(e)
+		}
+		return m
+	case *psStruct:
+		switch {
+		case v.Signature == psNode && len(v.Fields) == 3:
+			return jsonValue(v.Fields[2])
+		case v.Signature == psRelationship && len(v.Fields) == 5:
+			return jsonValue(v.Fields[4])
+		case v.Signature == psUnboundRelationship && len(v.Fields) == 3:
+			return jsonValue(v.Fields[2])
+		case v.Signature == psPath && len(v.Fields) == 3:
+			return pathValue(v)
+		}
+		return jsonValue(v.Fields)
+	}
+	return v
+}
+
+// A boltFloat is a float in a Bolt result.  It is encoded in JSON with a
+// decimal point even if it is whole, as the HTTP endpoints encode floats, so
+// it can still be told from an integer.
+type boltFloat float64
+
+func (f boltFloat) MarshalJSON() ([]byte, error) {
+	if math.IsInf(float64(f), 0) || math.IsNaN(float64(f)) {
+		return json.Marshal(float64(f)) // Fails, as over HTTP
+	}
+	b := strconv.AppendFloat(nil, float64(f), 'g', -1, 64)
+	if !bytes.ContainsAny(b, ".e") {
+		b = append(b, ".0"...)
+	}
+	return b, nil
+}
+
+// pathValue converts a path to a list of alternating node and relationship
+// property maps.
+func pathValue(s *psStruct) interface{} {
+	nodes, _ := s.Fields[0].([]interface{})
+	rels, _ := s.Fields[1].([]interface{})
+	seq, _ := s.Fields[2].([]interface{})
+	if len(nodes) == 0 {
+		return []interface{}{}
+	}
+	l := []interface{}{jsonValue(nodes[0])}
+	for i := 0; i+1 < len(seq); i += 2 {
+		r, _ := seq[i].(int64)
+		n, _ := seq[i+1].(int64)
+		if r < 0 {
+			r = -r
+		}
+		if r < 1 || int(r) > len(rels) || n < 0 || int(n) >= len(nodes) {
+			break
+		}
+		l = append(l, jsonValue(rels[r-1]), jsonValue(nodes[n]))
+	}
+	return l
+}
+
+// rawRow converts a decoded record to a result row.
+func rawRow(fields []interface{}) ([]*json.RawMessage, error) {
+	row := make([]*json.RawMessage, len(fields))
+	for i, f := range fields {
+		b, err := json.Marshal(jsonValue(f))
+		if err != nil {
+			return nil, err
+		}
+		raw := json.RawMessage(b)
+		row[i] = &raw
+	}
+	return row, nil
+}
+
+// boltStatsKeys maps the keys of the stats returned by the server over Bolt
+// to those returned over HTTP, as decoded into Stats.  They differ in more
+// than punctuation: "relationships-deleted" is "relationship_deleted".
+var boltStatsKeys = map[string]string{
+	"constraints-added":     "constraints_added",
+	"constraints-removed":   "constraints_removed",
+	"indexes-added":         "indexes_added",
+	"indexes-removed":       "indexes_removed",
+	"labels-added":          "labels_added",
+	"labels-removed":        "labels_removed",
+	"nodes-created":         "nodes_created",
+	"nodes-deleted":         "nodes_deleted",
+	"properties-set":        "properties_set",
+	"relationships-created": "relationships_created",
+	"relationships-deleted": "relationship_deleted",
+}
+
+// boltStats converts the stats returned by the server, e.g.
+// {"nodes-created": 1}, to Stats.
+func boltStats(m map[string]interface{}) (*Stats, error) {
+	j := map[string]interface{}{}
+	updates := false
+	for k, v := range m {
+		if key, ok := boltStatsKeys[k]; ok {
+			j[key] = v
+		}
+		if n, ok := v.(int64); ok && n > 0 || v == true {
+			updates = true
+		}
+	}
+	j["contains_updates"] = updates
+	b, err := json.Marshal(j)
+	if err != nil {
+		return nil, err
+	}
+	s := Stats{}
+	err = json.Unmarshal(b, &s)
+	return &s, err
+}
+
+// decodeMeta converts a value from response metadata, such as a plan or
+// notification, into v via JSON.
+func decodeMeta(m interface{}, v interface{}) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// parseServerVersion extracts the version from a server agent string such as
+// "Neo4j/3.5.0".
+func parseServerVersion(agent string) string {
+	return agent[strings.LastIndex(agent, "/")+1:]
+}
@@ -0,0 +1,108 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released under
+// the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for details.
+// Resist intellectual serfdom - the ownership of ideas is akin to slavery.
+
+package neoism
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// A Plan is an operator in the execution plan for a Cypher query.  The plan
+// returned by CypherQuery.Plan is the root operator, whose Children are the
+// operators feeding rows into it.
+type Plan struct {
+	OperatorType  string
+	Identifiers   []string
+	EstimatedRows float64
+	// Rows and DbHits are only populated when the query was profiled.
+	Rows      int64
+	DbHits    int64
+	Profiled  bool
+	Arguments map[string]interface{} // Any other arguments supplied by the server
+	Children  []*Plan
+}
+
+// UnmarshalJSON decodes an operator as returned by the transactional
+// endpoint, where the operator's arguments are mixed in with its type,
+// identifiers and children.
+func (p *Plan) UnmarshalJSON(b []byte) error {
+	m := map[string]json.RawMessage{}
+	err := json.Unmarshal(b, &m)
+	if err != nil {
+		return err
+	}
+	*p = Plan{Arguments: map[string]interface{}{}}
+	for k, raw := range m {
+		switch strings.ToLower(k) {
+		case "operatortype":
+			err = json.Unmarshal(raw, &p.OperatorType)
+		case "identifiers":
+			err = json.Unmarshal(raw, &p.Identifiers)
+		case "children":
+			err = json.Unmarshal(raw, &p.Children)
+		case "estimatedrows":
+			err = json.Unmarshal(raw, &p.EstimatedRows)
+		case "rows":
+			err = json.Unmarshal(raw, &p.Rows)
+			p.Profiled = true
+		case "dbhits":
+			err = json.Unmarshal(raw, &p.DbHits)
+			p.Profiled = true
+		case "args":
+			// Bolt returns arguments, including the estimated row
+			// count, in a nested map.
+			args := map[string]interface{}{}
+			err = json.Unmarshal(raw, &args)
+			for ak, av := range args {
+				p.Arguments[ak] = av
+			}
+			if f, ok := args["EstimatedRows"].(float64); ok {
+				p.EstimatedRows = f
+			}
+		default:
+			var v interface{}
+			err = json.Unmarshal(raw, &v)
+			p.Arguments[k] = v
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// String renders the plan as an indented tree, one operator per line, e.g.
+//
+//	ProduceResults [n] estimated rows: 1, rows: 1, db hits: 0
+//	+- Filter [n] estimated rows: 1, rows: 1, db hits: 2
+//	   +- NodeByLabelScan [n] estimated rows: 2, rows: 2, db hits: 3
+func (p *Plan) String() string {
+	var buf bytes.Buffer
+	p.render(&buf, "", "")
+	return buf.String()
+}
+
+// render writes the operator, prefixed by first, and its children, prefixed
+// by rest.
+func (p *Plan) render(buf *bytes.Buffer, first, rest string) {
+	buf.WriteString(first + p.OperatorType)
+	if len(p.Identifiers) > 0 {
+		ids := make([]string, len(p.Identifiers))
+		copy(ids, p.Identifiers)
+		sort.Strings(ids)
+		buf.WriteString(" [" + strings.Join(ids, ", ") + "]")
+	}
+	fmt.Fprintf(buf, " estimated rows: %g", p.EstimatedRows)
+	if p.Profiled {
+		fmt.Fprintf(buf, ", rows: %d, db hits: %d", p.Rows, p.DbHits)
+	}
+	buf.WriteString("\n")
+	for _, c := range p.Children {
+		c.render(buf, rest+"+- ", rest+"   ")
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released under
+// the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for details.
+// Resist intellectual serfdom - the ownership of ideas is akin to slavery.
+
+package neoism
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestPlanUnmarshal(t *testing.T) {
+	raw := `{
+		"operatorType": "ProduceResults",
+		"identifiers": ["n"],
+		"EstimatedRows": 1.5,
+		"DbHits": 0,
+		"Rows": 1,
+		"version": "CYPHER 3.5",
+		"children": [{
+			"operatorType": "NodeByLabelScan",
+			"identifiers": ["n"],
+			"EstimatedRows": 1.5,
+			"DbHits": 3,
+			"Rows": 1,
+			"LabelName": ":Person",
+			"children": []
+		}]
+	}`
+	p := Plan{}
+	err := json.Unmarshal([]byte(raw), &p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "ProduceResults", p.OperatorType)
+	assert.Equal(t, []string{"n"}, p.Identifiers)
+	assert.Equal(t, 1.5, p.EstimatedRows)
+	assert.Equal(t, true, p.Profiled)
+	assert.Equal(t, "CYPHER 3.5", p.Arguments["version"])
+	assert.Equal(t, 1, len(p.Children))
+	assert.Equal(t, int64(3), p.Children[0].DbHits)
+	assert.Equal(t, ":Person", p.Children[0].Arguments["LabelName"])
+	expected := "ProduceResults [n] estimated rows: 1.5, rows: 1, db hits: 0\n" +
+		"+- NodeByLabelScan [n] estimated rows: 1.5, rows: 1, db hits: 3\n"
+	assert.Equal(t, expected, p.String())
+}
+
+func TestCypherExplain(t *testing.T) {
+	db := connectTest(t)
+	defer cleanup(t, db)
+	cq := CypherQuery{
+		Statement: "MATCH (n:Person) RETURN n",
+		Explain:   true,
+	}
+	_, err := cq.Plan()
+	assert.NotEqual(t, nil, err)
+	err = db.Cypher(&cq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := cq.Plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "ProduceResults", p.OperatorType)
+	assert.Equal(t, false, p.Profiled)
+	err = db.CypherBatch([]*CypherQuery{&cq})
+	assert.NotEqual(t, nil, err)
+}
+
+func TestTxProfile(t *testing.T) {
+	db := connectTest(t)
+	defer cleanup(t, db)
+	res := []struct {
+		N string `json:"n.name"`
+	}{}
+	cq := CypherQuery{
+		Statement: "CREATE (n:Person {name: 'Scotty'}) RETURN n.name",
+		Profile:   true,
+		Result:    &res,
+	}
+	tx, err := db.Begin([]*CypherQuery{&cq})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	assert.Equal(t, "Scotty", res[0].N)
+	p, err := cq.Plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, true, p.Profiled)
+	assert.NotEqual(t, "", p.String())
+}
@@ -0,0 +1,299 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released under
+// the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for details.
+// Resist intellectual serfdom - the ownership of ideas is akin to slavery.
+
+package neoism
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	procedureNameRegex = regexp.MustCompile(`^[A-Za-z_]\w*(\.[A-Za-z_]\w*)*$`)
+	identifierRegex    = regexp.MustCompile(`^[A-Za-z_]\w*$`)
+)
+
+// CallProcedure calls the procedure name, e.g. "db.labels", with the
+// positional arguments args, which are sent as query parameters, and decodes
+// the records it returns into result as Cypher does.  If yield is given, only
+// those output fields are returned.  Procedures require Neo4j 3.0 or later.
+func (db *Database) CallProcedure(name string, args []interface{}, result interface{}, yield ...string) error {
+	if err := db.require(db.Capabilities().Procedures, "Procedures"); err != nil {
+		return err
+	}
+	if !procedureNameRegex.MatchString(name) {
+		return fmt.Errorf("Invalid procedure name %q", name)
+	}
+	for _, y := range yield {
+		if !identifierRegex.MatchString(y) {
+			return fmt.Errorf("Invalid output field name %q", y)
+		}
+	}
+	cq := CypherQuery{
+		Statement:  procedureCall(name, len(args), yield),
+		Parameters: map[string]interface{}{},
+		Result:     result,
+	}
+	for i, a := range args {
+		cq.Parameters["arg"+strconv.Itoa(i)] = a
+	}
+	return db.Cypher(&cq)
+}
+
+// procedureCall builds the statement calling a procedure with n arguments.
+func procedureCall(name string, n int, yield []string) string {
+	params := make([]string, n)
+	for i := range params {
+		params[i] = "$arg" + strconv.Itoa(i)
+	}
+	stmt := "CALL " + name + "(" + strings.Join(params, ", ") + ")"
+	if len(yield) > 0 {
+		stmt += " YIELD " + strings.Join(yield, ", ")
+	}
+	return stmt
+}
+
+// A SignatureField is an argument, or an output field, in the signature of a
+// procedure or function.
+type SignatureField struct {
+	Name     string
+	Type     string // e.g. "STRING?", "INTEGER?" or "LIST? OF MAP?"
+	Default  string // As written in the signature; blank unless Optional
+	Optional bool   // True if the argument has a default value
+}
+
+// A Procedure describes a procedure available on the server.
+type Procedure struct {
+	Name        string           `json:"name"`
+	Signature   string           `json:"signature"`
+	Description string           `json:"description"`
+	Mode        string           `json:"mode"` // e.g. "READ", "WRITE", "SCHEMA" or "DBMS"; Neo4j 3.4 and later
+	Arguments   []SignatureField `json:"-"`
+	Outputs     []SignatureField `json:"-"` // Empty for VOID procedures
+}
+
+// A Function describes a user-defined function available on the server.
+type Function struct {
+	Name        string           `json:"name"`
+	Signature   string           `json:"signature"`
+	Description string           `json:"description"`
+	Arguments   []SignatureField `json:"-"`
+	ReturnType  string           `json:"-"`
+}
+
+// Procedures lists the procedures available on the server, with their
+// signatures parsed, using dbms.procedures(), or SHOW PROCEDURES on Neo4j
+// 5.0 and later.
+func (db *Database) Procedures() ([]*Procedure, error) {
+	if err := db.require(db.Capabilities().Procedures, "Procedures"); err != nil {
+		return nil, err
+	}
+	procs := []*Procedure{}
+	cq := CypherQuery{
+		Statement: "CALL dbms.procedures()",
+		Result:    &procs,
+	}
+	if db.ServerVersion().AtLeast(5, 0, 0) {
+		cq.Statement = "SHOW PROCEDURES YIELD name, signature, description, mode"
+	}
+	if err := db.Cypher(&cq); err != nil {
+		return nil, err
+	}
+	for _, p := range procs {
+		args, ret := parseSignature(p.Signature)
+		p.Arguments = args
+		if ret != "VOID" {
+			p.Outputs = parseFields(strings.TrimSuffix(strings.TrimPrefix(ret, "("), ")"))
+		}
+	}
+	return procs, nil
+}
+
+// Functions lists the user-defined functions available on the server, with
+// their signatures parsed, using dbms.functions() of Neo4j 3.1 and later, or
+// SHOW FUNCTIONS on Neo4j 5.0 and later.
+func (db *Database) Functions() ([]*Function, error) {
+	err := db.require(db.ServerVersion().AtLeast(3, 1, 0), "User-defined functions")
+	if err != nil {
+		return nil, err
+	}
+	funcs := []*Function{}
+	cq := CypherQuery{
+		Statement: "CALL dbms.functions()",
+		Result:    &funcs,
+	}
+	if db.ServerVersion().AtLeast(5, 0, 0) {
+		cq.Statement = "SHOW FUNCTIONS YIELD name, signature, description"
+	}
+	if err := db.Cypher(&cq); err != nil {
+		return nil, err
+	}
+	for _, f := range funcs {
+		args, ret := parseSignature(f.Signature)
+		f.Arguments = args
+		f.ReturnType = strings.TrimSuffix(strings.TrimPrefix(ret, "("), ")")
+	}
+	return funcs, nil
+}
+
+// Validate checks that args suit the procedure's arguments, in number and,
+// as far as can be told, in type.
+func (p *Procedure) Validate(args []interface{}) error {
+	return validateArgs(p.Name, p.Arguments, args)
+}
+
+// Validate checks that args suit the function's arguments, in number and,
+// as far as can be told, in type.
+func (f *Function) Validate(args []interface{}) error {
+	return validateArgs(f.Name, f.Arguments, args)
+}
+
+func validateArgs(name string, fields []SignatureField, args []interface{}) error {
+	required := 0
+	for _, f := range fields {
+		if !f.Optional {
+			required++
+		}
+	}
+	if len(args) < required || len(args) > len(fields) {
+		if required == len(fields) {
+			return fmt.Errorf("%s takes %d arguments, not %d", name, required, len(args))
+		}
+		return fmt.Errorf("%s takes %d to %d arguments, not %d", name, required, len(fields), len(args))
+	}
+	for i, a := range args {
+		if !fitsType(a, fields[i].Type) {
+			return fmt.Errorf("Argument %s of %s must be of type %s, not %T", fields[i].Name, name, fields[i].Type, a)
+		}
+	}
+	return nil
+}
+
+// fitsType reports whether v may be passed as an argument of the Cypher type
+// typ.  Types other than the basic ones are not checked.
+func fitsType(v interface{}, typ string) bool {
+	if v == nil {
+		return strings.HasSuffix(typ, "?")
+	}
+	switch strings.TrimSuffix(typ, "?") {
+	case "STRING":
+		_, ok := v.(string)
+		return ok
+	case "BOOLEAN":
+		_, ok := v.(bool)
+		return ok
+	case "INTEGER":
+		switch v.(type) {
+		case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+			return true
+		}
+		return false
+	case "FLOAT", "NUMBER":
+		switch v.(type) {
+		case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+			return true
+		}
+		return false
+	}
+	return true
+}
+
+// parseSignature splits a signature such as
+// "db.index.fulltext.queryNodes(indexName :: STRING?, queryString :: STRING?, options = {} :: MAP?) :: (node :: NODE?, score :: FLOAT?)"
+// into its arguments, and what follows them: the output fields of a
+// procedure in parentheses, "VOID", or a function's return type.
+func parseSignature(sig string) ([]SignatureField, string) {
+	open := strings.Index(sig, "(")
+	if open < 0 {
+		return nil, ""
+	}
+	end := matchingParen(sig, open)
+	if end < 0 {
+		return nil, ""
+	}
+	args := parseFields(sig[open+1 : end])
+	ret := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(sig[end+1:]), "::"))
+	return args, ret
+}
+
+// parseFields parses a comma separated list of fields of the form
+// "name :: TYPE" or "name = default :: TYPE".
+func parseFields(s string) []SignatureField {
+	fields := []SignatureField{}
+	for _, part := range splitTopLevel(s) {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		f := SignatureField{}
+		i := strings.LastIndex(part, "::")
+		if i < 0 {
+			f.Name = part
+		} else {
+			f.Name = strings.TrimSpace(part[:i])
+			f.Type = strings.TrimSpace(part[i+2:])
+		}
+		if j := strings.Index(f.Name, "="); j >= 0 {
+			f.Default = strings.TrimSpace(f.Name[j+1:])
+			f.Name = strings.TrimSpace(f.Name[:j])
+			f.Optional = true
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+// splitTopLevel splits s at commas outside brackets and string literals.
+func splitTopLevel(s string) []string {
+	parts := []string{}
+	depth := 0
+	var quote rune
+	start := 0
+	for i, c := range s {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '(' || c == '[' || c == '{':
+			depth++
+		case c == ')' || c == ']' || c == '}':
+			depth--
+		case c == ',' && depth == 0:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// matchingParen returns the index of the parenthesis closing the one at
+// open, or -1.
+func matchingParen(s string, open int) int {
+	depth := 0
+	var quote byte
+	for i := open; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
@@ -0,0 +1,111 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released under
+// the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for details.
+// Resist intellectual serfdom - the ownership of ideas is akin to slavery.
+
+package neoism
+
+import (
+	"testing"
+
+	"github.com/jmcvetta/neoism/neoismtest"
+	"github.com/stretchr/testify/assert"
+)
+
+const queryNodesSignature = "db.index.fulltext.queryNodes(indexName :: STRING?, queryString :: STRING?, options = {skip: 0, limit: 10} :: MAP?) :: (node :: NODE?, score :: FLOAT?)"
+
+func TestCallProcedure(t *testing.T) {
+	fake := neoismtest.NewServer()
+	defer fake.Close()
+	var params map[string]interface{}
+	fake.HandleCypherFunc(`^CALL db\.index\.fulltext\.queryNodes\(\$arg0, \$arg1\) YIELD score$`,
+		func(stmt string, p map[string]interface{}) neoismtest.CypherResponse {
+			params = p
+			return neoismtest.CypherResponse{
+				Columns: []string{"score"},
+				Rows:    [][]interface{}{{0.9}, {0.4}},
+			}
+		})
+	db, err := Connect(fake.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := []struct {
+		Score float64 `json:"score"`
+	}{}
+	err = db.CallProcedure("db.index.fulltext.queryNodes", []interface{}{"people", "kirk"}, &res, "score")
+	if assert.Nil(t, err) && assert.Len(t, res, 2) {
+		assert.Equal(t, 0.9, res[0].Score)
+		assert.Equal(t, map[string]interface{}{"arg0": "people", "arg1": "kirk"}, params)
+	}
+	assert.NotNil(t, db.CallProcedure("db.labels() MATCH (n) DETACH DELETE n //", nil, nil))
+	assert.NotNil(t, db.CallProcedure("db.labels", nil, nil, "label, count(*)"))
+	assert.Equal(t, "CALL db.labels()", procedureCall("db.labels", 0, nil))
+}
+
+func TestProcedures(t *testing.T) {
+	fake := neoismtest.NewServer()
+	defer fake.Close()
+	fake.HandleCypher(`^CALL dbms\.procedures\(\)$`, neoismtest.CypherResponse{
+		Columns: []string{"name", "signature", "description", "mode"},
+		Rows: [][]interface{}{
+			{"db.index.fulltext.queryNodes", queryNodesSignature, "Query a full-text index.", "READ"},
+			{"dbms.security.deleteUser", "dbms.security.deleteUser(username :: STRING?) :: VOID", "Delete a user.", "DBMS"},
+		},
+	})
+	fake.HandleCypher(`^CALL dbms\.functions\(\)$`, neoismtest.CypherResponse{
+		Columns: []string{"name", "signature", "description"},
+		Rows: [][]interface{}{
+			{"apoc.text.join", "apoc.text.join(words :: LIST? OF STRING?, delimiter :: STRING?) :: (STRING?)", "Join strings."},
+		},
+	})
+	db, err := Connect(fake.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	procs, err := db.Procedures()
+	if !assert.Nil(t, err) || !assert.Len(t, procs, 2) {
+		return
+	}
+	p := procs[0]
+	assert.Equal(t, "READ", p.Mode)
+	assert.Equal(t, []SignatureField{
+		{Name: "indexName", Type: "STRING?"},
+		{Name: "queryString", Type: "STRING?"},
+		{Name: "options", Type: "MAP?", Default: "{skip: 0, limit: 10}", Optional: true},
+	}, p.Arguments)
+	assert.Equal(t, []SignatureField{
+		{Name: "node", Type: "NODE?"},
+		{Name: "score", Type: "FLOAT?"},
+	}, p.Outputs)
+	assert.Nil(t, p.Validate([]interface{}{"people", "kirk"}))
+	assert.Nil(t, p.Validate([]interface{}{"people", nil, map[string]interface{}{}}))
+	assert.NotNil(t, p.Validate([]interface{}{"people"}))
+	assert.NotNil(t, p.Validate([]interface{}{"people", 7}))
+	assert.Empty(t, procs[1].Outputs)
+	funcs, err := db.Functions()
+	if assert.Nil(t, err) && assert.Len(t, funcs, 1) {
+		f := funcs[0]
+		assert.Equal(t, "STRING?", f.ReturnType)
+		assert.Equal(t, "LIST? OF STRING?", f.Arguments[0].Type)
+		assert.Nil(t, f.Validate([]interface{}{[]string{"a", "b"}, ","}))
+		assert.NotNil(t, f.Validate([]interface{}{[]string{"a", "b"}, ",", "extra"}))
+	}
+	//
+	// Neo4j 5.0 and later replace dbms.procedures() with SHOW PROCEDURES.
+	//
+	fake5 := neoismtest.NewServerVersion("5.13.0")
+	defer fake5.Close()
+	fake5.HandleCypher(`^SHOW PROCEDURES`, neoismtest.CypherResponse{
+		Columns: []string{"name", "signature", "description", "mode"},
+		Rows:    [][]interface{}{{"db.labels", "db.labels() :: (label :: STRING)", "List all labels.", "READ"}},
+	})
+	db5, err := Connect(fake5.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	procs, err = db5.Procedures()
+	if assert.Nil(t, err) && assert.Len(t, procs, 1) {
+		assert.Empty(t, procs[0].Arguments)
+		assert.Equal(t, []SignatureField{{Name: "label", Type: "STRING"}}, procs[0].Outputs)
+	}
+}
@@ -0,0 +1,283 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released under
+// the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for details.
+// Resist intellectual serfdom - the ownership of ideas is akin to slavery.
+
+package neoism
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultSampleSize is the number of nodes or relationships PropertyUsage
+// examines per label or type, unless PropertyUsageOptions say otherwise.
+const DefaultSampleSize = 1000
+
+// PropertyUsageOptions configures PropertyUsage.
+type PropertyUsageOptions struct {
+	// Labels and Types, if not empty, restrict the analysis to nodes with
+	// those labels and relationships of those types.  Otherwise all labels
+	// and types are analysed.
+	Labels []string
+	Types  []string
+	// SkipNodes and SkipRelationships leave out nodes or relationships.
+	SkipNodes         bool
+	SkipRelationships bool
+	// SampleSize is the number of nodes or relationships examined per label
+	// or type.  If zero, DefaultSampleSize is used; if negative, all are
+	// examined.
+	SampleSize int
+}
+
+// A PropertyUsage reports the properties used by the nodes with a label, or
+// by the relationships of a type.
+type PropertyUsage struct {
+	Label        string // Label, or relationship type if Relationship is true
+	Relationship bool
+	Total        int // Nodes with the label, or relationships of the type
+	Sampled      int // Nodes or relationships examined
+	Properties   []*PropertyStats
+}
+
+// PropertyStats describe the values of a property within a PropertyUsage.
+// They are computed from the sample examined, so are estimates unless all
+// nodes or relationships were examined.
+type PropertyStats struct {
+	Key string
+	// Count is the number of nodes or relationships examined which have the
+	// property.
+	Count int
+	// Types lists the types of the values seen, sorted: "Boolean",
+	// "Integer", "Float", "String", "List" or "Map".  More than one type
+	// often reveals a data quality problem.
+	Types []string
+	// NullRatio is the fraction of the nodes or relationships examined which
+	// lack the property; Neo4j does not store null values.
+	NullRatio float64
+	// Distinct is the number of distinct values seen, and Cardinality an
+	// estimate of the number of distinct values among all nodes or
+	// relationships, scaled up from the sample.
+	Distinct    int
+	Cardinality int
+}
+
+// PropertyUsage examines nodes per label, and relationships per type, to
+// report which property keys are in use, the types of their values, how
+// often they are missing and how many distinct values they have.  This
+// helps to audit data quality, and to tell which of the keys listed by
+// PropertyKeys are still used.  Nodes without labels are not examined.
+func (db *Database) PropertyUsage(opts *PropertyUsageOptions) ([]*PropertyUsage, error) {
+	o := PropertyUsageOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.SampleSize == 0 {
+		o.SampleSize = DefaultSampleSize
+	}
+	usage := []*PropertyUsage{}
+	if !o.SkipNodes {
+		labels := o.Labels
+		if len(labels) == 0 {
+			var err error
+			labels, err = db.labelNames()
+			if err != nil {
+				return nil, err
+			}
+		}
+		for _, l := range labels {
+			u, err := db.propertyUsage(l, false, o.SampleSize)
+			if err != nil {
+				return nil, err
+			}
+			usage = append(usage, u)
+		}
+	}
+	if !o.SkipRelationships {
+		types := o.Types
+		if len(types) == 0 {
+			var err error
+			types, err = db.relTypeNames()
+			if err != nil {
+				return nil, err
+			}
+		}
+		for _, t := range types {
+			u, err := db.propertyUsage(t, true, o.SampleSize)
+			if err != nil {
+				return nil, err
+			}
+			usage = append(usage, u)
+		}
+	}
+	return usage, nil
+}
+
+// propertyUsage examines the nodes with a label, or relationships of a type.
+func (db *Database) propertyUsage(name string, rel bool, sampleSize int) (*PropertyUsage, error) {
+	pattern := "(x:" + quoteIdentifier(name) + ")"
+	if rel {
+		pattern = "()-[x:" + quoteIdentifier(name) + "]->()"
+	}
+	total := []struct {
+		N int `json:"n"`
+	}{}
+	count := CypherQuery{
+		Statement: "MATCH " + pattern + " RETURN count(x) AS n",
+		Result:    &total,
+		Mode:      AccessRead,
+	}
+	// properties() was added in Neo4j 3.1; earlier, entities are returned
+	// whole and their properties taken from them.
+	props := "properties(x)"
+	if !db.ServerVersion().AtLeast(3, 1, 0) {
+		props = "x"
+	}
+	rows := []struct {
+		Props json.RawMessage `json:"props"`
+	}{}
+	sample := CypherQuery{
+		Statement: "MATCH " + pattern + " RETURN " + props + " AS props",
+		Result:    &rows,
+		Mode:      AccessRead,
+	}
+	if sampleSize > 0 {
+		sample.Statement = "MATCH " + pattern + " WITH x LIMIT " + strconv.Itoa(sampleSize) + " RETURN " + props + " AS props"
+	}
+	if err := db.CypherBatch([]*CypherQuery{&count, &sample}); err != nil {
+		return nil, err
+	}
+	u := &PropertyUsage{Label: name, Relationship: rel, Sampled: len(rows)}
+	if len(total) == 1 {
+		u.Total = total[0].N
+	}
+	type tally struct {
+		stats  *PropertyStats
+		types  map[string]bool
+		values map[string]bool
+	}
+	tallies := map[string]*tally{}
+	for _, r := range rows {
+		// Numbers are decoded as json.Number, so that whole floats, which
+		// the server encodes as e.g. 2.0, can be told from integers.
+		p := map[string]interface{}{}
+		dec := json.NewDecoder(bytes.NewReader(r.Props))
+		dec.UseNumber()
+		if err := dec.Decode(&p); err != nil {
+			return nil, err
+		}
+		if data, ok := p["data"].(map[string]interface{}); ok && p["self"] != nil {
+			p = data // An entity in the REST representation
+		}
+		for k, v := range p {
+			t, ok := tallies[k]
+			if !ok {
+				t = &tally{
+					stats:  &PropertyStats{Key: k},
+					types:  map[string]bool{},
+					values: map[string]bool{},
+				}
+				tallies[k] = t
+			}
+			t.stats.Count++
+			t.types[valueType(v)] = true
+			b, _ := json.Marshal(v)
+			t.values[string(b)] = true
+		}
+	}
+	for _, t := range tallies {
+		s := t.stats
+		for typ := range t.types {
+			s.Types = append(s.Types, typ)
+		}
+		sort.Strings(s.Types)
+		s.NullRatio = 1 - float64(s.Count)/float64(u.Sampled)
+		s.Distinct = len(t.values)
+		s.Cardinality = estimateCardinality(s.Distinct, s.Count, u.Sampled, u.Total)
+		u.Properties = append(u.Properties, s)
+	}
+	sort.Slice(u.Properties, func(i, j int) bool {
+		return u.Properties[i].Key < u.Properties[j].Key
+	})
+	return u, nil
+}
+
+// estimateCardinality scales the number of distinct values seen in a sample
+// up to the whole population.  Values which were all distinct in the sample
+// are assumed to stay so; otherwise the count seen is kept, as values which
+// repeat are likely drawn from a small set.
+func estimateCardinality(distinct, count, sampled, total int) int {
+	if sampled == 0 || sampled >= total || distinct < count {
+		return distinct
+	}
+	return int(math.Round(float64(distinct) * float64(total) / float64(sampled)))
+}
+
+// valueType names the type of a property value, as decoded from JSON with
+// numbers as json.Number.  Numbers are told apart by their literal: the
+// server writes floats with a decimal point or exponent, even whole ones.
+func valueType(v interface{}) string {
+	switch x := v.(type) {
+	case bool:
+		return "Boolean"
+	case json.Number:
+		if strings.ContainsAny(string(x), ".eE") {
+			return "Float"
+		}
+		return "Integer"
+	case string:
+		return "String"
+	case []interface{}:
+		return "List"
+	case map[string]interface{}:
+		return "Map"
+	}
+	return "Unknown"
+}
+
+// quoteIdentifier quotes a label, relationship type, property key, user or
+// role name for use in a Cypher statement.
+func quoteIdentifier(s string) string {
+	return "`" + strings.Replace(s, "`", "``", -1) + "`"
+}
+
+// labelNames lists all labels, using the REST API or the db.labels()
+// procedure.
+func (db *Database) labelNames() ([]string, error) {
+	if db.Capabilities().REST {
+		return db.Labels()
+	}
+	return db.callList("Labels", "CALL db.labels() YIELD label AS name RETURN name")
+}
+
+// relTypeNames lists all relationship types, using the REST API or the
+// db.relationshipTypes() procedure.
+func (db *Database) relTypeNames() ([]string, error) {
+	if db.Capabilities().REST {
+		return db.RelTypes()
+	}
+	return db.callList("RelTypes", "CALL db.relationshipTypes() YIELD relationshipType AS name RETURN name")
+}
+
+// callList calls a procedure listing names, which it returns as the column
+// "name".  Procedures require Neo4j 3.0 or later.
+func (db *Database) callList(feature, stmt string) ([]string, error) {
+	if err := db.require(db.Capabilities().Procedures, feature); err != nil {
+		return nil, err
+	}
+	res := []struct {
+		Name string `json:"name"`
+	}{}
+	cq := CypherQuery{Statement: stmt, Result: &res}
+	if err := db.Cypher(&cq); err != nil {
+		return nil, err
+	}
+	names := make([]string, len(res))
+	for i, r := range res {
+		names[i] = r.Name
+	}
+	return names, nil
+}
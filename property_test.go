@@ -0,0 +1,133 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released under
+// the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for details.
+// Resist intellectual serfdom - the ownership of ideas is akin to slavery.
+
+package neoism
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/jmcvetta/neoism/neoismtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPropertyKeysMethod(t *testing.T) {
+	fake := neoismtest.NewServer()
+	defer fake.Close()
+	db, err := Connect(fake.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.CreateNode(Props{"name": "Kirk"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := db.PropertyKeys()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"name"}, keys)
+	keys, err = PropertyKeys(db)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"name"}, keys)
+	//
+	// Without the REST API, the db.propertyKeys() procedure is used.
+	//
+	fake4 := neoismtest.NewServerVersion("4.4.0")
+	defer fake4.Close()
+	fake4.HandleCypher(`^CALL db\.propertyKeys\(\)`, neoismtest.CypherResponse{
+		Columns: []string{"name"},
+		Rows:    [][]interface{}{{"name"}, {"rank"}},
+	})
+	db4, err := Connect(fake4.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err = db4.PropertyKeys()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"name", "rank"}, keys)
+}
+
+func TestPropertyUsage(t *testing.T) {
+	fake := neoismtest.NewServer()
+	defer fake.Close()
+	fake.HandleCypher("^MATCH \\(x:`Person`\\) RETURN count", neoismtest.CypherResponse{
+		Columns: []string{"n"},
+		Rows:    [][]interface{}{{8}},
+	})
+	fake.HandleCypher("^MATCH \\(x:`Person`\\) WITH x LIMIT 4 RETURN properties\\(x\\)", neoismtest.CypherResponse{
+		Columns: []string{"props"},
+		Rows: [][]interface{}{
+			{map[string]interface{}{"name": "Kirk", "rank": "Captain", "age": 34}},
+			{map[string]interface{}{"name": "Spock", "rank": "Commander", "age": "unknown"}},
+			// A whole float, as the server encodes it
+			{map[string]interface{}{"name": "McCoy", "rank": "Commander", "age": json.Number("37.0")}},
+			{map[string]interface{}{"name": "Uhura", "rank": "Lieutenant"}},
+		},
+	})
+	fake.HandleCypher("^MATCH \\(\\)-\\[x:`SERVES_ON`\\]->\\(\\) RETURN count", neoismtest.CypherResponse{
+		Columns: []string{"n"},
+		Rows:    [][]interface{}{{0}},
+	})
+	fake.HandleCypher("^MATCH \\(\\)-\\[x:`SERVES_ON`\\]->\\(\\) WITH x LIMIT 4", neoismtest.CypherResponse{
+		Columns: []string{"props"},
+	})
+	db, err := Connect(fake.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	usage, err := db.PropertyUsage(&PropertyUsageOptions{
+		Labels:     []string{"Person"},
+		Types:      []string{"SERVES_ON"},
+		SampleSize: 4,
+	})
+	if !assert.Nil(t, err) || !assert.Len(t, usage, 2) {
+		return
+	}
+	u := usage[0]
+	assert.Equal(t, "Person", u.Label)
+	assert.False(t, u.Relationship)
+	assert.Equal(t, 8, u.Total)
+	assert.Equal(t, 4, u.Sampled)
+	if assert.Len(t, u.Properties, 3) {
+		age, name, rank := u.Properties[0], u.Properties[1], u.Properties[2]
+		assert.Equal(t, "age", age.Key)
+		assert.Equal(t, 3, age.Count)
+		assert.Equal(t, 0.25, age.NullRatio)
+		assert.Equal(t, []string{"Float", "Integer", "String"}, age.Types)
+		assert.Equal(t, 0.0, name.NullRatio)
+		assert.Equal(t, []string{"String"}, name.Types)
+		assert.Equal(t, 4, name.Distinct)
+		assert.Equal(t, 8, name.Cardinality)
+		assert.Equal(t, 3, rank.Distinct)
+		assert.Equal(t, 3, rank.Cardinality)
+	}
+	assert.Equal(t, "SERVES_ON", usage[1].Label)
+	assert.True(t, usage[1].Relationship)
+	assert.Empty(t, usage[1].Properties)
+	assert.Equal(t, "`Odd``Label`", quoteIdentifier("Odd`Label"))
+}
+
+func TestValueType(t *testing.T) {
+	for lit, typ := range map[string]string{
+		"2":       "Integer",
+		"-17":     "Integer",
+		"2.0":     "Float",
+		"2.5":     "Float",
+		"1e+21":   "Float",
+		`"2.0"`:   "String",
+		"true":    "Boolean",
+		"[1, 2]":  "List",
+		`{"a":1}`: "Map",
+	} {
+		var v interface{}
+		dec := json.NewDecoder(strings.NewReader(lit))
+		dec.UseNumber()
+		assert.Nil(t, dec.Decode(&v))
+		assert.Equal(t, typ, valueType(v), lit)
+	}
+	// Whole floats received over Bolt keep their decimal point.
+	b, err := json.Marshal(jsonValue(map[string]interface{}{"f": 2.0, "i": int64(2)}))
+	assert.Nil(t, err)
+	assert.Equal(t, `{"f":2.0,"i":2}`, string(b))
+}
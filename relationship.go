@@ -12,9 +12,16 @@ import (
 
 // Relationship fetches a Relationship from by id.
 func (db *Database) Relationship(id int) (*Relationship, error) {
+	if err := db.requireREST("Fetching relationships"); err != nil {
+		return nil, err
+	}
+	return db.getRelationshipByUri(join(db.Url, "relationship", strconv.Itoa(id)))
+}
+
+// getRelationshipByUri fetches a Relationship from the DB given its URI.
+func (db *Database) getRelationshipByUri(uri string) (*Relationship, error) {
 	rel := Relationship{}
 	rel.Db = db
-	uri := join(db.Url, "relationship", strconv.Itoa(id))
 	ne := NeoError{}
 	resp, err := db.Session.Get(uri, nil, &rel, &ne)
 	if err != nil {
@@ -33,6 +40,9 @@ func (db *Database) Relationship(id int) (*Relationship, error) {
 
 // Types lists all existing relationship types
 func (db *Database) RelTypes() ([]string, error) {
+	if err := db.requireREST("RelTypes"); err != nil {
+		return nil, err
+	}
 	reltypes := []string{}
 	ne := NeoError{}
 	resp, err := db.Session.Get(db.HrefRelTypes, nil, &reltypes, &ne)
@@ -54,7 +64,7 @@ type Relationship struct {
 	HrefStart  string      `json:"start"`
 	HrefEnd    string      `json:"end"`
 	Data       interface{} `json:"data"`
-	Extensions interface{} `json:"extensions"`
+	Extensions Extensions  `json:"extensions"`
 }
 
 func (r *Relationship) hrefSelf() string {
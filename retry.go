@@ -0,0 +1,140 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released under
+// the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for details.
+// Resist intellectual serfdom - the ownership of ideas is akin to slavery.
+
+package neoism
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// A RetryPolicy determines which failed requests are retried, and how long to
+// wait between attempts.  GET, HEAD, OPTIONS, PUT and DELETE requests are
+// idempotent, and are retried on network errors and on the RetryableStatuses.
+// POST requests are only retried if RetryPOST is set, as a POST which reached
+// the server before failing may have executed.
+type RetryPolicy struct {
+	MaxAttempts       int // Including the first; 1 or less disables retries
+	InitialBackoff    time.Duration
+	MaxBackoff        time.Duration
+	Multiplier        float64 // Backoff growth per attempt; 2 if zero
+	Jitter            float64 // Backoffs vary randomly by up to this fraction
+	RetryableStatuses []int
+	RetryPOST         bool
+}
+
+// DefaultRetryPolicy returns the policy used by a new Database: up to three
+// attempts, backing off from 100ms, on network errors and 502, 503 and 504
+// responses.
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts:       3,
+		InitialBackoff:    100 * time.Millisecond,
+		MaxBackoff:        2 * time.Second,
+		Multiplier:        2,
+		Jitter:            0.2,
+		RetryableStatuses: []int{502, 503, 504},
+	}
+}
+
+// retryState holds a database's retry policy.  It is shared by copies of the
+// Database.
+type retryState struct {
+	mu     sync.RWMutex
+	policy *RetryPolicy
+}
+
+// SetRetryPolicy sets the policy for retrying failed requests.  A nil policy
+// disables retries.  Requests over Bolt are not retried.
+func (db *Database) SetRetryPolicy(p *RetryPolicy) {
+	db.retry.mu.Lock()
+	defer db.retry.mu.Unlock()
+	db.retry.policy = p
+}
+
+func (rs *retryState) get() *RetryPolicy {
+	if rs == nil {
+		return nil
+	}
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+	return rs.policy
+}
+
+// shouldRetry returns true if the outcome of attempt number attempt of req
+// should be retried.
+func (p *RetryPolicy) shouldRetry(req *http.Request, resp *http.Response, err error, attempt int) bool {
+	if p == nil || attempt >= p.MaxAttempts {
+		return false
+	}
+	switch req.Method {
+	case "GET", "HEAD", "OPTIONS", "PUT", "DELETE":
+	case "POST":
+		if !p.RetryPOST {
+			return false
+		}
+	default:
+		return false
+	}
+	if req.Body != nil && req.GetBody == nil {
+		return false // Body cannot be replayed
+	}
+	if err != nil {
+		for _, fatal := range []error{context.Canceled, context.DeadlineExceeded, ErrCircuitOpen, ErrLimiterTimeout} {
+			if errors.Is(err, fatal) {
+				return false
+			}
+		}
+		return true
+	}
+	for _, s := range p.RetryableStatuses {
+		if resp.StatusCode == s {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns how long to wait after attempt number attempt.
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	m := p.Multiplier
+	if m == 0 {
+		m = 2
+	}
+	d := float64(p.InitialBackoff)
+	for i := 1; i < attempt; i++ {
+		d *= m
+	}
+	if p.MaxBackoff > 0 && d > float64(p.MaxBackoff) {
+		d = float64(p.MaxBackoff)
+	}
+	d *= 1 + p.Jitter*(2*rand.Float64()-1)
+	return time.Duration(d)
+}
+
+// wait prepares to retry req, by discarding its response resp, if any, and
+// sleeping for the backoff.  It returns a request for the next attempt, or nil
+// if the body cannot be replayed.
+func (p *RetryPolicy) wait(req *http.Request, resp *http.Response, attempt int) *http.Request {
+	next := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil
+		}
+		next.Body = body
+	}
+	if resp != nil {
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	time.Sleep(p.backoff(attempt))
+	return next
+}
@@ -0,0 +1,119 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released under
+// the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for details.
+// Resist intellectual serfdom - the ownership of ideas is akin to slavery.
+
+package neoism
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jmcvetta/neoism/neoismtest"
+	"github.com/stretchr/testify/assert"
+)
+
+// flakyServer fails the first failures requests to paths containing
+// substring with a 503, and proxies everything to the fake.
+type flakyServer struct {
+	sync.Mutex
+	fake      *neoismtest.Server
+	substring string
+	failures  int
+	bodies    []string
+}
+
+func (f *flakyServer) RoundTrip(req *http.Request) (*http.Response, error) {
+	f.Lock()
+	fail := strings.Contains(req.URL.Path, f.substring) && f.failures > 0
+	if fail {
+		f.failures--
+	}
+	if req.Body != nil && strings.Contains(req.URL.Path, f.substring) {
+		b, _ := ioutil.ReadAll(req.Body)
+		f.bodies = append(f.bodies, string(b))
+		req.Body = ioutil.NopCloser(strings.NewReader(string(b)))
+	}
+	f.Unlock()
+	if fail {
+		w := httptest.NewRecorder()
+		w.WriteHeader(503)
+		return w.Result(), nil
+	}
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func connectFlaky(t *testing.T, substring string, failures int) (*flakyServer, *Database) {
+	f := &flakyServer{
+		fake:      neoismtest.NewServer(),
+		substring: substring,
+		failures:  failures,
+	}
+	db, err := ConnectWithClient(f.fake.URL, &http.Client{Transport: f})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := DefaultRetryPolicy()
+	p.InitialBackoff = time.Millisecond
+	db.SetRetryPolicy(p)
+	return f, db
+}
+
+func TestRetryGet(t *testing.T) {
+	f, db := connectFlaky(t, "/labels", 2)
+	defer f.fake.Close()
+	_, err := db.Labels()
+	assert.Nil(t, err)
+	s := db.Stats()
+	assert.Equal(t, int64(2), s.Retries)
+	assert.Equal(t, int64(2), s.Statuses[503])
+	//
+	// Attempts are limited
+	//
+	f.failures = 3
+	_, err = db.Labels()
+	assert.NotNil(t, err)
+	assert.Equal(t, int64(4), db.Stats().Retries)
+}
+
+func TestRetryPost(t *testing.T) {
+	f, db := connectFlaky(t, "/node", 1)
+	defer f.fake.Close()
+	_, err := db.CreateNode(Props{"name": "Kirk"})
+	assert.NotNil(t, err) // POSTs are not retried by default
+	p := DefaultRetryPolicy()
+	p.InitialBackoff = time.Millisecond
+	p.RetryPOST = true
+	db.SetRetryPolicy(p)
+	f.failures = 1
+	n, err := db.CreateNode(Props{"name": "Kirk"})
+	assert.Nil(t, err)
+	assert.Equal(t, "Kirk", n.Data["name"])
+	assert.Equal(t, f.bodies[1], f.bodies[2]) // Body is replayed
+	//
+	// Disabled
+	//
+	db.SetRetryPolicy(nil)
+	f.failures = 1
+	_, err = db.Labels()
+	assert.Nil(t, err) // Path does not match
+	_, err = db.CreateNode(Props{"name": "Kirk"})
+	assert.NotNil(t, err)
+}
+
+func TestRetryBackoff(t *testing.T) {
+	p := DefaultRetryPolicy()
+	p.Jitter = 0
+	assert.Equal(t, 100*time.Millisecond, p.backoff(1))
+	assert.Equal(t, 400*time.Millisecond, p.backoff(3))
+	assert.Equal(t, 2*time.Second, p.backoff(10))
+	req, _ := http.NewRequest("GET", "http://localhost/db/data/", nil)
+	assert.True(t, p.shouldRetry(req, nil, http.ErrHandlerTimeout, 1))
+	assert.False(t, p.shouldRetry(req, nil, context.Canceled, 1))
+	assert.False(t, p.shouldRetry(req, nil, http.ErrHandlerTimeout, 3))
+}
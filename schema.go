@@ -5,7 +5,12 @@
 package neoism
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
 )
 
 type indexRequest struct {
@@ -39,6 +44,9 @@ func (idx *Index) Drop() error {
 // CreateIndex starts a background job in the database that will create and
 // populate the new index of a specified property on nodes of a given label.
 func (db *Database) CreateIndex(label, property string) (*Index, error) {
+	if err := db.require(db.Capabilities().SchemaREST, "The schema REST API"); err != nil {
+		return nil, err
+	}
 	uri := join(db.Url, "schema/index", label)
 	payload := indexRequest{[]string{property}}
 	result := Index{db: db}
@@ -59,6 +67,9 @@ func (db *Database) CreateIndex(label, property string) (*Index, error) {
 // Indexes lists indexes for a label.  If a blank string is given as the label,
 // returns all indexes.
 func (db *Database) Indexes(label string) ([]*Index, error) {
+	if err := db.require(db.Capabilities().SchemaREST, "The schema REST API"); err != nil {
+		return nil, err
+	}
 	uri := join(db.Url, "schema/index", label)
 	result := []*Index{}
 	ne := NeoError{}
@@ -78,6 +89,323 @@ func (db *Database) Indexes(label string) ([]*Index, error) {
 	return result, nil
 }
 
+// An IndexState describes the population state of an index.
+type IndexState string
+
+// States reported by the server for an index.
+const (
+	IndexOnline     IndexState = "ONLINE"
+	IndexPopulating IndexState = "POPULATING"
+	IndexFailed     IndexState = "FAILED"
+)
+
+// An IndexFailedError is returned when the server reports that population of
+// an index has failed.
+type IndexFailedError struct {
+	Label        string
+	PropertyKeys []string
+	Message      string // Failure message supplied by the server, if any
+}
+
+// Error describes the failed index and the reason supplied by the server.
+func (e *IndexFailedError) Error() string {
+	msg := fmt.Sprintf("Population of index on :%s(%s) failed", e.Label, strings.Join(e.PropertyKeys, ","))
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	return msg
+}
+
+// indexStatus is a row returned by the db.indexes() procedure.  The columns
+// returned vary between server versions, so all of them are optional.
+type indexStatus struct {
+	Name              string   `json:"name"` // 4.0 and later
+	Type              string   `json:"type"`
+	EntityType        string   `json:"entityType"` // 4.0 and later
+	Description       string   `json:"description"`
+	Label             string   `json:"label"`         // 3.1 - 3.3
+	TokenNames        []string `json:"tokenNames"`    // 3.4 - 3.5
+	LabelsOrTypes     []string `json:"labelsOrTypes"` // 4.x
+	Properties        []string `json:"properties"`
+	State             string   `json:"state"`
+	Progress          *float64 `json:"progress"`          // 3.5
+	PopulationPercent *float64 `json:"populationPercent"` // 4.x
+	FailureMessage    string   `json:"failureMessage"`
+	Uniqueness        string   `json:"uniqueness"`       // 4.0 - 4.4; "UNIQUE" if backing a constraint
+	OwningConstraint  string   `json:"owningConstraint"` // 5.0 and later
+}
+
+// descriptionRegex extracts label and property keys from an index description
+// such as "INDEX ON :Person(name)", for servers that report nothing else.
+var descriptionRegex = regexp.MustCompile(":`?([^(`]+)`?\\((.*)\\)")
+
+// label returns the label the index applies to.
+func (s *indexStatus) label() string {
+	switch {
+	case s.Label != "":
+		return s.Label
+	case len(s.TokenNames) > 0:
+		return s.TokenNames[0]
+	case len(s.LabelsOrTypes) > 0:
+		return s.LabelsOrTypes[0]
+	}
+	if m := descriptionRegex.FindStringSubmatch(s.Description); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// propertyKeys returns the property keys covered by the index.
+func (s *indexStatus) propertyKeys() []string {
+	if len(s.Properties) > 0 {
+		return s.Properties
+	}
+	keys := []string{}
+	if m := descriptionRegex.FindStringSubmatch(s.Description); m != nil {
+		for _, k := range strings.Split(m[2], ",") {
+			keys = append(keys, strings.Trim(strings.TrimSpace(k), "`"))
+		}
+	}
+	return keys
+}
+
+// progress returns the population progress as a percentage.  Servers that do
+// not report progress are assumed to be done once the index is online.
+func (s *indexStatus) progress() float64 {
+	switch {
+	case s.Progress != nil:
+		return *s.Progress
+	case s.PopulationPercent != nil:
+		return *s.PopulationPercent
+	case IndexState(s.State) == IndexOnline:
+		return 100
+	}
+	return 0
+}
+
+// matches reports whether the status row describes the index idx.
+func (s *indexStatus) matches(idx *Index) bool {
+	if s.label() != idx.Label {
+		return false
+	}
+	keys := s.propertyKeys()
+	if len(keys) != len(idx.PropertyKeys) {
+		return false
+	}
+	for i, k := range keys {
+		if k != idx.PropertyKeys[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// err returns an IndexFailedError if population of the index has failed.
+func (s *indexStatus) err() error {
+	if IndexState(s.State) != IndexFailed {
+		return nil
+	}
+	return &IndexFailedError{
+		Label:        s.label(),
+		PropertyKeys: s.propertyKeys(),
+		Message:      s.FailureMessage,
+	}
+}
+
+// propertyIndex reports whether the row describes an index on properties of
+// nodes with a label, as opposed to a full-text, lookup or other index.
+func (s *indexStatus) propertyIndex() bool {
+	if s.EntityType != "" && s.EntityType != "NODE" {
+		return false
+	}
+	switch s.Type {
+	case "", "BTREE", "RANGE", "node_label_property", "node_unique_property":
+		return s.label() != ""
+	}
+	return false
+}
+
+// backing reports whether the index exists to back a unique constraint.
+func (s *indexStatus) backing() bool {
+	return s.Uniqueness == "UNIQUE" || s.OwningConstraint != "" || s.Type == "node_unique_property"
+}
+
+// indexStatuses fetches the state of all indexes using the db.indexes()
+// procedure, which requires Neo4j 3.0 or later, or SHOW INDEXES on Neo4j
+// 4.4 and later, where db.indexes() is deprecated; 5.0 removed it.
+func (db *Database) indexStatuses() ([]indexStatus, error) {
+	if err := db.require(db.Capabilities().Procedures, "Index states"); err != nil {
+		return nil, err
+	}
+	result := []indexStatus{}
+	cq := CypherQuery{
+		Statement: "CALL db.indexes()",
+		Result:    &result,
+	}
+	if db.ServerVersion().AtLeast(4, 4, 0) {
+		cq.Statement = "SHOW INDEXES YIELD *"
+	}
+	err := db.Cypher(&cq)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// status fetches the current status of the index.
+func (idx *Index) status() (*indexStatus, error) {
+	statuses, err := idx.db.indexStatuses()
+	if err != nil {
+		return nil, err
+	}
+	for i := range statuses {
+		if statuses[i].matches(idx) {
+			return &statuses[i], nil
+		}
+	}
+	return nil, NotFound
+}
+
+// State reports whether the index is online, still populating, or failed.  If
+// population has failed, the returned error is an *IndexFailedError carrying
+// the failure message supplied by the server.
+func (idx *Index) State() (IndexState, error) {
+	s, err := idx.status()
+	if err != nil {
+		return "", err
+	}
+	return IndexState(s.State), s.err()
+}
+
+// Progress reports how far population of the index has progressed, as a
+// percentage.  Servers older than 3.5 do not report progress, in which case
+// Progress is 100 for an online index and 0 otherwise.
+func (idx *Index) Progress() (float64, error) {
+	s, err := idx.status()
+	if err != nil {
+		return 0, err
+	}
+	return s.progress(), s.err()
+}
+
+// AwaitIndexes polls the server until all indexes are online.  It gives up
+// when ctx is done or, if timeout is greater than zero, when timeout has
+// elapsed.  If population of any index fails, an *IndexFailedError is
+// returned immediately.
+func (db *Database) AwaitIndexes(ctx context.Context, timeout time.Duration) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	delay := 50 * time.Millisecond
+	for {
+		statuses, err := db.indexStatuses()
+		if err != nil {
+			return err
+		}
+		online := true
+		for i := range statuses {
+			s := &statuses[i]
+			if err := s.err(); err != nil {
+				return err
+			}
+			if IndexState(s.State) != IndexOnline {
+				online = false
+			}
+		}
+		if online {
+			return nil // Success
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		if delay < time.Second {
+			delay *= 2
+		}
+	}
+}
+
+// constraintStatus is a row returned by the db.constraints() procedure, or
+// by SHOW CONSTRAINTS.  Servers before 4.4 report only the description.
+type constraintStatus struct {
+	Name          string   `json:"name"` // 4.0 and later
+	Description   string   `json:"description"`
+	Type          string   `json:"type"`          // 4.4 and later
+	EntityType    string   `json:"entityType"`    // 4.4 and later
+	LabelsOrTypes []string `json:"labelsOrTypes"` // 4.4 and later
+	Properties    []string `json:"properties"`    // 4.4 and later
+}
+
+// constraintDescriptionRegex extracts label and properties from a constraint
+// description such as "CONSTRAINT ON ( person:Person ) ASSERT person.name IS
+// UNIQUE".
+var constraintDescriptionRegex = regexp.MustCompile("ON \\(\\s*`?\\w*`?:`?([^`)]+?)`?\\s*\\) ASSERT \\(?(.+?)\\)? IS UNIQUE")
+
+// unique reports whether the row describes a unique constraint on nodes.
+func (s *constraintStatus) unique() bool {
+	switch {
+	case s.Type == "UNIQUENESS" || s.Type == "NODE_PROPERTY_UNIQUENESS":
+		return s.EntityType == "" || s.EntityType == "NODE"
+	case s.Type == "":
+		return constraintDescriptionRegex.MatchString(s.Description)
+	}
+	return false
+}
+
+// label returns the label the constraint applies to.
+func (s *constraintStatus) label() string {
+	if len(s.LabelsOrTypes) > 0 {
+		return s.LabelsOrTypes[0]
+	}
+	if m := constraintDescriptionRegex.FindStringSubmatch(s.Description); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// propertyKeys returns the property keys covered by the constraint.
+func (s *constraintStatus) propertyKeys() []string {
+	if len(s.Properties) > 0 {
+		return s.Properties
+	}
+	keys := []string{}
+	if m := constraintDescriptionRegex.FindStringSubmatch(s.Description); m != nil {
+		for _, k := range strings.Split(m[2], ",") {
+			k = strings.TrimSpace(k)
+			if i := strings.Index(k, "."); i >= 0 {
+				k = k[i+1:]
+			}
+			keys = append(keys, strings.Trim(k, "`"))
+		}
+	}
+	return keys
+}
+
+// constraintStatuses lists all constraints using the db.constraints()
+// procedure, or SHOW CONSTRAINTS on Neo4j 4.4 and later, where
+// db.constraints() is deprecated; 5.0 removed it.
+func (db *Database) constraintStatuses() ([]constraintStatus, error) {
+	if err := db.require(db.Capabilities().Procedures, "Constraints"); err != nil {
+		return nil, err
+	}
+	result := []constraintStatus{}
+	cq := CypherQuery{
+		Statement: "CALL db.constraints()",
+		Result:    &result,
+	}
+	if db.ServerVersion().AtLeast(4, 4, 0) {
+		cq.Statement = "SHOW CONSTRAINTS YIELD *"
+	}
+	err := db.Cypher(&cq)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
 type uniqueConstraintRequest struct {
 	PropertyKeys []string `json:"property_keys"`
 }
@@ -94,6 +422,9 @@ type UniqueConstraint struct {
 // CreateUniqueConstraint create a unique constraint on a property on nodes
 // with a specific label.
 func (db *Database) CreateUniqueConstraint(label, property string) (*UniqueConstraint, error) {
+	if err := db.require(db.Capabilities().SchemaREST, "The schema REST API"); err != nil {
+		return nil, err
+	}
 	uri := join(db.Url, "schema/constraint", label, "uniqueness")
 	payload := uniqueConstraintRequest{[]string{property}}
 	result := UniqueConstraint{db: db}
@@ -117,6 +448,9 @@ func (db *Database) CreateUniqueConstraint(label, property string) (*UniqueConst
 // If a blank string is given as the property, return all unique constraint for
 // the label.
 func (db *Database) UniqueConstraints(label, property string) ([]*UniqueConstraint, error) {
+	if err := db.require(db.Capabilities().SchemaREST, "The schema REST API"); err != nil {
+		return nil, err
+	}
 	if label == "" {
 		return nil, errors.New("Label cannot be empty")
 	}
@@ -0,0 +1,342 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released under
+// the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for details.
+// Resist intellectual serfdom - the ownership of ideas is akin to slavery.
+
+package neoism
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// A Schema describes the indexes and unique constraints in a database, either
+// as they currently are or as they are desired to be.
+type Schema struct {
+	Indexes           []*Index            `json:"indexes"`
+	UniqueConstraints []*UniqueConstraint `json:"unique_constraints"`
+}
+
+// CurrentSchema reads all indexes and unique constraints from the database,
+// using the schema REST API or, on Neo4j 4.0 and later, Cypher.  Indexes that
+// exist only to back a unique constraint are not listed, nor are full-text,
+// lookup and other special indexes.
+func (db *Database) CurrentSchema() (*Schema, error) {
+	if !db.Capabilities().SchemaREST {
+		return db.cypherSchema()
+	}
+	indexes, err := db.Indexes("")
+	if err != nil {
+		return nil, err
+	}
+	constraints, err := db.allUniqueConstraints()
+	if err != nil {
+		return nil, err
+	}
+	s := Schema{UniqueConstraints: constraints}
+	backing := schemaKeys(nil, constraints)
+	for _, idx := range indexes {
+		if !backing[schemaKey(idx.Label, idx.PropertyKeys)] {
+			s.Indexes = append(s.Indexes, idx)
+		}
+	}
+	return &s, nil
+}
+
+// cypherSchema reads the schema with db.indexes() and db.constraints(), or
+// SHOW INDEXES and SHOW CONSTRAINTS.
+func (db *Database) cypherSchema() (*Schema, error) {
+	statuses, err := db.indexStatuses()
+	if err != nil {
+		return nil, err
+	}
+	cstrs, err := db.constraintStatuses()
+	if err != nil {
+		return nil, err
+	}
+	s := Schema{}
+	for i := range cstrs {
+		c := &cstrs[i]
+		if c.unique() {
+			s.UniqueConstraints = append(s.UniqueConstraints, &UniqueConstraint{
+				db:           db,
+				Label:        c.label(),
+				Type:         "UNIQUENESS",
+				PropertyKeys: c.propertyKeys(),
+			})
+		}
+	}
+	backing := schemaKeys(nil, s.UniqueConstraints)
+	for i := range statuses {
+		st := &statuses[i]
+		if !st.propertyIndex() || st.backing() {
+			continue
+		}
+		idx := &Index{db: db, Label: st.label(), PropertyKeys: st.propertyKeys()}
+		if !backing[schemaKey(idx.Label, idx.PropertyKeys)] {
+			s.Indexes = append(s.Indexes, idx)
+		}
+	}
+	return &s, nil
+}
+
+// allUniqueConstraints lists the unique constraints on all labels.
+func (db *Database) allUniqueConstraints() ([]*UniqueConstraint, error) {
+	if err := db.require(db.Capabilities().SchemaREST, "The schema REST API"); err != nil {
+		return nil, err
+	}
+	uri := join(db.Url, "schema/constraint")
+	result := []*UniqueConstraint{}
+	ne := NeoError{}
+	resp, err := db.Session.Get(uri, nil, &result, &ne)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Status() != 200 {
+		return nil, ne
+	}
+	constraints := []*UniqueConstraint{}
+	for _, cstr := range result {
+		if cstr.Type != "UNIQUENESS" {
+			continue // Existence constraints are not managed here
+		}
+		cstr.db = db
+		constraints = append(constraints, cstr)
+	}
+	return constraints, nil
+}
+
+// A SchemaAction is the kind of change made by a SchemaChange.
+type SchemaAction string
+
+// Actions which may appear in a SchemaPlan.
+const (
+	SchemaDropUniqueConstraint   SchemaAction = "DROP CONSTRAINT"
+	SchemaDropIndex              SchemaAction = "DROP INDEX"
+	SchemaCreateIndex            SchemaAction = "CREATE INDEX"
+	SchemaCreateUniqueConstraint SchemaAction = "CREATE CONSTRAINT"
+)
+
+// A SchemaChange creates or drops a single index or unique constraint.
+type SchemaChange struct {
+	Action       SchemaAction
+	Label        string
+	PropertyKeys []string
+}
+
+// String returns the Cypher statement equivalent to the change.  Labels and
+// property keys are quoted with backticks.
+func (c SchemaChange) String() string {
+	switch c.Action {
+	case SchemaCreateIndex, SchemaDropIndex:
+		keys := make([]string, len(c.PropertyKeys))
+		for i, k := range c.PropertyKeys {
+			keys[i] = quoteIdentifier(k)
+		}
+		return fmt.Sprintf("%s ON :%s(%s)", c.Action, quoteIdentifier(c.Label), strings.Join(keys, ", "))
+	}
+	return fmt.Sprintf("%s ON (x:%s) ASSERT %s IS UNIQUE", c.Action, quoteIdentifier(c.Label), c.properties())
+}
+
+// properties returns the property keys of the change as properties of x,
+// parenthesized if there are several.
+func (c SchemaChange) properties() string {
+	if len(c.PropertyKeys) == 1 {
+		return c.propertyList()
+	}
+	return "(" + c.propertyList() + ")"
+}
+
+// propertyList returns the property keys of the change as a comma separated
+// list of properties of x.
+func (c SchemaChange) propertyList() string {
+	props := make([]string, len(c.PropertyKeys))
+	for i, k := range c.PropertyKeys {
+		props[i] = "x." + quoteIdentifier(k)
+	}
+	return strings.Join(props, ", ")
+}
+
+// validate checks that the change names a label and at least one property
+// key.
+func (c SchemaChange) validate() error {
+	if c.Label == "" {
+		return fmt.Errorf("%s has no label", c.Action)
+	}
+	if len(c.PropertyKeys) == 0 {
+		return fmt.Errorf("%s on :%s has no property keys", c.Action, c.Label)
+	}
+	return nil
+}
+
+// A SchemaPlan is an ordered list of changes which will bring a database from
+// one schema to another.
+type SchemaPlan []SchemaChange
+
+// Diff compares current and desired schemas, and returns the changes needed
+// to turn one into the other.  Changes are ordered so they can be applied
+// safely: all drops come before all creates, so an index being replaced by a
+// unique constraint on the same keys is gone before the constraint creates
+// its own backing index, and constraints are dropped before indexes.  An
+// index covered by a unique constraint in the same schema is treated as the
+// constraint's backing index, rather than as an index in its own right, and
+// so is never created or dropped by itself.
+func Diff(current, desired *Schema) SchemaPlan {
+	if current == nil {
+		current = &Schema{}
+	}
+	if desired == nil {
+		desired = &Schema{}
+	}
+	curIdx := schemaKeys(current.Indexes, nil)
+	curCstr := schemaKeys(nil, current.UniqueConstraints)
+	wantIdx := schemaKeys(desired.Indexes, nil)
+	wantCstr := schemaKeys(nil, desired.UniqueConstraints)
+	for key := range curCstr {
+		delete(curIdx, key)
+	}
+	for key := range wantCstr {
+		delete(wantIdx, key)
+	}
+	plan := SchemaPlan{}
+	plan = append(plan, sortedChanges(curCstr, wantCstr, SchemaDropUniqueConstraint)...)
+	plan = append(plan, sortedChanges(curIdx, wantIdx, SchemaDropIndex)...)
+	plan = append(plan, sortedChanges(wantIdx, curIdx, SchemaCreateIndex)...)
+	plan = append(plan, sortedChanges(wantCstr, curCstr, SchemaCreateUniqueConstraint)...)
+	return plan
+}
+
+// Apply executes the changes in plan, in order.  If dryRun is true, nothing is
+// executed.  The changes which were applied, or would have been applied in a
+// dry run, are returned; on error, these are the changes which succeeded
+// before the failure.
+func (db *Database) Apply(plan SchemaPlan, dryRun bool) ([]SchemaChange, error) {
+	applied := []SchemaChange{}
+	if dryRun {
+		return append(applied, plan...), nil
+	}
+	for _, c := range plan {
+		err := db.applySchemaChange(c)
+		if err != nil {
+			return applied, err
+		}
+		applied = append(applied, c)
+	}
+	return applied, nil
+}
+
+// applySchemaChange executes a single change using the schema REST API.
+// Composite indexes and constraints are not supported by the REST API, nor is
+// the REST API itself by Neo4j 4.0 and later, so such changes are executed as
+// Cypher statements instead.
+func (db *Database) applySchemaChange(c SchemaChange) error {
+	if err := c.validate(); err != nil {
+		return err
+	}
+	if len(c.PropertyKeys) != 1 || !db.Capabilities().SchemaREST {
+		stmt, err := db.schemaStatement(c)
+		if err != nil {
+			return err
+		}
+		cq := CypherQuery{Statement: stmt}
+		return db.Cypher(&cq)
+	}
+	var err error
+	switch c.Action {
+	case SchemaDropUniqueConstraint:
+		cstr := UniqueConstraint{db: db, Label: c.Label, PropertyKeys: c.PropertyKeys}
+		err = cstr.Drop()
+	case SchemaDropIndex:
+		idx := Index{db: db, Label: c.Label, PropertyKeys: c.PropertyKeys}
+		err = idx.Drop()
+	case SchemaCreateIndex:
+		_, err = db.CreateIndex(c.Label, c.PropertyKeys[0])
+	case SchemaCreateUniqueConstraint:
+		_, err = db.CreateUniqueConstraint(c.Label, c.PropertyKeys[0])
+	default:
+		err = fmt.Errorf("Unknown schema action %q", c.Action)
+	}
+	return err
+}
+
+// schemaStatement returns the Cypher statement executing the change.  Neo4j
+// 4.4 introduced the syntax "CREATE INDEX FOR ... ON" and "CREATE CONSTRAINT
+// FOR ... REQUIRE", and 5.0 removed the syntax returned by String, including
+// dropping an index or constraint by its schema rather than its name; on 4.4
+// and later the name is therefore looked up first.
+func (db *Database) schemaStatement(c SchemaChange) (string, error) {
+	if !db.ServerVersion().AtLeast(4, 4, 0) {
+		return c.String(), nil
+	}
+	key := schemaKey(c.Label, c.PropertyKeys)
+	switch c.Action {
+	case SchemaCreateIndex:
+		return fmt.Sprintf("CREATE INDEX FOR (x:%s) ON (%s)", quoteIdentifier(c.Label), c.propertyList()), nil
+	case SchemaCreateUniqueConstraint:
+		return fmt.Sprintf("CREATE CONSTRAINT FOR (x:%s) REQUIRE %s IS UNIQUE", quoteIdentifier(c.Label), c.properties()), nil
+	case SchemaDropIndex:
+		statuses, err := db.indexStatuses()
+		if err != nil {
+			return "", err
+		}
+		for i := range statuses {
+			s := &statuses[i]
+			if s.propertyIndex() && !s.backing() && schemaKey(s.label(), s.propertyKeys()) == key {
+				return "DROP INDEX " + quoteIdentifier(s.Name), nil
+			}
+		}
+		return "", NotFound
+	case SchemaDropUniqueConstraint:
+		cstrs, err := db.constraintStatuses()
+		if err != nil {
+			return "", err
+		}
+		for i := range cstrs {
+			s := &cstrs[i]
+			if s.unique() && schemaKey(s.label(), s.propertyKeys()) == key {
+				return "DROP CONSTRAINT " + quoteIdentifier(s.Name), nil
+			}
+		}
+		return "", NotFound
+	}
+	return "", fmt.Errorf("Unknown schema action %q", c.Action)
+}
+
+// schemaKey identifies an index or constraint by label and property keys.
+func schemaKey(label string, keys []string) string {
+	return label + "\x00" + strings.Join(keys, "\x00")
+}
+
+// schemaKeys maps the keys of the given indexes and constraints.
+func schemaKeys(indexes []*Index, constraints []*UniqueConstraint) map[string]bool {
+	m := map[string]bool{}
+	for _, idx := range indexes {
+		m[schemaKey(idx.Label, idx.PropertyKeys)] = true
+	}
+	for _, cstr := range constraints {
+		m[schemaKey(cstr.Label, cstr.PropertyKeys)] = true
+	}
+	return m
+}
+
+// sortedChanges returns a change with the given action for each key in from
+// that is not in to, sorted by label and property keys.
+func sortedChanges(from, to map[string]bool, action SchemaAction) []SchemaChange {
+	keys := []string{}
+	for key := range from {
+		if !to[key] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	changes := make([]SchemaChange, len(keys))
+	for i, key := range keys {
+		parts := strings.Split(key, "\x00")
+		changes[i] = SchemaChange{
+			Action:       action,
+			Label:        parts[0],
+			PropertyKeys: parts[1:],
+		}
+	}
+	return changes
+}
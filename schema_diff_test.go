@@ -0,0 +1,208 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released under
+// the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for details.
+// Resist intellectual serfdom - the ownership of ideas is akin to slavery.
+
+package neoism
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmcvetta/neoism/neoismtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiff(t *testing.T) {
+	current := &Schema{
+		Indexes: []*Index{
+			{Label: "Person", PropertyKeys: []string{"name"}},
+			{Label: "Person", PropertyKeys: []string{"email"}},
+		},
+		UniqueConstraints: []*UniqueConstraint{
+			{Label: "Account", PropertyKeys: []string{"id"}},
+		},
+	}
+	desired := &Schema{
+		Indexes: []*Index{
+			{Label: "Person", PropertyKeys: []string{"name"}},
+			{Label: "Person", PropertyKeys: []string{"email"}},
+			{Label: "Account", PropertyKeys: []string{"id"}},
+		},
+		UniqueConstraints: []*UniqueConstraint{
+			{Label: "Person", PropertyKeys: []string{"email"}},
+		},
+	}
+	plan := Diff(current, desired)
+	expected := SchemaPlan{
+		{SchemaDropUniqueConstraint, "Account", []string{"id"}},
+		{SchemaDropIndex, "Person", []string{"email"}},
+		{SchemaCreateIndex, "Account", []string{"id"}},
+		{SchemaCreateUniqueConstraint, "Person", []string{"email"}},
+	}
+	assert.Equal(t, expected, plan)
+	assert.Equal(t, "DROP INDEX ON :`Person`(`email`)", plan[1].String())
+	assert.Equal(t, "CREATE CONSTRAINT ON (x:`Person`) ASSERT x.`email` IS UNIQUE", plan[3].String())
+	odd := SchemaChange{SchemaCreateUniqueConstraint, "Odd Label", []string{"a`b", "c"}}
+	assert.Equal(t, "CREATE CONSTRAINT ON (x:`Odd Label`) ASSERT (x.`a``b`, x.`c`) IS UNIQUE", odd.String())
+	db := &Database{}
+	_, err := db.Apply(SchemaPlan{{SchemaCreateIndex, "Person", nil}}, false)
+	assert.NotNil(t, err)
+	assert.Equal(t, 0, len(Diff(desired, desired)))
+}
+
+func TestApplySchema(t *testing.T) {
+	db := connectTest(t)
+	defer cleanup(t, db)
+	defer cleanupIndexes(t, db)
+	defer cleanupUniqueConstraints(t, db)
+	label := rndStr(t)
+	prop0 := rndStr(t)
+	prop1 := rndStr(t)
+	_, err := db.CreateIndex(label, prop0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	desired := &Schema{
+		Indexes: []*Index{
+			{Label: label, PropertyKeys: []string{prop1}},
+		},
+		UniqueConstraints: []*UniqueConstraint{
+			{Label: label, PropertyKeys: []string{prop0}},
+		},
+	}
+	current, err := db.CurrentSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan := Diff(current, desired)
+	// Dry run changes nothing
+	applied, err := db.Apply(plan, true)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, len(plan), len(applied))
+	indexes, _ := db.Indexes(label)
+	assert.Equal(t, 1, len(indexes))
+	// Apply for real
+	_, err = db.Apply(plan, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err = db.CurrentSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 0, len(Diff(current, desired)))
+}
+
+func TestCypherSchema(t *testing.T) {
+	//
+	// Neo4j 5.0 and later: SHOW INDEXES, SHOW CONSTRAINTS and the new DDL.
+	//
+	fake := neoismtest.NewServerVersion("5.13.0")
+	defer fake.Close()
+	fake.HandleCypher(`^SHOW INDEXES YIELD \*$`, neoismtest.CypherResponse{
+		Columns: []string{"name", "type", "entityType", "labelsOrTypes", "properties", "state", "owningConstraint"},
+		Rows: [][]interface{}{
+			{"index_1", "LOOKUP", "NODE", nil, nil, "ONLINE", nil},
+			{"person_name", "RANGE", "NODE", []string{"Person"}, []string{"name"}, "ONLINE", nil},
+			{"person_text", "FULLTEXT", "NODE", []string{"Person"}, []string{"bio"}, "ONLINE", nil},
+			{"knows_since", "RANGE", "RELATIONSHIP", []string{"KNOWS"}, []string{"since"}, "ONLINE", nil},
+			{"account_id", "RANGE", "NODE", []string{"Account"}, []string{"id"}, "ONLINE", "account_id_unique"},
+		},
+	})
+	fake.HandleCypher(`^SHOW CONSTRAINTS YIELD \*$`, neoismtest.CypherResponse{
+		Columns: []string{"name", "type", "entityType", "labelsOrTypes", "properties"},
+		Rows: [][]interface{}{
+			{"account_id_unique", "UNIQUENESS", "NODE", []string{"Account"}, []string{"id"}},
+			{"account_email_exists", "NODE_PROPERTY_EXISTENCE", "NODE", []string{"Account"}, []string{"email"}},
+		},
+	})
+	fake.HandleCypher(`^(CREATE|DROP) `, neoismtest.CypherResponse{})
+	db, err := Connect(fake.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err := db.CurrentSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.Len(t, current.Indexes, 1) {
+		assert.Equal(t, "Person", current.Indexes[0].Label)
+		assert.Equal(t, []string{"name"}, current.Indexes[0].PropertyKeys)
+	}
+	if assert.Len(t, current.UniqueConstraints, 1) {
+		assert.Equal(t, "Account", current.UniqueConstraints[0].Label)
+		assert.Equal(t, []string{"id"}, current.UniqueConstraints[0].PropertyKeys)
+	}
+	assert.Nil(t, db.AwaitIndexes(context.Background(), time.Second))
+	desired := &Schema{
+		Indexes: []*Index{
+			{Label: "Person", PropertyKeys: []string{"email", "name"}},
+		},
+		UniqueConstraints: []*UniqueConstraint{
+			{Label: "User", PropertyKeys: []string{"login"}},
+		},
+	}
+	_, err = db.Apply(Diff(current, desired), false)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{
+		"DROP CONSTRAINT `account_id_unique`",
+		"DROP INDEX `person_name`",
+		"CREATE INDEX FOR (x:`Person`) ON (x.`email`, x.`name`)",
+		"CREATE CONSTRAINT FOR (x:`User`) REQUIRE x.`login` IS UNIQUE",
+	}, ddlStatements(fake.Statements()))
+	//
+	// Neo4j 4.0 - 4.3: db.indexes(), db.constraints() and the old DDL.
+	//
+	fake4 := neoismtest.NewServerVersion("4.0.0")
+	defer fake4.Close()
+	fake4.HandleCypher(`^CALL db\.indexes\(\)$`, neoismtest.CypherResponse{
+		Columns: []string{"name", "type", "entityType", "labelsOrTypes", "properties", "state", "uniqueness"},
+		Rows: [][]interface{}{
+			{"index_1", "BTREE", "NODE", []string{"Person"}, []string{"name"}, "ONLINE", "NONUNIQUE"},
+			{"index_2", "BTREE", "NODE", []string{"Account"}, []string{"id"}, "ONLINE", "UNIQUE"},
+		},
+	})
+	fake4.HandleCypher(`^CALL db\.constraints\(\)$`, neoismtest.CypherResponse{
+		Columns: []string{"name", "description"},
+		Rows: [][]interface{}{
+			{"constraint_1", "CONSTRAINT ON ( account:Account ) ASSERT (account.id) IS UNIQUE"},
+			{"constraint_2", "CONSTRAINT ON ( account:Account ) ASSERT exists(account.email)"},
+		},
+	})
+	fake4.HandleCypher(`^(CREATE|DROP) `, neoismtest.CypherResponse{})
+	db4, err := Connect(fake4.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err = db4.CurrentSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, current.Indexes, 1)
+	if assert.Len(t, current.UniqueConstraints, 1) {
+		assert.Equal(t, "Account", current.UniqueConstraints[0].Label)
+		assert.Equal(t, []string{"id"}, current.UniqueConstraints[0].PropertyKeys)
+	}
+	_, err = db4.Apply(Diff(current, desired), false)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{
+		"DROP CONSTRAINT ON (x:`Account`) ASSERT x.`id` IS UNIQUE",
+		"DROP INDEX ON :`Person`(`name`)",
+		"CREATE INDEX ON :`Person`(`email`, `name`)",
+		"CREATE CONSTRAINT ON (x:`User`) ASSERT x.`login` IS UNIQUE",
+	}, ddlStatements(fake4.Statements()))
+}
+
+// ddlStatements picks the schema changes out of the statements received by a
+// fake server.
+func ddlStatements(stmts []string) []string {
+	ddl := []string{}
+	for _, s := range stmts {
+		if strings.HasPrefix(s, "CREATE ") || strings.HasPrefix(s, "DROP ") {
+			ddl = append(ddl, s)
+		}
+	}
+	return ddl
+}
@@ -5,6 +5,7 @@
 package neoism
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/stretchr/testify/assert"
@@ -12,6 +13,7 @@ import (
 	"regexp"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestCreateIndex(t *testing.T) {
@@ -74,6 +76,48 @@ func TestDropIndex(t *testing.T) {
 	assert.Equal(t, NotFound, err)
 }
 
+func TestIndexState(t *testing.T) {
+	db := connectTest(t)
+	defer cleanup(t, db)
+	if !db.Capabilities().Procedures {
+		t.Skip("Index states require procedures, i.e. Neo4j 3.0 or later")
+	}
+	defer cleanupIndexes(t, db)
+	label := rndStr(t)
+	prop0 := rndStr(t)
+	idx, err := db.CreateIndex(label, prop0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.AwaitIndexes(context.Background(), 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, err := idx.State()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, IndexOnline, state)
+	progress, err := idx.Progress()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 100.0, progress)
+	idx.Drop()
+	_, err = idx.State()
+	assert.Equal(t, NotFound, err)
+}
+
+func TestIndexStatusDescription(t *testing.T) {
+	s := indexStatus{
+		Description: "INDEX ON :`Person`(name, age)",
+		State:       "FAILED",
+	}
+	assert.Equal(t, "Person", s.label())
+	assert.Equal(t, []string{"name", "age"}, s.propertyKeys())
+	assert.Equal(t, true, s.matches(&Index{Label: "Person", PropertyKeys: []string{"name", "age"}}))
+	assert.Equal(t, false, s.matches(&Index{Label: "Person", PropertyKeys: []string{"name"}}))
+	assert.Equal(t, 0.0, s.progress())
+	_, ok := s.err().(*IndexFailedError)
+	assert.Equal(t, true, ok)
+}
+
 func cleanupIndexes(t *testing.T, db *Database) {
 	indexes, err := allIndexes(db)
 	if err != nil {
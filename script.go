@@ -0,0 +1,185 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released under
+// the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for details.
+// Resist intellectual serfdom - the ownership of ideas is akin to slavery.
+
+package neoism
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// A ScriptStatement is a single statement split from a Cypher script.
+type ScriptStatement struct {
+	Statement string
+	Line      int // Line in the script on which the statement starts
+}
+
+// A ScriptError is returned when a statement in a Cypher script fails.
+type ScriptError struct {
+	Line      int
+	Statement string
+	Err       error
+}
+
+// Error returns the error message, prefixed with the line number of the
+// failing statement.
+func (e *ScriptError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Err)
+}
+
+// SplitScript splits a Cypher script into its semicolon-separated statements.
+// Semicolons inside quoted strings, backtick-quoted identifiers, `//` line
+// comments and `/* */` block comments do not end a statement.  Comments
+// preceding a statement are dropped, while those inside it are left in place.
+func SplitScript(script string) ([]ScriptStatement, error) {
+	const (
+		code = iota
+		quoted
+		lineComment
+		blockComment
+	)
+	stmts := []ScriptStatement{}
+	state := code
+	var quote byte // Closing character of the current quoted section
+	start := 0     // Offset of first code in the current statement
+	line := 1      // Current line number
+	stmtLine := 0  // Line of first code in current statement; 0 if none yet
+	quoteLine := 0 // Line on which the current quoted section opened
+	escaped := false
+	// All significant characters are ASCII, so it is safe to scan bytes
+	// rather than runes.
+	peek := func(i int) byte {
+		if i+1 < len(script) {
+			return script[i+1]
+		}
+		return 0
+	}
+	for i := 0; i < len(script); i++ {
+		c := script[i]
+		switch state {
+		case code:
+			switch {
+			case c == '/' && peek(i) == '/':
+				state = lineComment
+				i++
+			case c == '/' && peek(i) == '*':
+				state = blockComment
+				i++
+			case c == ';':
+				if stmtLine != 0 {
+					s := strings.TrimSpace(script[start:i])
+					stmts = append(stmts, ScriptStatement{Statement: s, Line: stmtLine})
+				}
+				stmtLine = 0
+			case c == '\'' || c == '"' || c == '`':
+				state = quoted
+				quote = c
+				quoteLine = line
+				if stmtLine == 0 {
+					stmtLine = line
+					start = i
+				}
+			case c == '\n', c == ' ', c == '\t', c == '\r':
+			default:
+				if stmtLine == 0 {
+					stmtLine = line
+					start = i
+				}
+			}
+		case quoted:
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\' && quote != '`':
+				escaped = true
+			case c == quote && quote == '`' && peek(i) == '`':
+				i++ // Doubled backtick inside an identifier
+			case c == quote:
+				state = code
+			}
+		case lineComment:
+			if c == '\n' {
+				state = code
+			}
+		case blockComment:
+			if c == '*' && peek(i) == '/' {
+				state = code
+				i++
+			}
+		}
+		if c == '\n' {
+			line++
+		}
+	}
+	switch state {
+	case quoted:
+		return nil, &ScriptError{Line: quoteLine, Err: fmt.Errorf("unterminated %c", quote)}
+	case blockComment:
+		return nil, &ScriptError{Line: line, Err: errors.New("unterminated block comment")}
+	}
+	if stmtLine != 0 {
+		s := strings.TrimSpace(script[start:])
+		stmts = append(stmts, ScriptStatement{Statement: s, Line: stmtLine})
+	}
+	return stmts, nil
+}
+
+// RunScript splits a Cypher script into statements and executes them, in
+// order, inside a single transaction.  If any statement fails the transaction
+// is rolled back and a *ScriptError identifying the statement is returned.
+// Note that Neo4j does not allow schema and data changes in the same
+// transaction.
+func (db *Database) RunScript(script string) error {
+	stmts, err := SplitScript(script)
+	if err != nil {
+		return err
+	}
+	tx, err := db.Begin([]*CypherQuery{})
+	if err != nil {
+		return err
+	}
+	err = tx.runStatements(stmts)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// RunScript splits a Cypher script into statements and executes them, in
+// order, in the transaction.  If any statement fails a *ScriptError
+// identifying the statement is returned; the transaction is left open, for
+// the caller to roll back.
+func (t *Tx) RunScript(script string) error {
+	stmts, err := SplitScript(script)
+	if err != nil {
+		return err
+	}
+	return t.runStatements(stmts)
+}
+
+func (t *Tx) runStatements(stmts []ScriptStatement) error {
+	for _, s := range stmts {
+		cq := CypherQuery{Statement: s.Statement}
+		err := t.Query([]*CypherQuery{&cq})
+		if err == TxQueryError && len(t.Errors) > 0 {
+			err = &t.Errors[len(t.Errors)-1]
+		}
+		if err != nil {
+			return &ScriptError{Line: s.Line, Statement: s.Statement, Err: err}
+		}
+	}
+	return nil
+}
+
+// RunScriptFile executes the Cypher script in the named file, as RunScript.
+func (db *Database) RunScriptFile(filename string) error {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	return db.RunScript(string(b))
+}
@@ -0,0 +1,89 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released under
+// the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for details.
+// Resist intellectual serfdom - the ownership of ideas is akin to slavery.
+
+package neoism
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSplitScript(t *testing.T) {
+	script := `// Seed data; run once
+CREATE (n:Person {name: "Kirk; James T"});
+
+/* A block comment;
+   spanning lines */
+CREATE (n:Person {name: 'O\'Brien;'})
+// This is a comment; with a semicolon
+RETURN n;
+MATCH (n:` + "`Odd;``Label`" + `) RETURN n;
+;
+// Trailing comment only
+MATCH (n) RETURN count(n)`
+	stmts, err := SplitScript(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 4, len(stmts))
+	assert.Equal(t, `CREATE (n:Person {name: "Kirk; James T"})`, stmts[0].Statement)
+	assert.Equal(t, 2, stmts[0].Line)
+	assert.Equal(t, `CREATE (n:Person {name: 'O\'Brien;'})
+// This is a comment; with a semicolon
+RETURN n`, stmts[1].Statement)
+	assert.Equal(t, 6, stmts[1].Line)
+	assert.Equal(t, "MATCH (n:`Odd;``Label`) RETURN n", stmts[2].Statement)
+	assert.Equal(t, 9, stmts[2].Line)
+	assert.Equal(t, "MATCH (n) RETURN count(n)", stmts[3].Statement)
+	assert.Equal(t, 12, stmts[3].Line)
+}
+
+func TestSplitScriptUnterminated(t *testing.T) {
+	_, err := SplitScript("CREATE (n);\nCREATE (m {name: 'oops})")
+	se, ok := err.(*ScriptError)
+	if !ok {
+		t.Fatal("Expected a ScriptError")
+	}
+	assert.Equal(t, 2, se.Line)
+	_, err = SplitScript("CREATE (n) /* never closed")
+	assert.NotEqual(t, nil, err)
+}
+
+func TestRunScript(t *testing.T) {
+	db := connectTest(t)
+	defer cleanup(t, db)
+	name := rndStr(t)
+	script := `
+		// Create two people
+		CREATE (:Person {name: "` + name + `"});
+		CREATE (:Person {name: "; not a separator"});
+	`
+	err := db.RunScript(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := []struct {
+		N int `json:"count(n)"`
+	}{}
+	cq := CypherQuery{
+		Statement:  `MATCH (n:Person) WHERE n.name IN [{name}, "; not a separator"] RETURN count(n)`,
+		Parameters: Props{"name": name},
+		Result:     &res,
+	}
+	db.Cypher(&cq)
+	assert.Equal(t, 2, res[0].N)
+	// Errors carry the line number, and roll back the whole script
+	err = db.RunScript("CREATE (:Person {name: 'Spock'});\n\nCREATE (:Person {name: 'Spock'}) RETURN x;")
+	se, ok := err.(*ScriptError)
+	if !ok {
+		t.Fatal("Expected a ScriptError")
+	}
+	assert.Equal(t, 3, se.Line)
+	cq = CypherQuery{
+		Statement: `MATCH (n:Person {name: 'Spock'}) RETURN count(n)`,
+		Result:    &res,
+	}
+	db.Cypher(&cq)
+	assert.Equal(t, 0, res[0].N)
+}
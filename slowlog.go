@@ -0,0 +1,273 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released under
+// the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for details.
+// Resist intellectual serfdom - the ownership of ideas is akin to slavery.
+
+package neoism
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A SlowQuery is a query which took longer than a SlowQueryLog's threshold.
+type SlowQuery struct {
+	Time        time.Time // When execution started
+	Duration    time.Duration
+	Statement   string
+	Fingerprint string
+	Parameters  map[string]interface{} // Redacted
+	Rows        int
+	Stats       *Stats // Nil unless requested with IncludeStats
+	Err         error
+}
+
+// String describes the slow query on a single line, with its statement
+// quoted, for logging.
+func (sq SlowQuery) String() string {
+	s := fmt.Sprintf("slow query (%s): %q fingerprint=%q rows=%d", sq.Duration, sq.Statement, sq.Fingerprint, sq.Rows)
+	if sq.Stats != nil {
+		s += " stats={" + sq.Stats.summary() + "}"
+	}
+	if len(sq.Parameters) > 0 {
+		keys := make([]string, 0, len(sq.Parameters))
+		for k := range sq.Parameters {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		ps := make([]string, len(keys))
+		for i, k := range keys {
+			ps[i] = fmt.Sprintf("%s=%v", k, sq.Parameters[k])
+		}
+		s += " params={" + strings.Join(ps, " ") + "}"
+	}
+	if sq.Err != nil {
+		s += " error=" + sq.Err.Error()
+	}
+	return s
+}
+
+// summary lists the non-zero counters of s.
+func (s *Stats) summary() string {
+	counts := []struct {
+		name string
+		n    int
+	}{
+		{"nodes_created", s.NodesCreated},
+		{"nodes_deleted", s.NodesDeleted},
+		{"relationships_created", s.RelationshipsCreated},
+		{"relationship_deleted", s.RelationshipDeleted},
+		{"properties_set", s.PropertiesSet},
+		{"labels_added", s.LabelsAdded},
+		{"labels_removed", s.LabelsRemoved},
+		{"indexes_added", s.IndexesAdded},
+		{"indexes_removed", s.IndexesRemoved},
+		{"constraints_added", s.ConstraintsAdded},
+		{"constraints_removed", s.ConstraintsRemoved},
+	}
+	parts := []string{}
+	for _, c := range counts {
+		if c.n != 0 {
+			parts = append(parts, fmt.Sprintf("%s=%d", c.name, c.n))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// A SlowQueryLog is a Hook which logs queries executed by Cypher, CypherBatch,
+// Begin or Tx.Query taking longer than Threshold, and keeps the most recent
+// of them in memory:
+//
+//	sl := neoism.NewSlowQueryLog(500*time.Millisecond, 100)
+//	db.AddHook(sl)
+//	...
+//	for _, sq := range sl.Recent() { ... }
+//
+// Queries sent together in one request share that request's duration.
+type SlowQueryLog struct {
+	Threshold time.Duration
+	// SampleRate is the fraction, between 0 and 1, of slow queries which are
+	// logged and kept.  Zero means all of them.
+	SampleRate float64
+	// Logger receives a line for each slow query.  If nil, the standard
+	// logger is used.
+	Logger *log.Logger
+	// Redactor is applied to query parameters.  If nil, all parameter values
+	// are redacted, and only their names are logged.
+	Redactor Redactor
+	mu       sync.Mutex
+	ring     []SlowQuery
+	next     int
+	full     bool
+}
+
+// NewSlowQueryLog returns a SlowQueryLog which keeps the last size queries
+// slower than threshold.
+func NewSlowQueryLog(threshold time.Duration, size int) *SlowQueryLog {
+	return &SlowQueryLog{
+		Threshold: threshold,
+		ring:      make([]SlowQuery, size),
+	}
+}
+
+// Before implements Hook.
+func (l *SlowQueryLog) Before(e *Event) {}
+
+// After implements Hook.
+func (l *SlowQueryLog) After(e *Event) {
+	if e.Kind != QueryEvent || e.Duration < l.Threshold {
+		return
+	}
+	if l.SampleRate > 0 && l.SampleRate < 1 && rand.Float64() >= l.SampleRate {
+		return
+	}
+	sq := SlowQuery{
+		Time:        e.Start,
+		Duration:    e.Duration,
+		Statement:   e.Statement,
+		Fingerprint: Fingerprint(e.Statement),
+		Parameters:  l.redact(e.Parameters),
+		Err:         e.Err,
+	}
+	if q := e.Query; q != nil {
+		sq.Rows = len(q.cr.Data)
+		sq.Stats = q.stats
+	}
+	if l.Logger != nil {
+		l.Logger.Print(sq)
+	} else {
+		log.Print(sq)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.ring) == 0 {
+		return
+	}
+	l.ring[l.next] = sq
+	l.next = (l.next + 1) % len(l.ring)
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+func (l *SlowQueryLog) redact(params map[string]interface{}) map[string]interface{} {
+	m := make(map[string]interface{}, len(params))
+	for k, v := range params {
+		if l.Redactor == nil {
+			m[k] = Redacted
+		} else {
+			m[k] = l.Redactor(k, v)
+		}
+	}
+	return m
+}
+
+// Recent returns the slow queries kept in memory, oldest first.
+func (l *SlowQueryLog) Recent() []SlowQuery {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.full {
+		return append([]SlowQuery{}, l.ring[:l.next]...)
+	}
+	return append(append([]SlowQuery{}, l.ring[l.next:]...), l.ring[:l.next]...)
+}
+
+// Fingerprint normalizes a Cypher statement so that statements differing only
+// in literal values have the same fingerprint: string and number literals are
+// replaced with "?", lists of literals with "[?]", comments are removed and
+// whitespace is collapsed.
+func Fingerprint(stmt string) string {
+	var b strings.Builder
+	space := false
+	emit := func(s string) {
+		if space && b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		space = false
+		b.WriteString(s)
+	}
+	isIdent := func(c byte) bool {
+		return c == '_' || c == '$' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+	}
+	for i := 0; i < len(stmt); {
+		c := stmt[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			space = true
+			i++
+		case c == '/' && i+1 < len(stmt) && stmt[i+1] == '/':
+			for i < len(stmt) && stmt[i] != '\n' {
+				i++
+			}
+			space = true
+		case c == '/' && i+1 < len(stmt) && stmt[i+1] == '*':
+			end := strings.Index(stmt[i+2:], "*/")
+			if end < 0 {
+				i = len(stmt)
+			} else {
+				i += end + 4
+			}
+			space = true
+		case c == '\'' || c == '"':
+			i++
+			for i < len(stmt) && stmt[i] != c {
+				if stmt[i] == '\\' {
+					i++
+				}
+				i++
+			}
+			i++
+			emit("?")
+		case c == '`':
+			j := i + 1
+			for j < len(stmt) && stmt[j] != '`' {
+				j++
+			}
+			if j < len(stmt) {
+				j++
+			}
+			emit(stmt[i:j])
+			i = j
+		case c >= '0' && c <= '9' || c == '-' && i+1 < len(stmt) && stmt[i+1] >= '0' && stmt[i+1] <= '9' && !prevIsOperand(b.String()):
+			i++
+			for i < len(stmt) && (isIdent(stmt[i]) || stmt[i] == '.') {
+				i++
+			}
+			emit("?")
+		case isIdent(c):
+			j := i
+			for j < len(stmt) && isIdent(stmt[j]) {
+				j++
+			}
+			emit(stmt[i:j])
+			i = j
+		default:
+			emit(string(c))
+			i++
+		}
+	}
+	s := b.String()
+	for {
+		t := strings.Replace(s, "[?, ?", "[?", -1)
+		t = strings.Replace(t, "[?,?", "[?", -1)
+		if t == s {
+			return s
+		}
+		s = t
+	}
+}
+
+// prevIsOperand returns true if the text emitted so far ends with an operand,
+// in which case a following "-" is subtraction or a relationship dash rather
+// than a sign.
+func prevIsOperand(s string) bool {
+	if s == "" {
+		return false
+	}
+	c := s[len(s)-1]
+	return c == ')' || c == ']' || c == '?' || c == '`' || c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
@@ -0,0 +1,85 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released under
+// the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for details.
+// Resist intellectual serfdom - the ownership of ideas is akin to slavery.
+
+package neoism
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFingerprint(t *testing.T) {
+	cases := map[string]string{
+		"MATCH (n:Person {name: 'Kirk'}) RETURN n":            "MATCH (n:Person {name: ?}) RETURN n",
+		"MATCH (n)\n  WHERE n.age > 42\n  RETURN n LIMIT 10":  "MATCH (n) WHERE n.age > ? RETURN n LIMIT ?",
+		`MATCH (n) WHERE n.id IN [1, 2, 3] RETURN n`:          "MATCH (n) WHERE n.id IN [?] RETURN n",
+		`MATCH (n) WHERE n.name = "it\"s" RETURN n // note`:   "MATCH (n) WHERE n.name = ? RETURN n",
+		"MATCH (a)-[:KNOWS]->(b) RETURN a.x-1, -2.5":          "MATCH (a)-[:KNOWS]->(b) RETURN a.x-?, ?",
+		"MATCH (n1:`Odd 'Label'`) /* c */ RETURN n1, {param}": "MATCH (n1:`Odd 'Label'`) RETURN n1, {param}",
+	}
+	for stmt, want := range cases {
+		assert.Equal(t, want, Fingerprint(stmt), stmt)
+	}
+}
+
+func TestSlowQueryLog(t *testing.T) {
+	buf := &bytes.Buffer{}
+	sl := NewSlowQueryLog(100*time.Millisecond, 2)
+	sl.Logger = log.New(buf, "", 0)
+	sl.Redactor = RedactKeys("password")
+	after := func(stmt string, d time.Duration) {
+		q := &CypherQuery{
+			Statement:  stmt,
+			Parameters: map[string]interface{}{"name": "kirk", "password": "ncc-1701"},
+		}
+		q.SetResult([]string{"n"}, [][]interface{}{{1}, {2}})
+		q.stats = &Stats{NodesCreated: 2}
+		sl.After(&Event{
+			Kind:       QueryEvent,
+			Statement:  stmt,
+			Parameters: q.Parameters,
+			Query:      q,
+			Duration:   d,
+			Err:        errors.New("boom"),
+		})
+	}
+	after("MATCH (n) RETURN 1", 50*time.Millisecond) // Fast
+	after("MATCH (n) RETURN 2", 200*time.Millisecond)
+	after("MATCH (n) RETURN 3", 300*time.Millisecond)
+	after("MATCH (n) RETURN 4", 400*time.Millisecond)
+	sl.After(&Event{Kind: RequestEvent, Duration: time.Second})
+	recent := sl.Recent()
+	assert.Equal(t, 2, len(recent))
+	assert.Equal(t, "MATCH (n) RETURN 3", recent[0].Statement)
+	assert.Equal(t, "MATCH (n) RETURN 4", recent[1].Statement)
+	assert.Equal(t, "MATCH (n) RETURN ?", recent[1].Fingerprint)
+	assert.Equal(t, 2, recent[1].Rows)
+	assert.Equal(t, Redacted, recent[1].Parameters["password"])
+	assert.Equal(t, "kirk", recent[1].Parameters["name"])
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Equal(t, 3, len(lines))
+	assert.Equal(t, `slow query (400ms): "MATCH (n) RETURN 4" fingerprint="MATCH (n) RETURN ?" rows=2 stats={nodes_created=2} params={name=kirk password=[REDACTED]} error=boom`, lines[2])
+	//
+	// Without a Redactor, all values are redacted
+	//
+	sl.Redactor = nil
+	after("MATCH (n) RETURN 5", time.Second)
+	assert.Equal(t, Redacted, sl.Recent()[1].Parameters["name"])
+	//
+	// Sampling
+	//
+	sl = NewSlowQueryLog(0, 10)
+	sl.Logger = log.New(&bytes.Buffer{}, "", 0)
+	sl.SampleRate = 0.000001
+	for i := 0; i < 10; i++ {
+		sl.After(&Event{Kind: QueryEvent, Statement: "MATCH (n) RETURN n"})
+	}
+	assert.True(t, len(sl.Recent()) < 10)
+}
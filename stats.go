@@ -0,0 +1,232 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released under
+// the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for details.
+// Resist intellectual serfdom - the ownership of ideas is akin to slavery.
+
+package neoism
+
+import (
+	"expvar"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Endpoints by which client statistics are broken down.
+const (
+	EndpointNode         = "node"
+	EndpointRelationship = "relationship"
+	EndpointCypher       = "cypher"
+	EndpointBatch        = "batch"
+	EndpointTransaction  = "transaction"
+	EndpointSchema       = "schema"
+	EndpointIndex        = "index"
+	EndpointOther        = "other"
+)
+
+// LatencyBuckets are the upper bounds of the buckets of latency histograms.
+var LatencyBuckets = []time.Duration{
+	time.Millisecond,
+	2 * time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// A Histogram counts observed latencies.  Counts[i] is the number of
+// observations no greater than LatencyBuckets[i] and greater than the
+// previous bucket; the last element counts observations greater than all
+// buckets.
+type Histogram struct {
+	Counts []int64
+	Count  int64
+	Sum    time.Duration
+}
+
+func (h *Histogram) observe(d time.Duration) {
+	if h.Counts == nil {
+		h.Counts = make([]int64, len(LatencyBuckets)+1)
+	}
+	i := 0
+	for i < len(LatencyBuckets) && d > LatencyBuckets[i] {
+		i++
+	}
+	h.Counts[i]++
+	h.Count++
+	h.Sum += d
+}
+
+// ClientStats is a snapshot of a Database's client-side statistics.
+type ClientStats struct {
+	Requests         map[string]int64     // Requests sent, by endpoint
+	Errors           map[string]int64     // Requests failing without a response, by endpoint
+	Statuses         map[int]int64        // Responses received, by status code
+	Latency          map[string]Histogram // Request latency, by endpoint
+	BytesSent        int64
+	BytesReceived    int64
+	OpenTransactions int64
+	Commits          int64
+	Rollbacks        int64
+	Retries          int64
+}
+
+// statsCollector accumulates the statistics of a Database and its copies.
+type statsCollector struct {
+	mu sync.Mutex
+	s  ClientStats
+}
+
+func newStatsCollector() *statsCollector {
+	return &statsCollector{
+		s: ClientStats{
+			Requests: map[string]int64{},
+			Errors:   map[string]int64{},
+			Statuses: map[int]int64{},
+			Latency:  map[string]Histogram{},
+		},
+	}
+}
+
+// endpoint classifies a REST API URL path.
+func endpoint(path string) string {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	for i, p := range parts {
+		if p == "db" && i+2 < len(parts) && parts[i+2] == "tx" {
+			return EndpointTransaction // Neo4j 4.0 and later: /db/{name}/tx
+		}
+		if p != "data" || i+1 == len(parts) {
+			continue
+		}
+		switch parts[i+1] {
+		case "node", "label", "labels":
+			return EndpointNode
+		case "relationship":
+			return EndpointRelationship
+		case "cypher":
+			return EndpointCypher
+		case "batch":
+			return EndpointBatch
+		case "transaction":
+			return EndpointTransaction
+		case "schema":
+			return EndpointSchema
+		case "index":
+			return EndpointIndex
+		}
+	}
+	return EndpointOther
+}
+
+// request records a request and its outcome.  It wraps the response body so
+// the bytes read from it are counted.
+func (c *statsCollector) request(req *http.Request, resp *http.Response, err error, d time.Duration) {
+	if c == nil {
+		return
+	}
+	ep := endpoint(req.URL.Path)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.s.Requests[ep]++
+	if req.ContentLength > 0 {
+		c.s.BytesSent += req.ContentLength
+	}
+	h := c.s.Latency[ep]
+	h.observe(d)
+	c.s.Latency[ep] = h
+	if err != nil {
+		c.s.Errors[ep]++
+		return
+	}
+	c.s.Statuses[resp.StatusCode]++
+	if resp.Body != nil {
+		resp.Body = &countingBody{ReadCloser: resp.Body, c: c}
+	}
+}
+
+// A countingBody counts the bytes read from a response body.
+type countingBody struct {
+	io.ReadCloser
+	c *statsCollector
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.c.mu.Lock()
+	b.c.s.BytesReceived += int64(n)
+	b.c.mu.Unlock()
+	return n, err
+}
+
+// add applies f to the statistics.
+func (c *statsCollector) add(f func(s *ClientStats)) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f(&c.s)
+}
+
+// txBegun records the opening of a transaction.
+func (c *statsCollector) txBegun() {
+	c.add(func(s *ClientStats) { s.OpenTransactions++ })
+}
+
+// txClosed records the end of a transaction.
+func (c *statsCollector) txClosed(committed bool) {
+	c.add(func(s *ClientStats) {
+		s.OpenTransactions--
+		if committed {
+			s.Commits++
+		} else {
+			s.Rollbacks++
+		}
+	})
+}
+
+// Stats returns a snapshot of the database's client-side statistics.
+func (db *Database) Stats() ClientStats {
+	c := db.stats
+	if c == nil {
+		return ClientStats{}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.s
+	s.Requests = map[string]int64{}
+	for k, v := range c.s.Requests {
+		s.Requests[k] = v
+	}
+	s.Errors = map[string]int64{}
+	for k, v := range c.s.Errors {
+		s.Errors[k] = v
+	}
+	s.Statuses = map[int]int64{}
+	for k, v := range c.s.Statuses {
+		s.Statuses[k] = v
+	}
+	s.Latency = map[string]Histogram{}
+	for k, v := range c.s.Latency {
+		v.Counts = append([]int64{}, v.Counts...)
+		s.Latency[k] = v
+	}
+	return s
+}
+
+// PublishExpvar publishes the database's statistics as the expvar variable
+// name, e.g. for /debug/vars.  Like expvar.Publish, it panics if name is
+// already in use.
+func (db *Database) PublishExpvar(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return db.Stats()
+	}))
+}
@@ -0,0 +1,92 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released under
+// the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for details.
+// Resist intellectual serfdom - the ownership of ideas is akin to slavery.
+
+package neoism
+
+import (
+	"encoding/json"
+	"expvar"
+	"testing"
+	"time"
+
+	"github.com/jmcvetta/neoism/neoismtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEndpoint(t *testing.T) {
+	cases := map[string]string{
+		"/db/data/node/12/relationships/all": EndpointNode,
+		"/db/data/label/Person/nodes":        EndpointNode,
+		"/db/data/relationship/types":        EndpointRelationship,
+		"/db/data/cypher":                    EndpointCypher,
+		"/db/data/batch":                     EndpointBatch,
+		"/db/data/transaction/3/commit":      EndpointTransaction,
+		"/db/neo4j/tx/3/commit":              EndpointTransaction,
+		"/db/data/schema/index/Person":       EndpointSchema,
+		"/db/data/index/node/people":         EndpointIndex,
+		"/db/data/propertykeys":              EndpointOther,
+		"/":                                  EndpointOther,
+	}
+	for path, want := range cases {
+		assert.Equal(t, want, endpoint(path), path)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	h := Histogram{}
+	h.observe(500 * time.Microsecond)
+	h.observe(3 * time.Millisecond)
+	h.observe(time.Minute)
+	assert.Equal(t, int64(3), h.Count)
+	assert.Equal(t, int64(1), h.Counts[0])
+	assert.Equal(t, int64(1), h.Counts[2])
+	assert.Equal(t, int64(1), h.Counts[len(LatencyBuckets)])
+}
+
+func TestStats(t *testing.T) {
+	fake := neoismtest.NewServer()
+	defer fake.Close()
+	db, err := Connect(fake.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := db.Stats()
+	_, err = db.CreateNode(Props{"name": "Kirk"})
+	assert.Nil(t, err)
+	_, err = db.Node(999999)
+	assert.Equal(t, NotFound, err)
+	tx, err := db.Begin([]*CypherQuery{})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), db.Stats().OpenTransactions)
+	assert.Nil(t, tx.Commit())
+	tx, err = db.Begin([]*CypherQuery{})
+	assert.Nil(t, err)
+	assert.Nil(t, tx.Rollback())
+	tx, err = db.Begin([]*CypherQuery{})
+	assert.Nil(t, err)
+	assert.Equal(t, TxQueryError, tx.Query([]*CypherQuery{{Statement: "NOT SCRIPTED"}}))
+	s := db.Stats()
+	assert.Equal(t, before.Requests[EndpointNode]+2, s.Requests[EndpointNode])
+	assert.Equal(t, int64(6), s.Requests[EndpointTransaction])
+	assert.Equal(t, int64(1), s.Statuses[404])
+	assert.Equal(t, int64(0), s.OpenTransactions)
+	assert.Equal(t, int64(1), s.Commits)
+	assert.Equal(t, int64(2), s.Rollbacks) // Explicit, and on failed statement
+	assert.Equal(t, int64(2), s.Latency[EndpointNode].Count-before.Latency[EndpointNode].Count)
+	assert.True(t, s.BytesSent > 0)
+	assert.True(t, s.BytesReceived > before.BytesReceived)
+	//
+	// Snapshots are independent of later requests
+	//
+	db.Node(999999)
+	assert.Equal(t, int64(1), s.Statuses[404])
+	//
+	// expvar
+	//
+	db.PublishExpvar("neoism_test")
+	v := ClientStats{}
+	err = json.Unmarshal([]byte(expvar.Get("neoism_test").String()), &v)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), v.Statuses[404])
+}
@@ -7,6 +7,7 @@ package neoism
 import (
 	"encoding/json"
 	"errors"
+	"sync"
 )
 
 // A Tx is an in-progress database transaction.
@@ -16,10 +17,54 @@ type Tx struct {
 	Location   string
 	Errors     []TxError
 	Expires    string // Cannot unmarshall into time.Time :(
+	// Mode is AccessRead for transactions begun through a read-only
+	// Database, and may be set to AccessRead to refuse writes for the rest of
+	// the transaction.  Otherwise, each query's AccessMode applies.
+	Mode   AccessMode
+	mu     sync.Mutex // Guards closed
+	closed bool
+	conn   *boltConn // Reserved for the transaction, when using Bolt
+}
+
+// end marks the transaction as committed or rolled back, and reports whether
+// it was still open.
+func (t *Tx) end() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	open := !t.closed
+	t.closed = true
+	return open
+}
+
+// ended reports whether the transaction has been committed or rolled back.
+func (t *Tx) ended() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.closed
 }
 
 type txRequest struct {
-	Statements []*CypherQuery `json:"statements"`
+	Statements []txStatement `json:"statements"`
+}
+
+type txStatement struct {
+	Statement    string                 `json:"statement"`
+	Parameters   map[string]interface{} `json:"parameters"`
+	IncludeStats bool                   `json:"includeStats"`
+}
+
+// newTxRequest builds the payload for executing qs on the transactional
+// endpoint.
+func newTxRequest(qs []*CypherQuery) txRequest {
+	stmts := make([]txStatement, len(qs))
+	for i, q := range qs {
+		stmts[i] = txStatement{
+			Statement:    q.statement(),
+			Parameters:   q.Parameters,
+			IncludeStats: q.IncludeStats,
+		}
+	}
+	return txRequest{Statements: stmts}
 }
 
 type txResponse struct {
@@ -30,11 +75,15 @@ type txResponse struct {
 			Row []*json.RawMessage
 		}
 		Stats *Stats
+		Plan  *struct {
+			Root *Plan
+		}
 	}
 	Transaction struct {
 		Expires string
 	}
-	Errors []TxError
+	Errors        []TxError
+	Notifications []Notification
 }
 
 // unmarshal populates a slice of CypherQuery object with result data returned
@@ -66,14 +115,39 @@ func (tr *txResponse) unmarshal(qs []*CypherQuery) error {
 			}
 		}
 		q.stats = cr.Stats
+		if res.Plan != nil {
+			q.plan = res.Plan.Root
+		}
+		q.notifications = tr.Notifications
 	}
 	return nil
 }
 
 // Begin opens a new transaction, executing zero or more cypher queries
-// inside the transaction.
+// inside the transaction.  If the results cannot be decoded, or the
+// NotificationHandler rejects a query, the transaction is rolled back and
+// only the error is returned.
 func (db *Database) Begin(qs []*CypherQuery) (*Tx, error) {
-	payload := txRequest{Statements: qs}
+	var t *Tx
+	mode := db.accessMode()
+	err := db.observeQueries(qs, func() error {
+		return checkAccess(qs, mode, func() error {
+			var err error
+			t, err = db.begin(qs)
+			if t != nil {
+				t.Mode = mode
+			}
+			return err
+		})
+	})
+	return t, err
+}
+
+func (db *Database) begin(qs []*CypherQuery) (*Tx, error) {
+	if db.bolt != nil {
+		return db.boltBegin(qs)
+	}
+	payload := newTxRequest(qs)
 	result := txResponse{}
 	ne := NeoError{}
 	resp, err := db.Session.Post(db.HrefTransaction, payload, &result, &ne)
@@ -83,7 +157,7 @@ func (db *Database) Begin(qs []*CypherQuery) (*Tx, error) {
 	if resp.Status() != 201 {
 		return nil, ne
 	}
-	t := Tx{
+	t := &Tx{
 		db:         db,
 		hrefCommit: result.Commit,
 		Location:   resp.HttpResponse().Header.Get("Location"),
@@ -91,13 +165,43 @@ func (db *Database) Begin(qs []*CypherQuery) (*Tx, error) {
 		Expires:    result.Transaction.Expires,
 	}
 	if len(t.Errors) != 0 {
-		return &t, TxQueryError
+		// The server rolls back a transaction when a statement fails.
+		t.end()
+		return t, TxQueryError
+	}
+	db.txOpened(t)
+	err = result.unmarshal(qs)
+	if err == nil {
+		err = db.handleNotifications(qs)
+	}
+	if err != nil {
+		t.Rollback()
+		return nil, err
+	}
+	return t, nil
+}
+
+// cypherTx executes queries in a transaction of their own, using the
+// transactional endpoint's commit-immediately URL.
+func (db *Database) cypherTx(qs ...*CypherQuery) error {
+	payload := newTxRequest(qs)
+	result := txResponse{}
+	ne := NeoError{}
+	resp, err := db.Session.Post(join(db.HrefTransaction, "commit"), payload, &result, &ne)
+	if err != nil {
+		return err
+	}
+	if resp.Status() != 200 {
+		return ne
+	}
+	if len(result.Errors) != 0 {
+		return &result.Errors[0]
 	}
 	err = result.unmarshal(qs)
 	if err != nil {
-		return &t, err
+		return err
 	}
-	return &t, err
+	return db.handleNotifications(qs)
 }
 
 // Commit commits an open transaction.
@@ -105,20 +209,42 @@ func (t *Tx) Commit() error {
 	if len(t.Errors) > 0 {
 		return TxQueryError
 	}
+	if t.db.bolt != nil {
+		return t.boltEnd(true)
+	}
 	ne := NeoError{}
 	resp, err := t.db.Session.Post(t.hrefCommit, nil, nil, &ne)
 	if err != nil {
 		return err
 	}
+	if resp.Status() == 404 {
+		// The transaction has expired, or was rolled back.
+		t.db.txEnded(t, false)
+		return NotFound
+	}
 	if resp.Status() != 200 {
 		return ne
 	}
+	t.db.txEnded(t, true)
 	return nil // Success
 }
 
-// Query executes statements in an open transaction.
+// Query executes statements in an open transaction.  If the results cannot
+// be decoded, or the NotificationHandler rejects a query, the transaction is
+// rolled back.
 func (t *Tx) Query(qs []*CypherQuery) error {
-	payload := txRequest{Statements: qs}
+	return t.db.observeQueries(qs, func() error {
+		return checkAccess(qs, t.Mode, func() error {
+			return t.query(qs)
+		})
+	})
+}
+
+func (t *Tx) query(qs []*CypherQuery) error {
+	if t.db.bolt != nil {
+		return t.boltQuery(qs)
+	}
+	payload := newTxRequest(qs)
 	result := txResponse{}
 	ne := NeoError{}
 	resp, err := t.db.Session.Post(t.Location, payload, &result, &ne)
@@ -126,6 +252,8 @@ func (t *Tx) Query(qs []*CypherQuery) error {
 		return err
 	}
 	if resp.Status() == 404 {
+		// The transaction has expired, or was rolled back.
+		t.db.txEnded(t, false)
 		return NotFound
 	}
 	if resp.Status() != 200 {
@@ -133,11 +261,18 @@ func (t *Tx) Query(qs []*CypherQuery) error {
 	}
 	t.Expires = result.Transaction.Expires
 	t.Errors = append(t.Errors, result.Errors...)
+	if len(result.Errors) != 0 {
+		t.db.txEnded(t, false)
+	}
 	if len(t.Errors) != 0 {
 		return TxQueryError
 	}
 	err = result.unmarshal(qs)
+	if err == nil {
+		err = t.db.handleNotifications(qs)
+	}
 	if err != nil {
+		t.Rollback()
 		return err
 	}
 	return nil
@@ -145,13 +280,22 @@ func (t *Tx) Query(qs []*CypherQuery) error {
 
 // Rollback rolls back an open transaction.
 func (t *Tx) Rollback() error {
+	if t.db.bolt != nil {
+		return t.boltEnd(false)
+	}
 	ne := NeoError{}
 	resp, err := t.db.Session.Delete(t.Location, nil, nil, &ne)
 	if err != nil {
 		return err
 	}
+	if resp.Status() == 404 {
+		// The transaction has expired, or was rolled back.
+		t.db.txEnded(t, false)
+		return NotFound
+	}
 	if resp.Status() != 200 {
 		return ne
 	}
+	t.db.txEnded(t, false)
 	return nil // Success
 }
@@ -0,0 +1,174 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released under
+// the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for details.
+// Resist intellectual serfdom - the ownership of ideas is akin to slavery.
+
+package neoism
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"gopkg.in/jmcvetta/napping.v3"
+)
+
+// newDatabase returns an unconnected Database whose session sends requests
+// with client, or a default client if client is nil.  All requests go through
+// a transport owned by the Database, which calls its hooks, collects its
+// statistics, retries failed requests, applies its circuit breaker and
+// limiter, tracks in-flight requests for Close, and authenticates requests.
+func newDatabase(client *http.Client) *Database {
+	h := http.Header{}
+	h.Add("User-Agent", "neoism")
+	c := http.Client{}
+	if client != nil {
+		c = *client
+	}
+	t := &transport{
+		next:  c.Transport,
+		hooks: &hookSet{},
+		stats: newStatsCollector(),
+		retry: &retryState{policy: DefaultRetryPolicy()},
+		guard: &guardState{},
+		life:  newLifecycle(),
+		auth:  &authState{},
+	}
+	if t.next == nil {
+		t.next = http.DefaultTransport
+	}
+	c.Transport = t
+	return &Database{
+		Session: &napping.Session{
+			Header: &h,
+			Client: &c,
+		},
+		hooks: t.hooks,
+		stats: t.stats,
+		retry: t.retry,
+		guard: t.guard,
+		life:  t.life,
+		auth:  t.auth,
+	}
+}
+
+// A transport is the http.RoundTripper through which a Database sends
+// requests.
+type transport struct {
+	next  http.RoundTripper
+	hooks *hookSet
+	stats *statsCollector
+	retry *retryState
+	guard *guardState
+	life  *lifecycle
+	auth  *authState
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	err := t.life.enter(req)
+	if err != nil {
+		return nil, err
+	}
+	defer t.life.exit()
+	req, used, err := t.auth.authorize(req)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.retryLoop(req)
+	if err != nil || resp.StatusCode != 401 || !t.auth.renew(used) {
+		return resp, err
+	}
+	// The credentials were refused, but have since been rotated.
+	if req.Body != nil && req.GetBody == nil {
+		return resp, err
+	}
+	next := req.Clone(req.Context())
+	if req.GetBody != nil {
+		next.Body, err = req.GetBody()
+		if err != nil {
+			return resp, nil
+		}
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	next, _, err = t.auth.authorize(next)
+	if err != nil {
+		return nil, err
+	}
+	return t.retryLoop(next)
+}
+
+// retryLoop sends req, retrying it as the retry policy allows.
+func (t *transport) retryLoop(req *http.Request) (*http.Response, error) {
+	policy := t.retry.get()
+	for attempt := 1; ; attempt++ {
+		resp, err := t.guard.roundTrip(req, t.send)
+		if !policy.shouldRetry(req, resp, err, attempt) {
+			return resp, err
+		}
+		next := policy.wait(req, resp, attempt)
+		if next == nil {
+			return resp, err
+		}
+		t.stats.add(func(s *ClientStats) { s.Retries++ })
+		req = next
+	}
+}
+
+// CloseIdleConnections closes idle connections of the underlying transport,
+// if it supports doing so.
+func (t *transport) CloseIdleConnections() {
+	type closeIdler interface {
+		CloseIdleConnections()
+	}
+	if c, ok := t.next.(closeIdler); ok {
+		c.CloseIdleConnections()
+	}
+}
+
+// send sends req, recording it in the statistics.
+func (t *transport) send(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.observe(req)
+	t.stats.request(req, resp, err, time.Since(start))
+	return resp, err
+}
+
+// credentialHeaders are withheld from hooks.
+var credentialHeaders = []string{"Authorization", "Proxy-Authorization"}
+
+// observe sends req, calling hooks around it.  Hooks see a copy of its
+// header without credentials; headers they set are copied back.
+func (t *transport) observe(req *http.Request) (*http.Response, error) {
+	hooks := t.hooks.list()
+	if len(hooks) == 0 {
+		return t.next.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	u := *req.URL
+	u.User = nil
+	header := req.Header.Clone()
+	for _, k := range credentialHeaders {
+		header.Del(k)
+	}
+	e := &Event{
+		Kind:   RequestEvent,
+		Method: req.Method,
+		URL:    u.String(),
+		Header: header,
+	}
+	var resp *http.Response
+	observe(hooks, e, func() error {
+		for k, v := range e.Header {
+			req.Header[k] = v
+		}
+		var err error
+		resp, err = t.next.RoundTrip(req)
+		if resp != nil {
+			e.Status = resp.StatusCode
+		}
+		return err
+	})
+	return resp, e.Err
+}
@@ -0,0 +1,219 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released under
+// the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for details.
+// Resist intellectual serfdom - the ownership of ideas is akin to slavery.
+
+package neoism
+
+// A User is a user account on the server.
+type User struct {
+	Username               string   `json:"username"`
+	PasswordChangeRequired bool     `json:"password_change_required"`
+	Roles                  []string `json:"roles"` // Enterprise edition only
+	Flags                  []string `json:"flags"` // e.g. "is_suspended"
+}
+
+// User fetches the status of a user.  The user REST API of Neo4j 2.2 to 3.x
+// lets users see only themselves; on other servers, the user is looked up
+// with Users, and administrators may see any user.
+func (db *Database) User(name string) (*User, error) {
+	if !db.Capabilities().UserAPI {
+		users, err := db.Users()
+		if err != nil {
+			return nil, err
+		}
+		for _, u := range users {
+			if u.Username == name {
+				return u, nil
+			}
+		}
+		return nil, NotFound
+	}
+	u := User{}
+	ar := authResponse{}
+	resp, err := db.Session.Get(db.hrefUser(name), nil, &u, &ar)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.Status() {
+	case 200:
+		return &u, nil
+	case 404:
+		return nil, NotFound
+	}
+	return nil, ar.err()
+}
+
+// security returns the database on which to call security procedures, or on
+// Neo4j 4.0 and later, which replace them, run administration commands.
+func (db *Database) security() (*Database, error) {
+	err := db.require(db.ServerVersion().AtLeast(3, 1, 0), "Security procedures")
+	if err != nil {
+		return nil, err
+	}
+	if db.Capabilities().MultiDatabase {
+		return db.Use("system")
+	}
+	return db, nil
+}
+
+// callSecurity calls a security procedure.
+func (db *Database) callSecurity(cq *CypherQuery) error {
+	sdb, err := db.security()
+	if err != nil {
+		return err
+	}
+	return sdb.Cypher(cq)
+}
+
+// A userRow is a row of SHOW USERS.  Only the enterprise edition reports
+// roles and suspension.
+type userRow struct {
+	User                   string   `json:"user"`
+	Roles                  []string `json:"roles"`
+	PasswordChangeRequired bool     `json:"passwordChangeRequired"`
+	Suspended              bool     `json:"suspended"`
+}
+
+// Users lists all users, using the dbms.security.listUsers() procedure of
+// Neo4j 3.1 to 3.5, or SHOW USERS on Neo4j 4.0 and later.
+func (db *Database) Users() ([]*User, error) {
+	if db.Capabilities().MultiDatabase {
+		return db.showUsers()
+	}
+	users := []*User{}
+	cq := CypherQuery{
+		Statement: "CALL dbms.security.listUsers()",
+		Result:    &users,
+	}
+	err := db.callSecurity(&cq)
+	if err != nil {
+		return nil, err
+	}
+	for _, u := range users {
+		for _, f := range u.Flags {
+			if f == "password_change_required" {
+				u.PasswordChangeRequired = true
+			}
+		}
+	}
+	return users, nil
+}
+
+// showUsers lists all users with SHOW USERS.
+func (db *Database) showUsers() ([]*User, error) {
+	rows := []userRow{}
+	cq := CypherQuery{Statement: "SHOW USERS", Result: &rows}
+	err := db.callSecurity(&cq)
+	if err != nil {
+		return nil, err
+	}
+	users := make([]*User, len(rows))
+	for i, r := range rows {
+		users[i] = &User{
+			Username:               r.User,
+			PasswordChangeRequired: r.PasswordChangeRequired,
+			Roles:                  r.Roles,
+		}
+		if r.Suspended {
+			users[i].Flags = []string{"is_suspended"}
+		}
+	}
+	return users, nil
+}
+
+// CreateUser creates a user.  If requirePasswordChange is true, the user
+// must change their password before doing anything else.
+func (db *Database) CreateUser(name, password string, requirePasswordChange bool) error {
+	if db.Capabilities().MultiDatabase {
+		change := "CHANGE REQUIRED"
+		if !requirePasswordChange {
+			change = "CHANGE NOT REQUIRED"
+		}
+		return db.callSecurity(&CypherQuery{
+			Statement:  "CREATE USER " + quoteIdentifier(name) + " SET PASSWORD $password " + change,
+			Parameters: map[string]interface{}{"password": password},
+			secret:     true,
+		})
+	}
+	cq := CypherQuery{
+		Statement: "CALL dbms.security.createUser($username, $password, $requirePasswordChange)",
+		Parameters: map[string]interface{}{
+			"username":              name,
+			"password":              password,
+			"requirePasswordChange": requirePasswordChange,
+		},
+		secret: true,
+	}
+	return db.callSecurity(&cq)
+}
+
+// DeleteUser deletes a user.
+func (db *Database) DeleteUser(name string) error {
+	if db.Capabilities().MultiDatabase {
+		return db.callSecurity(&CypherQuery{Statement: "DROP USER " + quoteIdentifier(name)})
+	}
+	cq := CypherQuery{
+		Statement:  "CALL dbms.security.deleteUser($username)",
+		Parameters: map[string]interface{}{"username": name},
+	}
+	return db.callSecurity(&cq)
+}
+
+// Roles lists all roles.  Roles are only supported by the enterprise edition.
+func (db *Database) Roles() ([]string, error) {
+	res := []struct {
+		Role string `json:"role"`
+	}{}
+	cq := CypherQuery{
+		Statement: "CALL dbms.security.listRoles() YIELD role RETURN role",
+		Result:    &res,
+	}
+	if db.Capabilities().MultiDatabase {
+		cq.Statement = "SHOW ROLES"
+	}
+	err := db.callSecurity(&cq)
+	if err != nil {
+		return nil, err
+	}
+	roles := make([]string, len(res))
+	for i, r := range res {
+		roles[i] = r.Role
+	}
+	return roles, nil
+}
+
+// AddUserRole grants a role to a user.  Roles are only supported by the
+// enterprise edition.
+func (db *Database) AddUserRole(name, role string) error {
+	if db.Capabilities().MultiDatabase {
+		return db.callSecurity(&CypherQuery{
+			Statement: "GRANT ROLE " + quoteIdentifier(role) + " TO " + quoteIdentifier(name),
+		})
+	}
+	cq := CypherQuery{
+		Statement: "CALL dbms.security.addRoleToUser($role, $username)",
+		Parameters: map[string]interface{}{
+			"role":     role,
+			"username": name,
+		},
+	}
+	return db.callSecurity(&cq)
+}
+
+// RemoveUserRole revokes a role from a user.  Roles are only supported by the
+// enterprise edition.
+func (db *Database) RemoveUserRole(name, role string) error {
+	if db.Capabilities().MultiDatabase {
+		return db.callSecurity(&CypherQuery{
+			Statement: "REVOKE ROLE " + quoteIdentifier(role) + " FROM " + quoteIdentifier(name),
+		})
+	}
+	cq := CypherQuery{
+		Statement: "CALL dbms.security.removeRoleFromUser($role, $username)",
+		Parameters: map[string]interface{}{
+			"role":     role,
+			"username": name,
+		},
+	}
+	return db.callSecurity(&cq)
+}
@@ -0,0 +1,108 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released under
+// the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for details.
+// Resist intellectual serfdom - the ownership of ideas is akin to slavery.
+
+package neoism
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// A ServerVersion is the parsed version of a Neo4j server.
+type ServerVersion struct {
+	Major      int
+	Minor      int
+	Patch      int
+	PreRelease string // e.g. "M05" or "RC1"; blank for final releases
+	Edition    string // "community" or "enterprise", if the server reports it
+}
+
+var versionRegex = regexp.MustCompile(`^(\d+)\.(\d+)(?:\.(\d+))?(?:[.-]?(.+))?$`)
+
+// ParseVersion parses a version as reported by Neo4j, e.g. "3.5.0",
+// "2.0.0-M05", "1.8.M07" or "5.13-aura".
+func ParseVersion(s string) (ServerVersion, error) {
+	v := ServerVersion{}
+	m := versionRegex.FindStringSubmatch(s)
+	if m == nil {
+		return v, fmt.Errorf("Invalid Neo4j version %q", s)
+	}
+	v.Major, _ = strconv.Atoi(m[1])
+	v.Minor, _ = strconv.Atoi(m[2])
+	if m[3] != "" {
+		v.Patch, _ = strconv.Atoi(m[3])
+	}
+	v.PreRelease = m[4]
+	return v, nil
+}
+
+// String returns the version in Neo4j's format, without the edition.
+func (v ServerVersion) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if v.PreRelease != "" {
+		s += "-" + v.PreRelease
+	}
+	return s
+}
+
+// AtLeast reports whether v is major.minor.patch or later.  Pre-releases are
+// counted as the release they precede, since features are usually present in
+// its milestones already.
+func (v ServerVersion) AtLeast(major, minor, patch int) bool {
+	if v.Major != major {
+		return v.Major > major
+	}
+	if v.Minor != minor {
+		return v.Minor > minor
+	}
+	return v.Patch >= patch
+}
+
+// ServerVersion returns the parsed version of the server.  If the server
+// reported a version neoism cannot parse, the zero ServerVersion is returned.
+func (db *Database) ServerVersion() ServerVersion {
+	v, _ := ParseVersion(db.Version)
+	v.Edition = db.Edition
+	return v
+}
+
+// Capabilities describes the APIs and features a server provides.
+type Capabilities struct {
+	REST          bool // Node, relationship and legacy index REST API
+	Transactions  bool // Transactional endpoint, or transactions over Bolt
+	SchemaREST    bool // Schema index and constraint REST API
+	Labels        bool // Node labels
+	Streaming     bool // Results are streamed rather than buffered
+	Procedures    bool // Procedures, e.g. CALL db.indexes()
+	MultiDatabase bool // Several databases on one server; see Use
+	Bolt          bool // Connected using the Bolt protocol
+	UserAPI       bool // User REST API, for password changes; Neo4j 2.2 to 3.x
+}
+
+// Capabilities returns the capabilities of the server, derived from its
+// version and from the service root it returned when connecting.
+func (db *Database) Capabilities() Capabilities {
+	v := db.ServerVersion()
+	c := Capabilities{
+		REST:          db.HrefNode != "",
+		Transactions:  db.HrefTransaction != "" || db.bolt != nil,
+		Labels:        v.AtLeast(2, 0, 0),
+		Streaming:     v.AtLeast(1, 8, 0) || db.bolt != nil,
+		Procedures:    v.AtLeast(3, 0, 0),
+		MultiDatabase: db.hrefTxTemplate != "" || db.bolt != nil && db.bolt.version >= 4,
+		Bolt:          db.bolt != nil,
+	}
+	c.SchemaREST = c.REST && c.Labels
+	c.UserAPI = c.REST && v.AtLeast(2, 2, 0)
+	return c
+}
+
+// require returns an *UnsupportedError for feature unless ok.
+func (db *Database) require(ok bool, feature string) error {
+	if !ok {
+		return &UnsupportedError{Feature: feature, Version: db.Version}
+	}
+	return nil
+}
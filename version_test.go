@@ -0,0 +1,73 @@
+// Copyright (c) 2012-2013 Jason McVetta.  This is Free Software, released under
+// the terms of the GPL v3.  See http://www.gnu.org/copyleft/gpl.html for details.
+// Resist intellectual serfdom - the ownership of ideas is akin to slavery.
+
+package neoism
+
+import (
+	"testing"
+
+	"github.com/jmcvetta/neoism/neoismtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseVersion(t *testing.T) {
+	cases := map[string]ServerVersion{
+		"3.5.0":     {Major: 3, Minor: 5},
+		"4.4.12":    {Major: 4, Minor: 4, Patch: 12},
+		"2.0.0-M05": {Major: 2, PreRelease: "M05"},
+		"1.8.M07":   {Major: 1, Minor: 8, PreRelease: "M07"},
+		"2.2.0-RC1": {Major: 2, Minor: 2, PreRelease: "RC1"},
+		"5.13-aura": {Major: 5, Minor: 13, PreRelease: "aura"},
+	}
+	for s, exp := range cases {
+		v, err := ParseVersion(s)
+		assert.Nil(t, err, s)
+		assert.Equal(t, exp, v, s)
+	}
+	_, err := ParseVersion("unknown")
+	assert.NotNil(t, err)
+	v, _ := ParseVersion("1.8.M07")
+	assert.Equal(t, "1.8.0-M07", v.String())
+	v, _ = ParseVersion("3.5.2")
+	assert.True(t, v.AtLeast(3, 5, 2))
+	assert.True(t, v.AtLeast(3, 0, 9))
+	assert.True(t, v.AtLeast(2, 9, 9))
+	assert.False(t, v.AtLeast(3, 5, 3))
+	assert.False(t, v.AtLeast(4, 0, 0))
+}
+
+func TestCapabilities(t *testing.T) {
+	fake := neoismtest.NewServer()
+	defer fake.Close()
+	db, err := Connect(fake.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, ServerVersion{Major: 3, Minor: 5}, db.ServerVersion())
+	assert.Equal(t, Capabilities{
+		REST:         true,
+		Transactions: true,
+		SchemaREST:   true,
+		Labels:       true,
+		Streaming:    true,
+		Procedures:   true,
+		UserAPI:      true,
+	}, db.Capabilities())
+	fake4 := neoismtest.NewServerVersion("4.4.0")
+	defer fake4.Close()
+	db, err = Connect(fake4.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "community", db.ServerVersion().Edition)
+	assert.Equal(t, Capabilities{
+		Transactions:  true,
+		Labels:        true,
+		Streaming:     true,
+		Procedures:    true,
+		MultiDatabase: true,
+	}, db.Capabilities())
+	_, err = db.Indexes("")
+	assert.IsType(t, &UnsupportedError{}, err)
+}